@@ -2,53 +2,91 @@ package infrastructure
 
 import (
 	"errors"
+	"sync"
 )
 
 const PoolSize = 4 // Tiny size to facilitate testing
 
+const PrefetchSize = 8 // Pages that can be read ahead at a time
+
+// AccessHint tells the buffer pool how a fetched page is going to be used, so that pages which are unlikely to be
+// used again are evicted before the working set
+type AccessHint int
+
+const (
+	AccessNormal     AccessHint = iota // The page may be used again, e.g. the root
+	AccessSequential                   // The page is read by a scan over many pages
+	AccessOnce                         // The page is read once, e.g. by a walk over the whole tree
+)
+
+var (
+	// ErrNoFreeFrame is returned when every frame holds a pinned page
+	ErrNoFreeFrame = errors.New("no free frame in the buffer pool")
+
+	// ErrDiskFull is returned by AllocatePage of a disk manager that stores the maximum number of pages
+	ErrDiskFull = errors.New("disk is full")
+
+	// ErrPageNotInPool is returned when a page that is not in the buffer pool is unpinned or flushed
+	ErrPageNotInPool = errors.New("Could not find page")
+)
+
 type BufferPoolManager struct {
 	pages       [PoolSize]*Page // Pointers to every page – or nil if no page
 	replacer    *ClockReplacer
 	freeList    []FrameID          // List of all free frames
 	pageTable   map[PageID]FrameID // Maps which page occupies which frame
 	diskManager DiskManager
+	hints       [PoolSize]AccessHint // Hints of the pages in the frames, used once they are unpinned
+	diskMu      sync.Mutex           // Serializes the disk manager between the pool and the reads of Prefetch
+	prefetches  map[PageID]*prefetch // Pages that are read ahead, they are not in a frame
+}
+
+// prefetch is a page that is read ahead in the background
+type prefetch struct {
+	done chan struct{} // Closed once the page has been read
+	page *Page
+	err  error
 }
 
-func (bufferPool *BufferPoolManager) FetchPage(pageID PageID) *Page {
+// FetchPage returns the page with the given id and pins it. It fails if the page cannot be read, or if the dirty page
+// it replaces cannot be written; that page then stays in the pool.
+// The hint decides how soon the page is evicted once it is unpinned. A page in the pool keeps the strongest hint it
+// has been fetched with, AccessNormal being the strongest, so a scan does not push out pages of the working set.
+func (bufferPool *BufferPoolManager) FetchPage(pageID PageID, hint AccessHint) (*Page, error) {
 	if frameID, ok := bufferPool.pageTable[pageID]; ok {
 		page := bufferPool.pages[frameID]
 		page.IncPinCount()
 		(*bufferPool.replacer).Pin(frameID)
-		return page
+		if hint < bufferPool.hints[frameID] {
+			bufferPool.hints[frameID] = hint
+		}
+		return page, nil
 	}
 
 	// get the id from free list or from replacer
 	frameID, isFromFreeList := bufferPool.getFrameID()
 	if frameID == nil {
-		return nil
+		return nil, ErrNoFreeFrame
 	}
 
 	if !isFromFreeList {
-		// remove page from current frame
-		currentPage := bufferPool.pages[*frameID]
-		if currentPage != nil {
-			if currentPage.isDirty {
-				bufferPool.diskManager.WritePage(currentPage)
-			}
-
-			delete(bufferPool.pageTable, currentPage.Id)
+		err := bufferPool.evict(*frameID)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	page, err := bufferPool.diskManager.ReadPage(pageID)
+	page, err := bufferPool.readPage(pageID)
 	if err != nil {
-		return nil
+		bufferPool.freeList = append(bufferPool.freeList, *frameID)
+		return nil, err
 	}
 	(*page).PinCounter = 1
 	bufferPool.pageTable[pageID] = *frameID
 	bufferPool.pages[*frameID] = page
+	bufferPool.hints[*frameID] = hint
 
-	return page
+	return page, nil
 }
 
 func (bufferPool *BufferPoolManager) UnpinPage(pageID PageID, isDirty bool) error {
@@ -57,7 +95,7 @@ func (bufferPool *BufferPoolManager) UnpinPage(pageID PageID, isDirty bool) erro
 		page.DecPinCount()
 
 		if page.PinCounter <= 0 {
-			(*bufferPool.replacer).Unpin(frameID)
+			(*bufferPool.replacer).UnpinWithHint(frameID, bufferPool.hints[frameID])
 		}
 
 		if page.isDirty || isDirty {
@@ -69,61 +107,85 @@ func (bufferPool *BufferPoolManager) UnpinPage(pageID PageID, isDirty bool) erro
 		return nil
 	}
 
-	return errors.New("Could not find page")
+	return ErrPageNotInPool
 }
 
-// FlushPage Flushes the target page to disk.
-func (bufferPool *BufferPoolManager) FlushPage(pageID PageID) bool {
+// FlushPage Flushes the target page to disk. The page stays dirty if it cannot be written, its pin count is kept.
+func (bufferPool *BufferPoolManager) FlushPage(pageID PageID) error {
 	if frameID, ok := bufferPool.pageTable[pageID]; ok {
 		page := bufferPool.pages[frameID]
-		page.DecPinCount()
-
-		bufferPool.diskManager.WritePage(page)
+		err := bufferPool.writePage(page)
+		if err != nil {
+			return err
+		}
 		page.isDirty = false
 
-		return true
+		return nil
 	}
 
-	return false
+	return ErrPageNotInPool
 }
 
 // NewPage allocates a new page in the buffer pool with the disk manager help
-func (bufferPool *BufferPoolManager) NewPage(path string) *Page {
+func (bufferPool *BufferPoolManager) NewPage(path string) (*Page, error) {
 	frameID, isFromFreeList := bufferPool.getFrameID()
 	if frameID == nil {
-		return nil
+		return nil, ErrNoFreeFrame
 	}
 
 	if !isFromFreeList {
-		// remove page from current frame
-		currentPage := bufferPool.pages[*frameID]
-		if currentPage != nil {
-			if currentPage.isDirty {
-				bufferPool.diskManager.WritePage(currentPage)
-			}
-
-			delete(bufferPool.pageTable, currentPage.Id)
+		err := bufferPool.evict(*frameID)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	// allocates new page
-	pageID := bufferPool.diskManager.AllocatePage(path)
-	if pageID == nil {
-		return nil
+	bufferPool.diskMu.Lock()
+	pageID, err := bufferPool.diskManager.AllocatePage(path)
+	bufferPool.diskMu.Unlock()
+	if err != nil {
+		bufferPool.freeList = append(bufferPool.freeList, *frameID)
+		return nil, err
 	}
+	bufferPool.dropPrefetch(*pageID)
 	page := &Page{*pageID, 1, true, []byte{}, path} //[PageSize]byte{}, do we need that?
 
 	bufferPool.pageTable[*pageID] = *frameID
 	bufferPool.pages[*frameID] = page
+	bufferPool.hints[*frameID] = AccessNormal
+
+	return page, nil
+}
+
+// evict removes the page in a frame chosen as victim from the pool and writes it to disk if it is dirty. A page that
+// cannot be written stays in the pool, it can be chosen again.
+func (bufferPool *BufferPoolManager) evict(frameID FrameID) error {
+	currentPage := bufferPool.pages[frameID]
+	if currentPage == nil {
+		return nil
+	}
+
+	if currentPage.isDirty {
+		err := bufferPool.writePage(currentPage)
+		if err != nil {
+			(*bufferPool.replacer).UnpinWithHint(frameID, bufferPool.hints[frameID])
+			return err
+		}
+	}
 
-	return page
+	delete(bufferPool.pageTable, currentPage.Id)
+	bufferPool.pages[frameID] = nil
+	return nil
 }
 
-// DeletePage deletes a page from the buffer pool.
+// DeletePage deletes a page from the buffer pool and deallocates it on disk.
 func (bufferPool *BufferPoolManager) DeletePage(pageID PageID) error {
+	bufferPool.dropPrefetch(pageID)
 	var frameID FrameID
 	var ok bool
 	if frameID, ok = bufferPool.pageTable[pageID]; !ok {
+		bufferPool.deallocatePage(pageID)
 		return nil
 	}
 
@@ -134,20 +196,155 @@ func (bufferPool *BufferPoolManager) DeletePage(pageID PageID) error {
 	}
 	delete(bufferPool.pageTable, page.Id)
 	(*bufferPool.replacer).Pin(frameID)
-	bufferPool.diskManager.DeallocatePage(pageID)
+	bufferPool.deallocatePage(pageID)
 
 	bufferPool.freeList = append(bufferPool.freeList, frameID)
 
 	return nil
 }
 
-// FlushAllpages flushes all the pages in the buffer pool to disk.
-func (bufferPool *BufferPoolManager) FlushAllpages() {
+// FlushAllpages flushes all the pages in the buffer pool to disk and syncs it. Pages that cannot be written stay
+// dirty, the first error is returned.
+func (bufferPool *BufferPoolManager) FlushAllpages() error {
+	var firstErr error
 	for pageID := range bufferPool.pageTable {
-		bufferPool.FlushPage(pageID)
+		err := bufferPool.FlushPage(pageID)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil || bufferPool.diskManager == nil {
+		return firstErr // The zero value has no disk to sync
+	}
+
+	bufferPool.diskMu.Lock()
+	defer bufferPool.diskMu.Unlock()
+	return bufferPool.diskManager.Sync()
+}
+
+// Clear drops all pages from the pool without writing them and waits for the pages read ahead, e.g. before the disk
+// manager releases the stored pages. Pinned pages are dropped as well, they must not be used anymore.
+func (bufferPool *BufferPoolManager) Clear() {
+	bufferPool.CancelPrefetches()
+	for frameID, page := range bufferPool.pages {
+		if page == nil {
+			continue
+		}
+
+		(*bufferPool.replacer).Pin(FrameID(frameID))
+		delete(bufferPool.pageTable, page.Id)
+		bufferPool.pages[frameID] = nil
+		bufferPool.freeList = append(bufferPool.freeList, FrameID(frameID))
 	}
 }
 
+// DirtyRatio returns the share of the frames that hold a dirty page
+func (bufferPool *BufferPoolManager) DirtyRatio() float64 {
+	dirty := 0
+	for _, page := range bufferPool.pages {
+		if page != nil && page.isDirty {
+			dirty++
+		}
+	}
+
+	return float64(dirty) / float64(PoolSize)
+}
+
+// WriteBack writes up to n dirty pages that are not pinned to disk, so their frames can be chosen as victims without
+// a write. The pages stay in the pool. It returns how many pages have been written; a page that cannot be written
+// stays dirty.
+func (bufferPool *BufferPoolManager) WriteBack(n int) (int, error) {
+	written := 0
+	for _, page := range bufferPool.pages {
+		if written == n {
+			break
+		}
+		if page == nil || !page.isDirty || page.PinCounter > 0 {
+			continue
+		}
+
+		err := bufferPool.writePage(page)
+		if err != nil {
+			return written, err
+		}
+		page.isDirty = false
+		written++
+	}
+
+	return written, nil
+}
+
+// Prefetch starts reading the given pages in the background, so that FetchPage does not have to wait for the disk.
+// Pages that are in the pool or read ahead already are skipped, and no more than PrefetchSize pages are read ahead at
+// a time. Read pages are held next to the frames until they are fetched, an error of the read is returned by
+// FetchPage. Until CancelPrefetches is called, the disk manager must only be used through the pool.
+func (bufferPool *BufferPoolManager) Prefetch(pageIDs ...PageID) {
+	if bufferPool.prefetches == nil {
+		bufferPool.prefetches = make(map[PageID]*prefetch)
+	}
+
+	for _, pageID := range pageIDs {
+		if len(bufferPool.prefetches) >= PrefetchSize {
+			return
+		}
+		if _, ok := bufferPool.pageTable[pageID]; ok {
+			continue
+		}
+		if _, ok := bufferPool.prefetches[pageID]; ok {
+			continue
+		}
+
+		p := &prefetch{done: make(chan struct{})}
+		bufferPool.prefetches[pageID] = p
+		go func(pageID PageID) {
+			defer close(p.done)
+			bufferPool.diskMu.Lock()
+			defer bufferPool.diskMu.Unlock()
+			p.page, p.err = bufferPool.diskManager.ReadPage(pageID)
+		}(pageID)
+	}
+}
+
+// CancelPrefetches waits for the reads started by Prefetch and drops the pages that have not been fetched
+func (bufferPool *BufferPoolManager) CancelPrefetches() {
+	for pageID := range bufferPool.prefetches {
+		bufferPool.dropPrefetch(pageID)
+	}
+}
+
+// dropPrefetch waits until a page that is read ahead has been read and drops it, e.g. because it is deallocated
+func (bufferPool *BufferPoolManager) dropPrefetch(pageID PageID) {
+	if p, ok := bufferPool.prefetches[pageID]; ok {
+		<-p.done
+		delete(bufferPool.prefetches, pageID)
+	}
+}
+
+// readPage takes a page that has been read ahead, waiting for the read if it is still running, or reads it
+func (bufferPool *BufferPoolManager) readPage(pageID PageID) (*Page, error) {
+	if p, ok := bufferPool.prefetches[pageID]; ok {
+		<-p.done
+		delete(bufferPool.prefetches, pageID)
+		return p.page, p.err
+	}
+
+	bufferPool.diskMu.Lock()
+	defer bufferPool.diskMu.Unlock()
+	return bufferPool.diskManager.ReadPage(pageID)
+}
+
+func (bufferPool *BufferPoolManager) writePage(page *Page) error {
+	bufferPool.diskMu.Lock()
+	defer bufferPool.diskMu.Unlock()
+	return bufferPool.diskManager.WritePage(page)
+}
+
+func (bufferPool *BufferPoolManager) deallocatePage(pageID PageID) {
+	bufferPool.diskMu.Lock()
+	defer bufferPool.diskMu.Unlock()
+	bufferPool.diskManager.DeallocatePage(pageID)
+}
+
 func (bufferPool *BufferPoolManager) getFrameID() (*FrameID, bool) {
 	if len(bufferPool.freeList) > 0 {
 		frameID, newFreeList := bufferPool.freeList[0], bufferPool.freeList[1:]
@@ -167,5 +364,12 @@ func NewBufferPoolManager(DiskManager DiskManager, clockReplacer *ClockReplacer)
 		freeList = append(freeList, FrameID(i))
 		pages[FrameID(i)] = nil
 	}
-	return &BufferPoolManager{pages, clockReplacer, freeList, make(map[PageID]FrameID), DiskManager}
+	return &BufferPoolManager{
+		pages:       pages,
+		replacer:    clockReplacer,
+		freeList:    freeList,
+		pageTable:   make(map[PageID]FrameID),
+		diskManager: DiskManager,
+		prefetches:  make(map[PageID]*prefetch),
+	}
 }
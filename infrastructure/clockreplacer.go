@@ -4,17 +4,27 @@ type FrameID int
 
 // ClockReplacer the data needed for the clock replacer algorithm
 type ClockReplacer struct {
-	cList     *circularList // circular list of frames. Value = true
-	clockHand **node        // node in the circular list we are currently at
+	cList     *circularList          // circular list of frames. Value = true
+	clockHand **node                 // node in the circular list we are currently at
+	hints     map[FrameID]AccessHint // Frames in the clock that were not unpinned with AccessNormal
 }
 
 // ChooseVictim removes the victim frame, i.e. frame corresponding to the next node with value false
 // If value is true, set it to false to allow for a "second chance" – so algorithm goes  at most around the whole list once
+// Frames unpinned with AccessOnce, and then those unpinned with AccessSequential, are chosen before the clock is
+// consulted, so they do not push out the frames that are used again.
 func (clockReplacer *ClockReplacer) ChooseVictim() *FrameID {
 	if clockReplacer.cList.size == 0 {
 		return nil
 	}
 
+	for _, hint := range []AccessHint{AccessOnce, AccessSequential} {
+		if frameID, ok := clockReplacer.findHint(hint); ok {
+			clockReplacer.Pin(frameID)
+			return &frameID
+		}
+	}
+
 	var victimFrameID *FrameID
 	currentNode := *clockReplacer.clockHand
 	for {
@@ -35,11 +45,20 @@ func (clockReplacer *ClockReplacer) ChooseVictim() *FrameID {
 
 // Unpin unpins a frame, indicating that it can now be victimized
 func (clockReplacer *ClockReplacer) Unpin(id FrameID) {
+	clockReplacer.UnpinWithHint(id, AccessNormal)
+}
+
+// UnpinWithHint unpins a frame like Unpin. A frame accessed with AccessOnce or AccessSequential is victimized before
+// the frames accessed with AccessNormal, see ChooseVictim.
+func (clockReplacer *ClockReplacer) UnpinWithHint(id FrameID, hint AccessHint) {
 	if !clockReplacer.cList.hasKey(id) {
 		clockReplacer.cList.insert(id, true)
 		if clockReplacer.cList.size == 1 {
 			clockReplacer.clockHand = &clockReplacer.cList.head
 		}
+		if hint != AccessNormal {
+			clockReplacer.hints[id] = hint
+		}
 	}
 }
 
@@ -49,6 +68,7 @@ func (clockReplacer *ClockReplacer) Pin(id FrameID) {
 	if node == nil {
 		return
 	}
+	delete(clockReplacer.hints, id)
 
 	if (*clockReplacer.clockHand) == node {
 		clockReplacer.clockHand = &(*clockReplacer.clockHand).next
@@ -57,6 +77,24 @@ func (clockReplacer *ClockReplacer) Pin(id FrameID) {
 
 }
 
+// findHint returns the first frame from the clock hand on that has been unpinned with hint
+func (clockReplacer *ClockReplacer) findHint(hint AccessHint) (FrameID, bool) {
+	if len(clockReplacer.hints) == 0 {
+		return 0, false
+	}
+
+	currentNode := *clockReplacer.clockHand
+	for i := 0; i < clockReplacer.cList.size; i++ {
+		frameID := currentNode.key.(FrameID)
+		if clockReplacer.hints[frameID] == hint {
+			return frameID, true
+		}
+		currentNode = currentNode.next
+	}
+
+	return 0, false
+}
+
 // Size returns the size of the clock
 func (clockReplacer *ClockReplacer) Size() int {
 	return clockReplacer.cList.size
@@ -65,5 +103,5 @@ func (clockReplacer *ClockReplacer) Size() int {
 // NewClockReplacer instantiates a new clock replacer
 func NewClockReplacer(poolSize int) *ClockReplacer {
 	cList := newCircularList(poolSize)
-	return &ClockReplacer{cList, &cList.head}
+	return &ClockReplacer{cList, &cList.head, make(map[FrameID]AccessHint)}
 }
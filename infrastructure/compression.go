@@ -0,0 +1,121 @@
+package infrastructure
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	pageUncompressed byte = 0
+	pageCompressed   byte = 1
+
+	compressionHeaderSize = 5 // Compression flag and length of the stored data
+)
+
+// CompressingDiskManager compresses pages before they are written by the wrapped DiskManager and decompresses them
+// after they have been read. Every stored page starts with a header holding a compression flag and the length of the
+// data that follows, so pages written with compression turned off can still be read and vice versa.
+type CompressingDiskManager struct {
+	diskManager DiskManager
+	enabled     bool // Compress pages on write, otherwise they are only prefixed with the header
+}
+
+// ReadPage reads a page from the wrapped DiskManager and decompresses it
+func (c *CompressingDiskManager) ReadPage(pageID PageID) (*Page, error) {
+	page, err := c.diskManager.ReadPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decompressPage(page.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	readPage := *page
+	readPage.Data = data
+	return &readPage, nil
+}
+
+// WritePage compresses a page and writes it with the wrapped DiskManager. The page itself is not modified.
+func (c *CompressingDiskManager) WritePage(page *Page) error {
+	storedPage := *page
+	storedPage.Data = compressPage(page.Data, c.enabled)
+	return c.diskManager.WritePage(&storedPage)
+}
+
+// AllocatePage allocates a page with the wrapped DiskManager
+func (c *CompressingDiskManager) AllocatePage(path string) (*PageID, error) {
+	return c.diskManager.AllocatePage(path)
+}
+
+// DeallocatePage deallocates a page with the wrapped DiskManager
+func (c *CompressingDiskManager) DeallocatePage(pageID PageID) {
+	c.diskManager.DeallocatePage(pageID)
+}
+
+// Sync syncs the wrapped DiskManager
+func (c *CompressingDiskManager) Sync() error {
+	return c.diskManager.Sync()
+}
+
+// compressPage prefixes data with the page header. If compression is enabled and makes the page smaller,
+// the data is stored compressed.
+func compressPage(data []byte, enabled bool) []byte {
+	if len(data) == 0 {
+		return data
+	}
+
+	flag, payload := pageUncompressed, data
+	if enabled {
+		var buffer bytes.Buffer
+		writer, err := flate.NewWriter(&buffer, flate.BestSpeed)
+		check(err) // only fails for an invalid level
+		writer.Write(data)
+		writer.Close()
+
+		if buffer.Len() < len(data) {
+			flag, payload = pageCompressed, buffer.Bytes()
+		}
+	}
+
+	stored := make([]byte, compressionHeaderSize+len(payload))
+	stored[0] = flag
+	binary.LittleEndian.PutUint32(stored[1:compressionHeaderSize], uint32(len(payload)))
+	copy(stored[compressionHeaderSize:], payload)
+
+	return stored
+}
+
+// decompressPage reverses compressPage
+func decompressPage(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return stored, nil
+	}
+	if len(stored) < compressionHeaderSize {
+		return nil, errors.New("page header is truncated")
+	}
+
+	length := int(binary.LittleEndian.Uint32(stored[1:compressionHeaderSize]))
+	if len(stored) < compressionHeaderSize+length {
+		return nil, errors.New("page data is truncated")
+	}
+	payload := stored[compressionHeaderSize : compressionHeaderSize+length]
+
+	switch stored[0] {
+	case pageUncompressed:
+		return payload, nil
+	case pageCompressed:
+		return io.ReadAll(flate.NewReader(bytes.NewReader(payload)))
+	default:
+		return nil, errors.New("unknown page compression")
+	}
+}
+
+// NewCompressingDiskManager wraps diskManager. Pages are only compressed if enabled is set.
+func NewCompressingDiskManager(diskManager DiskManager, enabled bool) *CompressingDiskManager {
+	return &CompressingDiskManager{diskManager, enabled}
+}
@@ -1,12 +1,15 @@
 package infrastructure
 
-// DiskMaxNumPages sets the disk capacity
-const DiskMaxNumPages = 1000 // Needlessly large for our case, because we assume disk space not an issue
+// DiskMaxNumPages is the number of pages a disk manager stores unless SetMaxPages is called. It is also the limit of
+// stores that were created before the limit could be configured.
+const DiskMaxNumPages = 1000
 
-// DiskManager responsible for interacting with disk
+// DiskManager responsible for interacting with disk. Disk managers are not safe for concurrent use, their callers, e.g.
+// the BufferPoolManager, serialize the calls. One that wraps another calls it only from within its own methods.
 type DiskManager interface {
 	ReadPage(PageID) (*Page, error)
 	WritePage(*Page) error
-	AllocatePage(string) *PageID
+	AllocatePage(string) (*PageID, error) // Returns ErrDiskFull if no more pages can be allocated
 	DeallocatePage(PageID)
+	Sync() error // Makes the pages written so far durable
 }
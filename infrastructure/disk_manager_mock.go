@@ -1,31 +1,38 @@
 package infrastructure
 
 import (
-	"encoding/binary"
 	"errors"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 )
 
-var (
-	instance DiskManagerMock
-)
-
 // DiskManagerMock is a memory mock for disk manager
 type DiskManagerMock struct {
-	nextPageId int // tracks the number of pages ever allocated and serves as next pageId
-	pages      map[PageID]*Page
-	memMap     map[PageID]*os.File // Mocks disk;
+	nextPageId  int // tracks the number of pages ever allocated and serves as next pageId
+	pages       map[PageID]*Page
+	memMap      map[PageID]*os.File // Mocks disk;
+	freePageIds []PageID            // Deallocated page ids below nextPageId, sorted ascending
+	maxPages    int                 // AllocatePage fails once this many pages are allocated
 }
 
 // ReadPage reads a page from pages map
 func (d *DiskManagerMock) ReadPage(pageID PageID) (*Page, error) {
 	if file, ok := d.memMap[pageID]; ok {
-		var page Page
-		err := binary.Read(file, binary.LittleEndian, page)
-		check(err)
-		return &page, nil
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, info.Size()) // Stored pages can be larger than PageSize, e.g. with a header
+		n, err := file.ReadAt(data, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		path := filepath.Dir(filepath.Dir(file.Name())) // <path>/KVSTOREPAGES/<pageId>
+		return &Page{Id: pageID, Data: data[:n], Path: path}, nil
 	}
 	if page, ok := d.pages[pageID]; ok {
 		return page, nil
@@ -39,8 +46,13 @@ func (d *DiskManagerMock) WritePage(page *Page) error {
 	d.pages[page.Id] = page
 	var file = d.memMap[page.Id]
 
-	err := binary.Write(file, binary.LittleEndian, page)
-	return err
+	_, err := file.WriteAt(page.Data, 0)
+	if err != nil {
+		return err
+	}
+
+	// Pages can shrink, e.g. when they are compressed
+	return file.Truncate(int64(len(page.Data)))
 }
 
 func asUint64(val interface{}) uint64 {
@@ -51,36 +63,173 @@ func asUint64(val interface{}) uint64 {
 	return ref.Uint()
 }
 
-// AllocatePage allocates new page
-func (d *DiskManagerMock) AllocatePage(path string) *PageID {
-	if d.nextPageId == DiskMaxNumPages {
-		return nil
+// AllocatePage allocates new page. Deallocated page ids are reused, the lowest first.
+// It returns ErrDiskFull if the maximum number of pages is allocated.
+func (d *DiskManagerMock) AllocatePage(path string) (*PageID, error) {
+	if len(d.memMap) >= d.maxPages {
+		return nil, ErrDiskFull
+	}
+
+	var pageID PageID
+	if len(d.freePageIds) > 0 {
+		pageID = d.freePageIds[0]
+	} else {
+		pageID = PageID(d.nextPageId)
 	}
-	pageID := PageID(d.nextPageId)
-	d.nextPageId = d.nextPageId + 1
 
 	err := os.MkdirAll(path+"/KVSTOREPAGES", os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
 	file, err := os.Create(path + "/KVSTOREPAGES/" + strconv.Itoa(int(pageID)))
-	check(err) // should never happen, pageID is unique
+	if err != nil {
+		return nil, err
+	}
+	if len(d.freePageIds) > 0 {
+		d.freePageIds = d.freePageIds[1:]
+	} else {
+		d.nextPageId++
+	}
 	d.memMap[pageID] = file
 
-	return &pageID
+	return &pageID, nil
 }
 
-// DeallocatePage removes page from disk
+// OpenPages makes the pages stored at path available again, e.g. after the process has been restarted.
+// Page ids that are already known are taken over by the pages at path.
+func (d *DiskManagerMock) OpenPages(path string) error {
+	entries, err := os.ReadDir(path + "/KVSTOREPAGES")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue // Not a page
+		}
+
+		file, err := os.OpenFile(path+"/KVSTOREPAGES/"+entry.Name(), os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		if oldFile, ok := d.memMap[PageID(id)]; ok {
+			oldFile.Close()
+		}
+		d.memMap[PageID(id)] = file
+		delete(d.pages, PageID(id))
+
+		if id >= d.nextPageId {
+			d.nextPageId = id + 1
+		}
+	}
+
+	// Ids below nextPageId without a page, e.g. after a compaction, can be reused
+	d.freePageIds = nil
+	for id := 1; id < d.nextPageId; id++ {
+		if _, ok := d.memMap[PageID(id)]; !ok {
+			d.freePageIds = append(d.freePageIds, PageID(id))
+		}
+	}
+
+	return nil
+}
+
+// ReleasePages forgets the pages stored at path, e.g. after the store has been deleted.
+// Once no pages are left, page ids start at 1 again.
+func (d *DiskManagerMock) ReleasePages(path string) {
+	dir := filepath.Clean(path + "/KVSTOREPAGES")
+	for pageID, file := range d.memMap {
+		if filepath.Dir(file.Name()) == dir {
+			file.Close()
+			delete(d.memMap, pageID)
+			delete(d.pages, pageID)
+			d.freePageID(pageID)
+		}
+	}
+
+	if len(d.memMap) == 0 {
+		d.nextPageId = 1
+		d.pages = make(map[PageID]*Page)
+		d.freePageIds = nil
+	}
+}
+
+// DeallocatePage removes page from disk. Its id can be allocated again.
 func (d *DiskManagerMock) DeallocatePage(pageID PageID) {
+	if file, ok := d.memMap[pageID]; ok {
+		file.Close()
+		os.Remove(file.Name())
+		d.freePageID(pageID)
+	}
 	delete(d.pages, pageID)
 	delete(d.memMap, pageID)
 }
 
-// NewDiskManagerMock returns an empty disk manager mock. Should only use one unless wanting to mock multiple disks.
-func NewDiskManagerMock() *DiskManagerMock {
-	if instance.nextPageId < 1 {
+// Sync does nothing, pages are written to their files right away
+func (d *DiskManagerMock) Sync() error {
+	return nil
+}
+
+// StoredPages returns the ids of the pages stored at path and their total size in bytes
+func (d *DiskManagerMock) StoredPages(path string) ([]PageID, int64, error) {
+	entries, err := os.ReadDir(path + "/KVSTOREPAGES")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var pageIDs []PageID
+	size := int64(0)
+	for _, entry := range entries {
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue // Not a page
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		pageIDs = append(pageIDs, PageID(id))
+		size += info.Size()
+	}
+
+	return pageIDs, size, nil
+}
+
+// SetMaxPages sets the number of pages that can be allocated. Pages that are allocated already are kept.
+func (d *DiskManagerMock) SetMaxPages(maxPages int) {
+	d.maxPages = maxPages
+}
+
+// AllocatedPages returns the number of pages that are allocated
+func (d *DiskManagerMock) AllocatedPages() int {
+	return len(d.memMap)
+}
 
-		instance = DiskManagerMock{1, make(map[PageID]*Page), make(map[PageID]*os.File)} // <-- not thread safe
+// freePageID makes pageID available to AllocatePage again
+func (d *DiskManagerMock) freePageID(pageID PageID) {
+	d.freePageIds = insertPageID(d.freePageIds, pageID)
+}
+
+// insertPageID adds pageID to the ascending pageIDs unless it is there already
+func insertPageID(pageIDs []PageID, pageID PageID) []PageID {
+	i := sort.Search(len(pageIDs), func(i int) bool { return pageIDs[i] >= pageID })
+	if i < len(pageIDs) && pageIDs[i] == pageID {
+		return pageIDs
 	}
 
-	return &instance
+	pageIDs = append(pageIDs, 0)
+	copy(pageIDs[i+1:], pageIDs[i:])
+	pageIDs[i] = pageID
+	return pageIDs
+}
+
+// NewDiskManagerMock returns an empty disk manager mock that allocates up to DiskMaxNumPages pages. Every store has its
+// own, so page ids only have to be unique within a store. Use OpenPages to make the pages of an existing store
+// available.
+func NewDiskManagerMock() *DiskManagerMock {
+	return &DiskManagerMock{1, make(map[PageID]*Page), make(map[PageID]*os.File), nil, DiskMaxNumPages}
 }
 
 func check(e error) {
@@ -0,0 +1,99 @@
+package infrastructure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// EncryptingDiskManager seals pages with AES-GCM before they are written by the wrapped DiskManager and opens them
+// after they have been read.
+//
+// The 12 byte nonce of a page consists of its PageID and a write counter. The counter starts at the current time in
+// nanoseconds and is incremented on every write, so a nonce is never reused for the same key, not even after the
+// store has been reopened. The nonce is stored in front of the sealed page and the PageID is authenticated as well,
+// so pages cannot be swapped on disk without being detected.
+type EncryptingDiskManager struct {
+	writeCounter uint64 // First field to keep it 64-bit aligned for atomic access
+	diskManager  DiskManager
+	aead         cipher.AEAD
+}
+
+// ReadPage reads a page from the wrapped DiskManager and decrypts it
+func (e *EncryptingDiskManager) ReadPage(pageID PageID) (*Page, error) {
+	page, err := e.diskManager.ReadPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Data) == 0 {
+		return page, nil
+	}
+
+	nonceSize := e.aead.NonceSize()
+	if len(page.Data) < nonceSize {
+		return nil, errors.New("encrypted page is truncated")
+	}
+
+	data, err := e.aead.Open(nil, page.Data[:nonceSize], page.Data[nonceSize:], pageIDBytes(pageID))
+	if err != nil {
+		return nil, err
+	}
+
+	readPage := *page
+	readPage.Data = data
+	return &readPage, nil
+}
+
+// WritePage encrypts a page and writes it with the wrapped DiskManager. The page itself is not modified.
+func (e *EncryptingDiskManager) WritePage(page *Page) error {
+	storedPage := *page
+	if len(page.Data) > 0 {
+		nonce := make([]byte, e.aead.NonceSize())
+		binary.BigEndian.PutUint32(nonce[0:4], uint32(page.Id))
+		binary.BigEndian.PutUint64(nonce[4:12], atomic.AddUint64(&e.writeCounter, 1))
+
+		storedPage.Data = e.aead.Seal(nonce, nonce, page.Data, pageIDBytes(page.Id))
+	}
+
+	return e.diskManager.WritePage(&storedPage)
+}
+
+// AllocatePage allocates a page with the wrapped DiskManager
+func (e *EncryptingDiskManager) AllocatePage(path string) (*PageID, error) {
+	return e.diskManager.AllocatePage(path)
+}
+
+// DeallocatePage deallocates a page with the wrapped DiskManager
+func (e *EncryptingDiskManager) DeallocatePage(pageID PageID) {
+	e.diskManager.DeallocatePage(pageID)
+}
+
+// Sync syncs the wrapped DiskManager
+func (e *EncryptingDiskManager) Sync() error {
+	return e.diskManager.Sync()
+}
+
+// pageIDBytes is the additional authenticated data of a page
+func pageIDBytes(pageID PageID) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(pageID))
+	return data
+}
+
+// NewEncryptingDiskManager wraps diskManager. The key has to be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+func NewEncryptingDiskManager(diskManager DiskManager, key []byte) (*EncryptingDiskManager, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptingDiskManager{uint64(time.Now().UnixNano()), diskManager, aead}, nil
+}
@@ -0,0 +1,171 @@
+package infrastructure
+
+import (
+	"errors"
+)
+
+// ErrInjectedFault is returned by a FaultyDiskManager for a read or write it was told to fail
+var ErrInjectedFault = errors.New("injected fault")
+
+// FaultyDiskManager wraps a DiskManager and injects faults for tests. Reads and writes are counted, so a test can
+// script which of the coming ones fail or tear, and it can flip bits of stored pages.
+// It also simulates a disk cache: what has been written or deallocated since the last Sync is lost by Crash, which
+// brings the pages back to the state of the last Sync.
+type FaultyDiskManager struct {
+	diskManager DiskManager
+	reads       int
+	writes      int
+	readFaults  map[int]bool // Numbers of the reads that fail
+	writeFaults map[int]int  // Numbers of the writes that fail with -1, or that tear after that many bytes
+	unsynced    map[PageID][]byte
+	allocated   map[PageID]bool // Pages allocated since the last Sync
+	deallocated []PageID        // Deallocations that take effect with the next Sync
+}
+
+// ReadPage reads a page with the wrapped DiskManager unless the read is scripted to fail
+func (f *FaultyDiskManager) ReadPage(pageID PageID) (*Page, error) {
+	f.reads++
+	if f.readFaults[f.reads] {
+		delete(f.readFaults, f.reads)
+		return nil, ErrInjectedFault
+	}
+
+	return f.diskManager.ReadPage(pageID)
+}
+
+// WritePage writes a page with the wrapped DiskManager unless the write is scripted to fail or tear. A torn write
+// stores the beginning of the new page and the rest of the page as it was stored before, and reports success.
+func (f *FaultyDiskManager) WritePage(page *Page) error {
+	f.writes++
+	tear, fault := f.writeFaults[f.writes]
+	delete(f.writeFaults, f.writes)
+	if fault && tear < 0 {
+		return ErrInjectedFault
+	}
+
+	f.preserve(page.Id)
+	if !fault || tear >= len(page.Data) {
+		return f.diskManager.WritePage(page)
+	}
+
+	data := append([]byte{}, page.Data[:tear]...)
+	if stored, err := f.diskManager.ReadPage(page.Id); err == nil && len(stored.Data) > tear {
+		data = append(data, stored.Data[tear:]...)
+	}
+	torn := *page
+	torn.Data = data
+	return f.diskManager.WritePage(&torn)
+}
+
+// AllocatePage allocates a page with the wrapped DiskManager
+func (f *FaultyDiskManager) AllocatePage(path string) (*PageID, error) {
+	pageID, err := f.diskManager.AllocatePage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.allocated[*pageID] = true
+	return pageID, nil
+}
+
+// DeallocatePage deallocates a page that has been allocated since the last Sync right away, other pages with the
+// next Sync
+func (f *FaultyDiskManager) DeallocatePage(pageID PageID) {
+	if f.allocated[pageID] {
+		delete(f.allocated, pageID)
+		delete(f.unsynced, pageID)
+		f.diskManager.DeallocatePage(pageID)
+		return
+	}
+
+	f.deallocated = append(f.deallocated, pageID)
+}
+
+// Sync makes the writes and deallocations so far durable and syncs the wrapped DiskManager
+func (f *FaultyDiskManager) Sync() error {
+	for _, pageID := range f.deallocated {
+		f.diskManager.DeallocatePage(pageID)
+	}
+	f.deallocated = nil
+	f.unsynced = make(map[PageID][]byte)
+	f.allocated = make(map[PageID]bool)
+
+	return f.diskManager.Sync()
+}
+
+// Crash drops what has been written, allocated or deallocated since the last Sync
+func (f *FaultyDiskManager) Crash() error {
+	for pageID, data := range f.unsynced {
+		err := f.diskManager.WritePage(&Page{Id: pageID, Data: data})
+		if err != nil {
+			return err
+		}
+	}
+	for pageID := range f.allocated {
+		f.diskManager.DeallocatePage(pageID)
+	}
+	f.deallocated = nil
+	f.unsynced = make(map[PageID][]byte)
+	f.allocated = make(map[PageID]bool)
+
+	return nil
+}
+
+// FailRead makes the nth read from now on fail, 1 is the next one
+func (f *FaultyDiskManager) FailRead(n int) {
+	f.readFaults[f.reads+n] = true
+}
+
+// FailWrite makes the nth write from now on fail without storing anything, 1 is the next one
+func (f *FaultyDiskManager) FailWrite(n int) {
+	f.writeFaults[f.writes+n] = -1
+}
+
+// TearWrite makes the nth write from now on store only the first size bytes of the page, 1 is the next one
+func (f *FaultyDiskManager) TearWrite(n int, size int) {
+	f.writeFaults[f.writes+n] = size
+}
+
+// FlipBit flips a bit of a stored page, bit 0 is the lowest bit of the first byte. The flip is not undone by Crash.
+func (f *FaultyDiskManager) FlipBit(pageID PageID, bit int) error {
+	stored, err := f.diskManager.ReadPage(pageID)
+	if err != nil {
+		return err
+	}
+	if bit < 0 || bit/8 >= len(stored.Data) {
+		return errors.New("bit is outside of the page")
+	}
+
+	flipped := *stored
+	flipped.Data = append([]byte{}, stored.Data...)
+	flipped.Data[bit/8] ^= 1 << (bit % 8)
+	return f.diskManager.WritePage(&flipped)
+}
+
+// ClearFaults drops the reads and writes that are scripted to fail or tear
+func (f *FaultyDiskManager) ClearFaults() {
+	f.readFaults = make(map[int]bool)
+	f.writeFaults = make(map[int]int)
+}
+
+// preserve saves the stored page before it is written for the first time since the last Sync
+func (f *FaultyDiskManager) preserve(pageID PageID) {
+	if _, ok := f.unsynced[pageID]; ok || f.allocated[pageID] {
+		return
+	}
+
+	stored, err := f.diskManager.ReadPage(pageID)
+	if err != nil {
+		return
+	}
+	f.unsynced[pageID] = append([]byte{}, stored.Data...)
+}
+
+// NewFaultyDiskManager returns a disk manager that stores pages with diskManager until it is told to fail
+func NewFaultyDiskManager(diskManager DiskManager) *FaultyDiskManager {
+	f := &FaultyDiskManager{diskManager: diskManager}
+	f.ClearFaults()
+	f.unsynced = make(map[PageID][]byte)
+	f.allocated = make(map[PageID]bool)
+	return f
+}
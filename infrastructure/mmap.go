@@ -0,0 +1,305 @@
+package infrastructure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const (
+	mmapSlotHeaderSize = 8              // State of the slot and length of the stored data
+	mmapSlotSize       = PageSize + 128 // Room for the slot header and the headers of compression and encryption
+	mmapInitialSlots   = 64             // Slots of a new file, it doubles whenever it is full
+	mmapMagic          = "KVMMAP\x01"   // Start of the first slot, which holds no page
+	mmapDataFile       = "/KVSTOREDATA" // Name of the file below the path of the store
+	slotFree           = byte(0)
+	slotAllocated      = byte(1)
+)
+
+var (
+	// ErrInvalidPageFile is returned by OpenPages if the file at the path does not hold pages of an MmapDiskManager
+	ErrInvalidPageFile = errors.New("invalid page file")
+
+	// ErrPageTooLarge is returned by WritePage if the data of a page does not fit into a slot of the file
+	ErrPageTooLarge = errors.New("page is too large")
+
+	// ErrInvalidSlot is returned by ReadPage if the length stored in the slot of a page does not fit into the slot
+	ErrInvalidSlot = errors.New("invalid page slot")
+
+	// ErrOtherPath is returned by AllocatePage if the disk manager already stores the pages of another path
+	ErrOtherPath = errors.New("pages of another path are mapped")
+)
+
+// MmapDiskManager stores all pages in one file, <path>/KVSTOREDATA, which is mapped into memory. Page n is stored in
+// slot n of the file, every slot has the same size, and slot 0 identifies the file.
+// ReadPage does not copy: the data of a read page is a slice of the mapping, so it must not be modified, and it
+// changes when the page is written again. Writes go through the file, never through the mapping, which is
+// read-only; Sync flushes them to disk. When the file grows, it is mapped again. The earlier mappings stay valid
+// until ReleasePages, because pages read from them may still be in use. Pages that have been read must be dropped
+// before ReleasePages, e.g. with BufferPoolManager.Clear.
+type MmapDiskManager struct {
+	path        string
+	file        *os.File
+	mapping     []byte   // Maps the whole file
+	retired     [][]byte // Earlier mappings of the file
+	nextPageId  int      // Slots from here on have never been allocated
+	freePageIds []PageID // Deallocated page ids below nextPageId, sorted ascending
+	maxPages    int      // AllocatePage fails once this many pages are allocated
+}
+
+// ReadPage returns a page as a slice of the mapping
+func (d *MmapDiskManager) ReadPage(pageID PageID) (*Page, error) {
+	offset, ok := d.allocated(pageID)
+	if !ok {
+		return nil, errors.New("page not found")
+	}
+
+	length := int(binary.LittleEndian.Uint32(d.mapping[offset+4 : offset+8]))
+	if length > mmapSlotSize-mmapSlotHeaderSize {
+		return nil, ErrInvalidSlot
+	}
+	start := offset + mmapSlotHeaderSize
+	end := start + length
+	return &Page{Id: pageID, Data: d.mapping[start:end:end], Path: d.path}, nil
+}
+
+// WritePage writes a page to its slot of the file
+func (d *MmapDiskManager) WritePage(page *Page) error {
+	offset, ok := d.allocated(page.Id)
+	if !ok {
+		return errors.New("page not found")
+	}
+	if len(page.Data) > mmapSlotSize-mmapSlotHeaderSize {
+		return ErrPageTooLarge
+	}
+
+	slot := make([]byte, mmapSlotHeaderSize+len(page.Data))
+	slot[0] = slotAllocated
+	binary.LittleEndian.PutUint32(slot[4:8], uint32(len(page.Data)))
+	copy(slot[mmapSlotHeaderSize:], page.Data)
+	_, err := d.file.WriteAt(slot, int64(offset))
+	return err
+}
+
+// AllocatePage allocates a slot in the file at path, which is created with the first page. Deallocated page ids are
+// reused, the lowest first. It returns ErrOtherPath if the disk manager already stores the pages of another path and
+// ErrDiskFull if the maximum number of pages is allocated.
+func (d *MmapDiskManager) AllocatePage(path string) (*PageID, error) {
+	if d.file == nil {
+		err := d.open(path, true)
+		if err != nil {
+			return nil, err
+		}
+	} else if filepath.Clean(path) != d.path {
+		return nil, ErrOtherPath
+	}
+	if d.AllocatedPages() >= d.maxPages {
+		return nil, ErrDiskFull
+	}
+
+	var pageID PageID
+	if len(d.freePageIds) > 0 {
+		pageID = d.freePageIds[0]
+	} else {
+		pageID = PageID(d.nextPageId)
+		if (int(pageID)+1)*mmapSlotSize > len(d.mapping) {
+			err := d.grow()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The page is empty until it is written
+	header := make([]byte, mmapSlotHeaderSize)
+	header[0] = slotAllocated
+	_, err := d.file.WriteAt(header, int64(int(pageID)*mmapSlotSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(d.freePageIds) > 0 {
+		d.freePageIds = d.freePageIds[1:]
+	} else {
+		d.nextPageId++
+	}
+
+	return &pageID, nil
+}
+
+// DeallocatePage frees the slot of a page. Its id can be allocated again, the file does not shrink.
+func (d *MmapDiskManager) DeallocatePage(pageID PageID) {
+	offset, ok := d.allocated(pageID)
+	if !ok {
+		return
+	}
+
+	_, err := d.file.WriteAt([]byte{slotFree}, int64(offset))
+	if err != nil {
+		return
+	}
+	d.freePageIds = insertPageID(d.freePageIds, pageID)
+}
+
+// Sync makes the pages written so far durable
+func (d *MmapDiskManager) Sync() error {
+	if d.file == nil {
+		return nil
+	}
+
+	return d.file.Sync()
+}
+
+// OpenPages maps the file of the pages stored at path, e.g. after the process has been restarted.
+// Pages of another path that have been opened before are released.
+func (d *MmapDiskManager) OpenPages(path string) error {
+	if d.file != nil {
+		d.ReleasePages(d.path)
+	}
+
+	return d.open(path, false)
+}
+
+// ReleasePages unmaps and closes the file of the pages stored at path, e.g. after the store has been deleted.
+// Pages that have been read before must have been dropped, their data is no longer mapped.
+func (d *MmapDiskManager) ReleasePages(path string) {
+	if d.file == nil || filepath.Clean(path) != d.path {
+		return
+	}
+
+	for _, mapping := range append(d.retired, d.mapping) {
+		unmapFile(mapping)
+	}
+	d.file.Close()
+	*d = MmapDiskManager{nextPageId: 1, maxPages: d.maxPages}
+}
+
+// StoredPages returns the ids of the pages stored at path and the total size of their data in bytes
+func (d *MmapDiskManager) StoredPages(path string) ([]PageID, int64, error) {
+	if d.file == nil || filepath.Clean(path) != d.path {
+		return nil, 0, os.ErrNotExist
+	}
+
+	var pageIDs []PageID
+	size := int64(0)
+	for id := 1; id < d.nextPageId; id++ {
+		offset, ok := d.allocated(PageID(id))
+		if ok {
+			pageIDs = append(pageIDs, PageID(id))
+			size += int64(binary.LittleEndian.Uint32(d.mapping[offset+4 : offset+8]))
+		}
+	}
+
+	return pageIDs, size, nil
+}
+
+// SetMaxPages sets the number of pages that can be allocated. Pages that are allocated already are kept.
+func (d *MmapDiskManager) SetMaxPages(maxPages int) {
+	d.maxPages = maxPages
+}
+
+// AllocatedPages returns the number of pages that are allocated
+func (d *MmapDiskManager) AllocatedPages() int {
+	return d.nextPageId - 1 - len(d.freePageIds)
+}
+
+// open maps the file at path, which is created if create is set and it does not exist yet
+func (d *MmapDiskManager) open(path string, create bool) error {
+	name := filepath.Clean(path) + mmapDataFile
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if os.IsNotExist(err) && create {
+		err = os.MkdirAll(path, os.ModePerm)
+		if err != nil {
+			return err
+		}
+		file, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			err = initPageFile(file)
+		}
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() < int64(mmapSlotSize) || info.Size()%int64(mmapSlotSize) != 0 {
+		file.Close()
+		return ErrInvalidPageFile
+	}
+	mapping, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if !bytes.HasPrefix(mapping, []byte(mmapMagic)) {
+		unmapFile(mapping)
+		file.Close()
+		return ErrInvalidPageFile
+	}
+
+	*d = MmapDiskManager{path: filepath.Clean(path), file: file, mapping: mapping, nextPageId: 1, maxPages: d.maxPages}
+	for id := 1; id < len(mapping)/mmapSlotSize; id++ {
+		if mapping[id*mmapSlotSize] == slotAllocated {
+			d.nextPageId = id + 1
+		}
+	}
+	for id := 1; id < d.nextPageId; id++ {
+		if _, ok := d.allocated(PageID(id)); !ok {
+			d.freePageIds = append(d.freePageIds, PageID(id))
+		}
+	}
+
+	return nil
+}
+
+// grow doubles the size of the file and maps it again
+func (d *MmapDiskManager) grow() error {
+	size := 2 * len(d.mapping)
+	err := d.file.Truncate(int64(size))
+	if err != nil {
+		return err
+	}
+	mapping, err := mapFile(d.file, size)
+	if err != nil {
+		return err
+	}
+
+	d.retired = append(d.retired, d.mapping)
+	d.mapping = mapping
+	return nil
+}
+
+// allocated returns the offset of the slot of an allocated page
+func (d *MmapDiskManager) allocated(pageID PageID) (int, bool) {
+	offset := int(pageID) * mmapSlotSize
+	if pageID < 1 || offset+mmapSlotSize > len(d.mapping) || d.mapping[offset] != slotAllocated {
+		return 0, false
+	}
+
+	return offset, true
+}
+
+// initPageFile writes the first slot of a new file and makes room for the first pages
+func initPageFile(file *os.File) error {
+	err := file.Truncate(int64(mmapInitialSlots * mmapSlotSize))
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt([]byte(mmapMagic), 0)
+	return err
+}
+
+// NewMmapDiskManager returns a disk manager without pages that allocates up to DiskMaxNumPages pages. The file is
+// created by the first AllocatePage or mapped by OpenPages.
+func NewMmapDiskManager() *MmapDiskManager {
+	return &MmapDiskManager{nextPageId: 1, maxPages: DiskMaxNumPages}
+}
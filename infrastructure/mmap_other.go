@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package infrastructure
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile maps the first size bytes of file read-only. Memory-mapped pages are only supported on Linux, elsewhere
+// an MmapDiskManager cannot open or create its file.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	return nil, errors.New("memory-mapped pages are not supported")
+}
+
+func unmapFile(mapping []byte) error {
+	return nil
+}
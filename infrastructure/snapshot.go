@@ -0,0 +1,111 @@
+package infrastructure
+
+import (
+	"errors"
+)
+
+// ErrSnapshotInProgress is returned by Begin if a snapshot has been started and not ended yet
+var ErrSnapshotInProgress = errors.New("snapshot in progress")
+
+// SnapshotDiskManager preserves the pages of a snapshot while they are overwritten (copy-on-write).
+// Between Begin and End, the first write of a page that belongs to the snapshot saves the page as it was stored
+// before, so ReadSnapshot returns every page as it was at Begin. Pages are kept as they are stored by the wrapped
+// disk manager, e.g. compressed and encrypted.
+type SnapshotDiskManager struct {
+	diskManager DiskManager
+	active      bool
+	pending     map[PageID]bool   // Pages of the snapshot that have not been read with ReadSnapshot yet
+	preImages   map[PageID][]byte // Pending pages that have been overwritten since Begin
+}
+
+// ReadPage reads a page with the wrapped DiskManager
+func (s *SnapshotDiskManager) ReadPage(pageID PageID) (*Page, error) {
+	return s.diskManager.ReadPage(pageID)
+}
+
+// WritePage preserves the stored page if it belongs to the snapshot and writes it with the wrapped DiskManager
+func (s *SnapshotDiskManager) WritePage(page *Page) error {
+	s.preserve(page.Id)
+	return s.diskManager.WritePage(page)
+}
+
+// AllocatePage allocates a page with the wrapped DiskManager
+func (s *SnapshotDiskManager) AllocatePage(path string) (*PageID, error) {
+	return s.diskManager.AllocatePage(path)
+}
+
+// DeallocatePage preserves the stored page if it belongs to the snapshot and deallocates it with the wrapped DiskManager
+func (s *SnapshotDiskManager) DeallocatePage(pageID PageID) {
+	s.preserve(pageID)
+	s.diskManager.DeallocatePage(pageID)
+}
+
+// Sync syncs the wrapped DiskManager
+func (s *SnapshotDiskManager) Sync() error {
+	return s.diskManager.Sync()
+}
+
+// preserve saves the stored page before it is changed for the first time during a snapshot
+func (s *SnapshotDiskManager) preserve(pageID PageID) {
+	if !s.pending[pageID] {
+		return
+	}
+	if _, ok := s.preImages[pageID]; ok {
+		return
+	}
+
+	stored, err := s.diskManager.ReadPage(pageID)
+	if err != nil {
+		return // Not stored yet, ReadSnapshot will fail for it
+	}
+	s.preImages[pageID] = append([]byte{}, stored.GetData()...)
+}
+
+// Begin starts a snapshot of the given pages as they are stored now. Pages that are still dirty in a buffer pool
+// have to be flushed before.
+func (s *SnapshotDiskManager) Begin(pageIDs []PageID) error {
+	if s.active {
+		return ErrSnapshotInProgress
+	}
+
+	s.active = true
+	s.pending = make(map[PageID]bool, len(pageIDs))
+	s.preImages = make(map[PageID][]byte)
+	for _, pageID := range pageIDs {
+		s.pending[pageID] = true
+	}
+
+	return nil
+}
+
+// ReadSnapshot returns a page of the snapshot as it was stored at Begin. Every page can only be read once,
+// afterwards it is no longer preserved.
+func (s *SnapshotDiskManager) ReadSnapshot(pageID PageID) ([]byte, error) {
+	if !s.pending[pageID] {
+		return nil, errors.New("page is not part of the snapshot")
+	}
+	delete(s.pending, pageID)
+
+	if data, ok := s.preImages[pageID]; ok {
+		delete(s.preImages, pageID)
+		return data, nil
+	}
+
+	stored, err := s.diskManager.ReadPage(pageID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, stored.GetData()...), nil
+}
+
+// End ends the snapshot and drops the pages that have not been read
+func (s *SnapshotDiskManager) End() {
+	s.active = false
+	s.pending = nil
+	s.preImages = nil
+}
+
+// NewSnapshotDiskManager returns a disk manager that stores pages with diskManager and can take snapshots of them
+func NewSnapshotDiskManager(diskManager DiskManager) *SnapshotDiskManager {
+	return &SnapshotDiskManager{diskManager: diskManager}
+}
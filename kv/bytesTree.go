@@ -0,0 +1,105 @@
+package kv
+
+// BytesTree is a named tree whose keys are byte strings of up to MaxKeyLength bytes, e.g. URLs, instead of ints.
+// The keys are stored as they are and sorted byte-wise, so a leaf stores the prefix its keys share only once and inner
+// nodes only keep the shortest separator, as for int keys. It shares the pages of its store like the other named
+// trees, but its changes are not recorded for Watch, and it has neither secondary indexes nor pairs that expire.
+type BytesTree struct {
+	tree *BpTreeImpl
+}
+
+// CreateBytesTree adds an empty BytesTree called name to the store and returns it. The name is shared with the
+// trees created with CreateTree.
+func (bpTree *BpTreeImpl) CreateBytesTree(name string) (_ *BytesTree, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	tree, err := bpTree.createTree(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &BytesTree{tree}, nil
+}
+
+// OpenBytesTree returns the BytesTree called name. It returns ErrKeyType for a tree created with CreateTree.
+func (bpTree *BpTreeImpl) OpenBytesTree(name string) (_ *BytesTree, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	tree, err := bpTree.openTree(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return &BytesTree{tree}, nil
+}
+
+// Put inserts key with the given value. It returns ErrSameKeyTwice if the key exists and ErrKeyTooLong if it is
+// longer than MaxKeyLength bytes.
+func (bytesTree *BytesTree) Put(key []byte, value [10]byte) (err error) {
+	bpTree := bytesTree.tree
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLong
+	}
+	err = bpTree.reserve(1)
+	if err != nil {
+		return err
+	}
+	err = bpTree.put(string(key), value)
+	if err != nil {
+		return err
+	}
+
+	return bpTree.syncIfDurable()
+}
+
+// Get returns the value of key or ErrNotFound
+func (bytesTree *BytesTree) Get(key []byte) (_ [10]byte, err error) {
+	bpTree := bytesTree.tree
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	return bpTree.get(string(key))
+}
+
+// Delete removes key from the tree or returns ErrNotFound
+func (bytesTree *BytesTree) Delete(key []byte) (err error) {
+	bpTree := bytesTree.tree
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	err = bpTree.delete(string(key))
+	if err != nil {
+		return err
+	}
+
+	return bpTree.syncIfDurable()
+}
+
+// Scan calls fn for every key in [start, end] in byte-wise ascending order, until fn returns false. A nil end scans
+// to the last key. Like for BpTreeImpl.Scan, the store is locked while fn runs.
+func (bytesTree *BytesTree) Scan(start []byte, end []byte, fn func(key []byte, value [10]byte) bool) (err error) {
+	bpTree := bytesTree.tree
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	return bpTree.scanKeys(string(start), func(key string, value [10]byte, expires int64) bool {
+		if end != nil && key > string(end) {
+			return false
+		}
+		return fn([]byte(key), value)
+	})
+}
+
+// Close writes the catalog entry of the tree and its dirty pages like BpTreeImpl.Close. It does not close the store.
+func (bytesTree *BytesTree) Close() error {
+	return bytesTree.tree.Close()
+}
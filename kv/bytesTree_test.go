@@ -0,0 +1,115 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesTree_URLKeys_SortedAndPrefixShared(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	pages, err := bpTreeImpl.CreateBytesTree("pages")
+	assert.Nil(t, err)
+
+	url := func(i int) []byte { return []byte(fmt.Sprintf("https://example.com/articles/%05d/comments", i)) }
+	for i := 0; i < 500; i++ {
+		key := i * 7919 % 500
+		assert.Nil(t, pages.Put(url(key), [10]byte{byte(key)}))
+	}
+
+	for i := 0; i < 500; i++ {
+		value, err := pages.Get(url(i))
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(i)}, value)
+	}
+	var keys [][]byte
+	assert.Nil(t, pages.Scan(nil, nil, func(key []byte, value [10]byte) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, 500, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, url(i), key)
+	}
+	assert.Nil(t, pages.tree.Verify())
+
+	// The leaves store the shared prefix once, the root only the bytes that tell its children apart
+	leaf, _ := pages.tree.findLeaf(string(url(250)))
+	assert.Equal(t, 1, bytes.Count(leaf.serializeNode(), []byte("https://example.com/articles/")))
+	root := pages.tree.pager.getNodeFromPageId(pages.tree.RootPageId)
+	for i := 0; i < root.numKeys; i++ {
+		assert.Less(t, len(root.Keys[i]), len(url(0)))
+	}
+}
+
+func TestBytesTree_Scan_Bounds(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	words, _ := bpTreeImpl.CreateBytesTree("words")
+	for _, word := range []string{"b", "ab", "a", "abc", "ba", "c"} {
+		assert.Nil(t, words.Put([]byte(word), [10]byte{}))
+	}
+
+	var scanned []string
+	assert.Nil(t, words.Scan([]byte("ab"), []byte("ba"), func(key []byte, value [10]byte) bool {
+		scanned = append(scanned, string(key))
+		return true
+	}))
+
+	assert.Equal(t, []string{"ab", "abc", "b", "ba"}, scanned)
+}
+
+func TestBytesTree_Errors(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	pages, _ := bpTreeImpl.CreateBytesTree("pages")
+
+	assert.Equal(t, ErrKeyTooLong, pages.Put(make([]byte, MaxKeyLength+1), [10]byte{}))
+	assert.Nil(t, pages.Put([]byte("a"), [10]byte{}))
+	assert.Equal(t, ErrSameKeyTwice, pages.Put([]byte("a"), [10]byte{}))
+	assert.Nil(t, pages.Delete([]byte("a")))
+	assert.Equal(t, ErrNotFound, pages.Delete([]byte("a")))
+	_, err := pages.Get([]byte("a"))
+	assert.Equal(t, ErrNotFound, err)
+
+	// Leaves of the longest keys that share no prefix still fit into their pages
+	for i := 0; i < 3*MAX_BRANCHING_FACTOR; i++ {
+		key := bytes.Repeat([]byte{byte(255 - i)}, MaxKeyLength)
+		assert.Nil(t, pages.Put(key, [10]byte{byte(i)}))
+	}
+	assert.Nil(t, pages.tree.Verify())
+	value, _ := pages.Get(bytes.Repeat([]byte{250}, MaxKeyLength))
+	assert.Equal(t, [10]byte{5}, value)
+}
+
+func TestOpenBytesTree_Reopened_KeepsPairsAndKeyType(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	pages, _ := bpTreeImpl.CreateBytesTree("pages")
+	bpTreeImpl.CreateTree("users")
+	for i := 0; i < 100; i++ {
+		pages.Put([]byte(fmt.Sprintf("https://example.com/%d", i)), [10]byte{byte(i)})
+	}
+	_, err := bpTreeImpl.Compact()
+	assert.Nil(t, err)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, _ := bpTreeImpl.Open(".")
+	pages, err = reopened.OpenBytesTree("pages")
+	assert.Nil(t, err)
+	value, err := pages.Get([]byte("https://example.com/42"))
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{42}, value)
+	assert.Nil(t, pages.tree.Verify())
+
+	_, err = reopened.OpenTree("pages")
+	assert.Equal(t, ErrKeyType, err)
+	_, err = reopened.OpenBytesTree("users")
+	assert.Equal(t, ErrKeyType, err)
+	_, err = reopened.OpenBytesTree("missing")
+	assert.Equal(t, ErrTreeNotFound, err)
+	assert.Equal(t, uint64(0), reopened.Sequence)
+}
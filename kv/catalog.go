@@ -0,0 +1,368 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"main/infrastructure"
+)
+
+// A store holds one tree described by the KVSTORE header and any number of named trees. The named trees are listed
+// in the catalog, which maps their names to their root page ids, the root page ids of their indexes and whether their
+// keys are byte strings, see BytesTree. The catalog is stored on a chain of pages that grows with it.
+// All trees share the pages, the buffer pool and the disk manager of the store.
+// The page id of the first catalog page is kept in the header, it is 0 until the first named tree is created.
+
+var (
+	// ErrTreeExists is returned by CreateTree if the store already holds a tree with that name
+	ErrTreeExists = errors.New(Package + " - tree already exists")
+
+	// ErrTreeNotFound is returned when the store does not hold a tree with the given name
+	ErrTreeNotFound = errors.New(Package + " - tree not found")
+
+	// ErrInvalidTreeName is returned for an empty tree name or one that is longer than MaxTreeNameLength bytes
+	ErrInvalidTreeName = errors.New(Package + " - tree name is not valid")
+
+	// ErrKeyType is returned when a tree with byte-string keys is opened with OpenTree or a tree with int keys with
+	// OpenBytesTree
+	ErrKeyType = errors.New(Package + " - tree has keys of another type")
+)
+
+// MaxTreeNameLength is the length of the longest tree name in bytes. It bounds the size of a record in KVCHANGES.
+const MaxTreeNameLength = 255
+
+// catalogEntry describes a named tree
+type catalogEntry struct {
+	RootPageId int
+	Indexes    map[string]int
+	ByteKeys   bool // The tree is a BytesTree
+}
+
+// CreateTree adds an empty tree called name to the store and returns it. The tree is used like the store itself,
+// with Put, Get, Scan and so on, and is stored in the same pages. It can be called on the store or on any of its
+// named trees.
+func (bpTree *BpTreeImpl) CreateTree(name string) (_ *BpTreeImpl, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	return bpTree.createTree(name, false)
+}
+
+// createTree adds an empty named tree with int or byte-string keys to the store
+func (bpTree *BpTreeImpl) createTree(name string, byteKeys bool) (*BpTreeImpl, error) {
+	if name == "" || len(name) > MaxTreeNameLength {
+		return nil, ErrInvalidTreeName
+	}
+	store := bpTree.storeTree()
+	catalog, err := store.readCatalog()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := catalog[name]; ok {
+		return nil, ErrTreeExists
+	}
+	// The root page id is not known yet, the largest one takes the most space
+	catalog[name] = catalogEntry{RootPageId: math.MaxInt64, ByteKeys: byteKeys}
+	data, err := encodeCatalog(catalog)
+	if err != nil {
+		return nil, err
+	}
+	_, catalogPageIds, err := store.catalogChain()
+	if err != nil {
+		return nil, err
+	}
+	if bpTree.pager.freePages() < 1+catalogPages(len(data))-len(catalogPageIds) {
+		return nil, ErrStoreFull
+	}
+
+	root := Node{IsLeaf: true, PageId: bpTree.pager.createNewNode(store.Path).PageId}
+	bpTree.pager.writeNodeToPage(&root)
+	catalog[name] = catalogEntry{RootPageId: root.PageId, ByteKeys: byteKeys}
+	err = store.writeCatalog(catalog)
+	if err != nil {
+		bpTree.pager.pool.DeletePage(infrastructure.PageID(root.PageId))
+		return nil, err
+	}
+
+	tree := store.namedTree(name, catalog[name])
+	err = store.syncIfDurable()
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// OpenTree returns the named tree called name. Every call returns the same tree as long as the store is open, so
+// the indexes registered on it are kept. A BytesTree is opened with OpenBytesTree.
+func (bpTree *BpTreeImpl) OpenTree(name string) (_ *BpTreeImpl, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	return bpTree.openTree(name, false)
+}
+
+// openTree returns the named tree called name if its keys are byte strings or ints as requested
+func (bpTree *BpTreeImpl) openTree(name string, byteKeys bool) (*BpTreeImpl, error) {
+	store := bpTree.storeTree()
+	tree, ok := store.trees[name]
+	if !ok {
+		catalog, err := store.readCatalog()
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := catalog[name]
+		if !ok {
+			return nil, ErrTreeNotFound
+		}
+		tree = store.namedTree(name, entry)
+	}
+
+	if tree.byteKeys != byteKeys {
+		return nil, ErrKeyType
+	}
+	return tree, nil
+}
+
+// DropTree removes the named tree called name from the store and frees its pages and those of its indexes.
+// The tree must not be used anymore afterwards.
+func (bpTree *BpTreeImpl) DropTree(name string) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	store := bpTree.storeTree()
+	catalog, err := store.readCatalog()
+	if err != nil {
+		return err
+	}
+	entry, ok := catalog[name]
+	if !ok {
+		return ErrTreeNotFound
+	}
+
+	delete(catalog, name)
+	err = store.writeCatalog(catalog)
+	if err != nil {
+		return err
+	}
+	delete(store.trees, name)
+
+	bpTree.pager.freeTree(entry.RootPageId)
+	for _, rootPageId := range entry.Indexes {
+		bpTree.pager.freeIndex(rootPageId)
+	}
+
+	return store.syncIfDurable()
+}
+
+// ListTrees returns the names of the named trees of the store in ascending order
+func (bpTree *BpTreeImpl) ListTrees() (_ []string, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	catalog, err := bpTree.storeTree().readCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// storeTree returns the tree described by the header, i.e. bpTree itself unless it is a named tree
+func (bpTree *BpTreeImpl) storeTree() *BpTreeImpl {
+	if bpTree.store != nil {
+		return bpTree.store
+	}
+
+	return bpTree
+}
+
+// namedTree returns a tree for a catalog entry and remembers it for OpenTree
+func (bpTree *BpTreeImpl) namedTree(name string, entry catalogEntry) *BpTreeImpl {
+	tree := &BpTreeImpl{
+		MaxMem:     bpTree.MaxMem,
+		MaxPages:   bpTree.MaxPages,
+		Path:       bpTree.Path,
+		RootPageId: entry.RootPageId,
+		Encrypted:  bpTree.Encrypted,
+		Indexes:    entry.Indexes,
+		options:    bpTree.options,
+		name:       name,
+		byteKeys:   entry.ByteKeys,
+		store:      bpTree,
+		pager:      bpTree.pager,
+	}
+
+	if bpTree.trees == nil {
+		bpTree.trees = make(map[string]*BpTreeImpl)
+	}
+	bpTree.trees[name] = tree
+	return tree
+}
+
+// writeHeader persists where the tree is: the KVSTORE header for the store, the catalog for a named tree
+func (bpTree *BpTreeImpl) writeHeader() error {
+	if bpTree.store == nil {
+		return CreateKVStore(*bpTree)
+	}
+
+	catalog, err := bpTree.store.readCatalog()
+	if err != nil {
+		return err
+	}
+	if _, ok := catalog[bpTree.name]; !ok {
+		return ErrTreeNotFound
+	}
+
+	catalog[bpTree.name] = catalogEntry{RootPageId: bpTree.RootPageId, Indexes: bpTree.Indexes, ByteKeys: bpTree.byteKeys}
+	return bpTree.store.writeCatalog(catalog)
+}
+
+// The catalog is gob encoded and split over a chain of pages. Every page starts with the page id of the next page,
+// 0 on the last one, and the length of the part of the catalog it holds.
+const (
+	catalogPageHeaderSize = 12
+	catalogPageCapacity   = infrastructure.PageSize - catalogPageHeaderSize
+)
+
+// readCatalog returns the entries of the catalog of the store by name
+func (bpTree *BpTreeImpl) readCatalog() (map[string]catalogEntry, error) {
+	catalog := make(map[string]catalogEntry)
+	data, _, err := bpTree.catalogChain()
+	if err != nil || data == nil {
+		return catalog, err
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&catalog)
+	return catalog, err
+}
+
+// catalogChain returns the encoded catalog of the store and the ids of the pages it is stored on, in order
+func (bpTree *BpTreeImpl) catalogChain() ([]byte, []int, error) {
+	var data []byte
+	var pageIds []int
+	for pageId := bpTree.CatalogPageId; pageId != 0; {
+		if len(pageIds) > bpTree.pager.maxPages {
+			return nil, nil, fmt.Errorf("%w: the catalog pages form a cycle", ErrCorrupt)
+		}
+
+		page := bpTree.pager.fetchPage(pageId, infrastructure.AccessNormal)
+		content := page.GetData()
+		next := int(binary.BigEndian.Uint64(content))
+		length := int(binary.BigEndian.Uint32(content[8:]))
+		if length > len(content)-catalogPageHeaderSize {
+			bpTree.pager.pool.UnpinPage(page.GetId(), false)
+			return nil, nil, fmt.Errorf("%w: page %d: catalog part of %d bytes", ErrCorrupt, pageId, length)
+		}
+		data = append(data, content[catalogPageHeaderSize:catalogPageHeaderSize+length]...)
+		bpTree.pager.pool.UnpinPage(page.GetId(), false)
+
+		pageIds = append(pageIds, pageId)
+		pageId = next
+	}
+
+	return data, pageIds, nil
+}
+
+// writeCatalog stores the catalog of the store on its chain of pages. The first page is allocated with the first
+// named tree, which is persisted in the header right away, and stays the first page of the chain from then on.
+func (bpTree *BpTreeImpl) writeCatalog(catalog map[string]catalogEntry) error {
+	data, err := encodeCatalog(catalog)
+	if err != nil {
+		return err
+	}
+	_, pageIds, err := bpTree.catalogChain()
+	if err != nil {
+		return err
+	}
+
+	pageIds = bpTree.pager.writeCatalogPages(bpTree.Path, data, pageIds)
+	if bpTree.CatalogPageId != 0 {
+		return nil
+	}
+
+	bpTree.CatalogPageId = pageIds[0]
+	err = bpTree.pager.pool.FlushAllpages()
+	if err != nil {
+		return err
+	}
+	return CreateKVStore(*bpTree)
+}
+
+// encodeCatalog returns the encoded catalog as it is split over the catalog pages
+func encodeCatalog(catalog map[string]catalogEntry) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// catalogPages returns the number of pages an encoded catalog of the given size is stored on
+func catalogPages(size int) int {
+	return (size + catalogPageCapacity - 1) / catalogPageCapacity
+}
+
+// catalogGrowth returns the number of pages the catalog may grow by when the entry of the tree grows by the given
+// number of bytes, e.g. by the name of a new index. The numbers of an entry grow by a few bytes at most. The store
+// itself needs none, its entry is the header.
+func (bpTree *BpTreeImpl) catalogGrowth(size int) int {
+	if bpTree.store == nil {
+		return 0
+	}
+
+	return (size+2*binary.MaxVarintLen64)/catalogPageCapacity + 1
+}
+
+// writeCatalogPages writes an encoded catalog to a chain of pages and returns the chain. Pages are added to the end
+// of the given chain or freed from it as needed, so its first page is kept.
+func (p *pager) writeCatalogPages(path string, data []byte, pageIds []int) []int {
+	pages := catalogPages(len(data))
+	for len(pageIds) < pages {
+		page := p.newPage(path)
+		p.pool.UnpinPage(page.GetId(), true)
+		pageIds = append(pageIds, int(page.GetId()))
+	}
+	for _, pageId := range pageIds[pages:] {
+		p.pool.DeletePage(infrastructure.PageID(pageId))
+	}
+	pageIds = pageIds[:pages]
+
+	for i, pageId := range pageIds {
+		next := 0
+		if i+1 < len(pageIds) {
+			next = pageIds[i+1]
+		}
+		part := data[i*catalogPageCapacity:]
+		if len(part) > catalogPageCapacity {
+			part = part[:catalogPageCapacity]
+		}
+
+		content := make([]byte, infrastructure.PageSize)
+		binary.BigEndian.PutUint64(content, uint64(next))
+		binary.BigEndian.PutUint32(content[8:], uint32(len(part)))
+		copy(content[catalogPageHeaderSize:], part)
+
+		page := p.fetchPage(pageId, infrastructure.AccessNormal)
+		page.SetData(content)
+		p.pool.UnpinPage(page.GetId(), true)
+	}
+
+	return pageIds
+}
@@ -0,0 +1,225 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTree_Trees_Independent(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	users, err := bpTreeImpl.CreateTree("users")
+	assert.Nil(t, err)
+	sessions, err := bpTreeImpl.CreateTree("sessions")
+	assert.Nil(t, err)
+
+	for key := 0; key < 100; key++ {
+		assert.Nil(t, users.Put(key, [10]byte{'u'}))
+		assert.Nil(t, sessions.Put(key, [10]byte{'s'}))
+	}
+	assert.Nil(t, bpTreeImpl.Put(1, [10]byte{'d'}))
+
+	value, _ := users.Get(42)
+	assert.Equal(t, [10]byte{'u'}, value)
+	value, _ = sessions.Get(42)
+	assert.Equal(t, [10]byte{'s'}, value)
+	assert.Equal(t, 100, treeStats(t, users).Keys)
+	assert.Equal(t, 1, treeStats(t, bpTreeImpl).Keys)
+	assert.Nil(t, users.Verify())
+	assert.Nil(t, sessions.Verify())
+
+	_, err = bpTreeImpl.CreateTree("users")
+	assert.Equal(t, ErrTreeExists, err)
+	_, err = bpTreeImpl.CreateTree("")
+	assert.Equal(t, ErrInvalidTreeName, err)
+	_, err = bpTreeImpl.CreateTree(strings.Repeat("x", MaxTreeNameLength+1))
+	assert.Equal(t, ErrInvalidTreeName, err)
+}
+
+func TestOpenTree_Reopened_KeepsPairs(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	audit, _ := bpTreeImpl.CreateTree("audit")
+	for key := 0; key < 200; key++ {
+		audit.Put(key, [10]byte{byte(key)})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(".")
+	assert.Nil(t, err)
+	audit, err = reopened.OpenTree("audit")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, treeStats(t, audit).Keys)
+	assert.Nil(t, audit.Verify())
+	again, _ := reopened.OpenTree("audit")
+	assert.Same(t, audit, again)
+	_, err = reopened.OpenTree("missing")
+	assert.Equal(t, ErrTreeNotFound, err)
+}
+
+func TestDropTree_FreesPagesAndRemovesName(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.CreateTree("a")
+	b, _ := bpTreeImpl.CreateTree("b")
+	bpTreeImpl.pager.pool.FlushAllpages()
+	before, _, _ := bpTreeImpl.pager.storedPages(".")
+	for key := 0; key < 100; key++ {
+		b.Put(key, [10]byte{1})
+	}
+
+	assert.Nil(t, bpTreeImpl.DropTree("b"))
+
+	bpTreeImpl.pager.pool.FlushAllpages()
+	after, _, _ := bpTreeImpl.pager.storedPages(".")
+	assert.Equal(t, len(before)-1, len(after))
+	names, _ := bpTreeImpl.ListTrees()
+	assert.Equal(t, []string{"a"}, names)
+	assert.Equal(t, ErrTreeNotFound, bpTreeImpl.DropTree("b"))
+}
+
+func TestListTrees_Sorted(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	names, err := bpTreeImpl.ListTrees()
+	assert.Nil(t, err)
+	assert.Empty(t, names)
+
+	bpTreeImpl.CreateTree("users")
+	sessions, _ := bpTreeImpl.CreateTree("sessions")
+	sessions.CreateTree("audit")
+
+	names, _ = bpTreeImpl.ListTrees()
+	assert.Equal(t, []string{"audit", "sessions", "users"}, names)
+}
+
+func TestCreateTree_ManyTrees_CatalogChained(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	names := []string{}
+	for i := 0; i < 100; i++ {
+		names = append(names, fmt.Sprintf("%s%03d", strings.Repeat("x", 50), i))
+		tree, err := bpTreeImpl.CreateTree(names[i])
+		assert.Nil(t, err)
+		assert.Nil(t, tree.Put(i, [10]byte{byte(i)}))
+	}
+	_, catalogPageIds, _ := bpTreeImpl.catalogChain()
+	assert.Greater(t, len(catalogPageIds), 1)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(".")
+	assert.Nil(t, err)
+	listed, _ := reopened.ListTrees()
+	assert.Equal(t, names, listed)
+	for i, name := range names {
+		tree, err := reopened.OpenTree(name)
+		assert.Nil(t, err)
+		value, err := tree.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(i)}, value)
+	}
+
+	for _, name := range names[1:] {
+		assert.Nil(t, reopened.DropTree(name))
+	}
+	_, catalogPageIds, _ = reopened.catalogChain()
+	assert.Equal(t, 1, len(catalogPageIds))
+	assert.Equal(t, reopened.CatalogPageId, catalogPageIds[0])
+	listed, _ = reopened.ListTrees()
+	assert.Equal(t, names[:1], listed)
+}
+
+func TestCreateTree_StoreFull_ReturnsErrStoreFull(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 5})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	assert.Nil(t, bpTreeImpl.Put(1, [10]byte{1}))
+
+	var err error
+	created := []string{}
+	for err == nil {
+		name := strconv.Itoa(len(created))
+		_, err = bpTreeImpl.CreateTree(name)
+		if err == nil {
+			created = append(created, name)
+		}
+	}
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Equal(t, 3, len(created))
+	trees, err := bpTreeImpl.ListTrees()
+	assert.Nil(t, err)
+	assert.Equal(t, created, trees)
+	assert.Nil(t, bpTreeImpl.Update(1, [10]byte{2}))
+}
+
+func TestCompact_NamedTrees_KeepsPairs(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	users, _ := bpTreeImpl.CreateTree("users")
+	assert.Nil(t, users.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 300; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+		users.Put(key, [10]byte{byte(key % 2)})
+	}
+	for key := 0; key < 300; key += 2 {
+		users.Delete(key)
+	}
+
+	_, err := users.Compact()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 300, treeStats(t, bpTreeImpl).Keys)
+	assert.Equal(t, 150, treeStats(t, users).Keys)
+	assert.Nil(t, users.Verify())
+	odd, _ := users.LookupBy("first", 1)
+	assert.Equal(t, 150, len(odd))
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, _ := bpTreeImpl.Open(".")
+	users, err = reopened.OpenTree("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 150, treeStats(t, users).Keys)
+}
+
+func TestCheckpoint_NamedTrees_InCopy(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	users, _ := bpTreeImpl.CreateTree("users")
+	for key := 0; key < 100; key++ {
+		users.Put(key, [10]byte{1})
+	}
+
+	dest := t.TempDir()
+	assert.Nil(t, users.Checkpoint(dest))
+
+	copied := openCheckpoint(t, bpTreeImpl, dest)
+	defer copied.DeleteStore(dest)
+	users, err := copied.OpenTree("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 100, treeStats(t, users).Keys)
+}
+
+func TestCheckpoint_ChainedCatalog_InCopy(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 100; i++ {
+		bpTreeImpl.CreateTree(fmt.Sprintf("%s%03d", strings.Repeat("x", 50), i))
+	}
+	names, _ := bpTreeImpl.ListTrees()
+
+	dest := t.TempDir()
+	assert.Nil(t, bpTreeImpl.Checkpoint(dest))
+
+	copied := openCheckpoint(t, bpTreeImpl, dest)
+	defer copied.DeleteStore(dest)
+	copiedNames, err := copied.ListTrees()
+	assert.Nil(t, err)
+	assert.Equal(t, names, copiedNames)
+}
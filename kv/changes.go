@@ -0,0 +1,389 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Every change of a pair gets a sequence number, which increases by one with every change in any tree of the store.
+// Changes are recorded while an operation runs and committed when it is done: they are appended to the change log
+// and passed to the subscriptions that watch them. The change log retains at least the last Options.ChangeLogSize
+// changes in memory and in the file KVCHANGES, so a subscription can be resumed from a sequence number, also after a
+// restart. With Options.Durable, KVCHANGES is synced before the header refers to the changes.
+// In KVCHANGES, every change is a record with a uint32 length followed by the sequence number, the kind, whether the
+// key existed, the key, the old and the new value, the expiry of the new value and the name of the tree. All integers
+// are big-endian.
+// Pairs that expire are reported as deleted when they are swept.
+
+// DefaultChangeLogSize is the number of changes retained if Options.ChangeLogSize is 0
+const DefaultChangeLogSize = 1000
+
+// watchBuffer is the number of changes a subscription buffers before it is closed as too slow
+const watchBuffer = 1024
+
+var (
+	// ErrChangesTruncated is returned by WatchFrom if changes after the given sequence number are not retained anymore
+	ErrChangesTruncated = errors.New(Package + " - changes are not retained anymore")
+
+	// ErrWatcherTooSlow is reported by Subscription.Err if the subscription was closed because its buffer was full
+	ErrWatcherTooSlow = errors.New(Package + " - watcher is too slow")
+)
+
+// ChangeKind tells how a pair was changed
+type ChangeKind int
+
+const (
+	ChangePut    ChangeKind = iota // The key was inserted or its value was replaced
+	ChangeDelete                   // The key was deleted or has expired
+)
+
+// Change is a committed change of a pair
+type Change struct {
+	Sequence uint64
+	Kind     ChangeKind
+	Key      int
+	Existed  bool     // Whether the key existed before the change
+	OldValue [10]byte // The value before the change if Existed
+	NewValue [10]byte // The value after a ChangePut
+	Expires  int64    // Expiry of the pair after a ChangePut in unix nanoseconds, 0 if it does not expire
+	tree     string
+}
+
+// Subscription delivers the changes of a key range of one tree on C, in the order of their sequence numbers.
+// C is closed when the subscription or the store is closed, or when the subscription falls behind by more than
+// watchBuffer changes. Err tells which one it was.
+type Subscription struct {
+	C     <-chan Change
+	c     chan Change
+	tree  string
+	start int
+	end   int
+	log   *changeLog
+	pager *pager
+	err   error
+}
+
+// changeLog retains the last committed changes and the subscriptions of a store
+type changeLog struct {
+	path     string
+	file     *os.File
+	size     int
+	written  int      // Number of records in the file, which is rewritten with the retained ones when it gets large
+	pending  []Change // Changes recorded since the last commit, by the running operation or one whose sync failed
+	logged   int      // Number of pending changes that have been written to the file already
+	retained []Change
+	watchers map[*Subscription]bool
+}
+
+// Watch subscribes to the changes of the keys in [start, end] that are committed from now on
+func (bpTree *BpTreeImpl) Watch(start int, end int) (*Subscription, error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+
+	return bpTree.watch(start, end, bpTree.storeTree().committedSequence())
+}
+
+// WatchFrom subscribes to the changes of the keys in [start, end] whose sequence number is greater than sequence.
+// Retained changes are delivered first, then new ones as they are committed. It returns ErrChangesTruncated if some of
+// the changes are not retained anymore, e.g. because the subscriber has been offline for too long; the subscriber
+// then has to read the whole range with Scan and watch from Sequence.
+func (bpTree *BpTreeImpl) WatchFrom(start int, end int, sequence uint64) (*Subscription, error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+
+	return bpTree.watch(start, end, sequence)
+}
+
+// LastSequence returns the sequence number of the last committed change of the store
+func (bpTree *BpTreeImpl) LastSequence() uint64 {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+
+	return bpTree.storeTree().committedSequence()
+}
+
+// committedSequence returns the sequence number of the last committed change. Changes that have been recorded but
+// not committed yet, because writing them to disk failed, are not counted.
+func (bpTree *BpTreeImpl) committedSequence() uint64 {
+	if bpTree.changes == nil {
+		return bpTree.Sequence
+	}
+
+	return bpTree.Sequence - uint64(len(bpTree.changes.pending))
+}
+
+func (bpTree *BpTreeImpl) watch(start int, end int, sequence uint64) (*Subscription, error) {
+	store := bpTree.storeTree()
+	log := store.changes
+	if log == nil {
+		return nil, ErrNotFound
+	}
+
+	// The retained changes have to reach from sequence to the last committed change, which they do not after a crash
+	// that lost the end of KVCHANGES
+	var replay []Change
+	committed := store.committedSequence()
+	if sequence < committed {
+		retained := log.retained
+		if len(retained) == 0 || retained[0].Sequence > sequence+1 || retained[len(retained)-1].Sequence < committed {
+			return nil, ErrChangesTruncated
+		}
+		replay = retained[sequence+1-retained[0].Sequence:]
+	}
+
+	s := &Subscription{c: make(chan Change, watchBuffer+len(replay)), tree: bpTree.name, start: start, end: end, log: log, pager: bpTree.pager}
+	s.C = s.c
+	for _, change := range replay {
+		if s.matches(change) {
+			s.c <- change
+		}
+	}
+	log.watchers[s] = true
+
+	return s, nil
+}
+
+// Close ends the subscription and closes C
+func (s *Subscription) Close() {
+	s.pager.mu.Lock()
+	defer s.pager.mu.Unlock()
+
+	s.close(nil)
+}
+
+// Err returns ErrWatcherTooSlow if the subscription was closed because it fell behind, nil otherwise
+func (s *Subscription) Err() error {
+	s.pager.mu.Lock()
+	defer s.pager.mu.Unlock()
+
+	return s.err
+}
+
+func (s *Subscription) matches(change Change) bool {
+	return change.tree == s.tree && change.Key >= s.start && change.Key <= s.end
+}
+
+// close closes C once
+func (s *Subscription) close(err error) {
+	if !s.log.watchers[s] {
+		return
+	}
+
+	delete(s.log.watchers, s)
+	s.err = err
+	close(s.c)
+}
+
+// record adds a change of the tree to the changes of the running operation and assigns its sequence number
+func (bpTree *BpTreeImpl) record(change Change) {
+	store := bpTree.storeTree()
+	if store.changes == nil || bpTree.byteKeys {
+		return // E.g. the tree of an index, changes only have int keys
+	}
+
+	store.Sequence++
+	change.Sequence = store.Sequence
+	change.tree = bpTree.name
+	store.changes.pending = append(store.changes.pending, change)
+}
+
+// commitChanges appends the changes recorded since the last commit to the change log and delivers them
+func (bpTree *BpTreeImpl) commitChanges() error {
+	log := bpTree.storeTree().changes
+	if log == nil || len(log.pending) == 0 {
+		return nil
+	}
+
+	err := log.logPending(false)
+	if err != nil {
+		return err
+	}
+
+	pending := log.pending
+	log.pending = nil
+	log.logged = 0
+	for _, change := range pending {
+		for s := range log.watchers {
+			if !s.matches(change) {
+				continue
+			}
+			select {
+			case s.c <- change:
+			default:
+				s.close(ErrWatcherTooSlow)
+			}
+		}
+	}
+
+	// Older changes are dropped in bulk, so that at least the last size changes are retained
+	log.retained = append(log.retained, pending...)
+	if len(log.retained) > 2*log.size {
+		log.retained = append([]Change{}, log.retained[len(log.retained)-log.size:]...)
+	}
+
+	if log.written > 2*log.size {
+		return log.rewrite()
+	}
+	return nil
+}
+
+// logPending appends the pending changes that are not in the file yet to it. With sync, the file is synced, so the
+// changes are not lost in a crash.
+func (log *changeLog) logPending(sync bool) error {
+	err := writeChanges(log.file, log.pending[log.logged:])
+	if err != nil {
+		return err
+	}
+	log.written += len(log.pending) - log.logged
+	log.logged = len(log.pending)
+
+	if sync {
+		return log.file.Sync()
+	}
+	return nil
+}
+
+// openChangeLog loads the changes retained at path. It returns the log and the sequence number of the last change.
+func openChangeLog(path string, size int) (*changeLog, uint64, error) {
+	if size <= 0 {
+		size = DefaultChangeLogSize
+	}
+	log := &changeLog{path: path, size: size, watchers: make(map[*Subscription]bool)}
+
+	file, err := os.Open(path + "/KVCHANGES")
+	if err == nil {
+		log.retained = readChanges(file, size)
+		file.Close()
+	} else if !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+
+	last := uint64(0)
+	if len(log.retained) > 0 {
+		last = log.retained[len(log.retained)-1].Sequence
+	}
+	return log, last, log.rewrite()
+}
+
+// rewrite replaces the file of the log atomically with the retained changes
+func (log *changeLog) rewrite() error {
+	if log.file != nil {
+		log.file.Close()
+	}
+
+	tmpPath := log.path + "/KVCHANGES.tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = writeChanges(file, log.retained)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, log.path+"/KVCHANGES")
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	log.file = file
+	log.written = len(log.retained)
+	return nil
+}
+
+// close closes the file of the log and all subscriptions
+func (log *changeLog) close() {
+	for s := range log.watchers {
+		s.close(nil)
+	}
+	if log.file != nil {
+		log.file.Close()
+		log.file = nil
+	}
+}
+
+func writeChanges(w io.Writer, changes []Change) error {
+	buffered := bufio.NewWriter(w)
+	for _, change := range changes {
+		buffered.Write(encodeChange(change))
+	}
+
+	return buffered.Flush()
+}
+
+// readChanges returns the last size changes of a change log file. A record that was only partially written, because
+// the process stopped, ends the log.
+func readChanges(r io.Reader, size int) []Change {
+	reader := bufio.NewReader(r)
+	var changes []Change
+
+	for {
+		change, err := readChange(reader)
+		if err != nil {
+			return changes
+		}
+
+		changes = append(changes, change)
+		if len(changes) > size {
+			changes = changes[1:]
+		}
+	}
+}
+
+// changeRecordSize is the size of a change record without its length and the name of the tree
+const changeRecordSize = 46
+
+// encodeChange returns the record of a change, including its length
+func encodeChange(change Change) []byte {
+	record := make([]byte, 4+changeRecordSize+len(change.tree))
+	binary.BigEndian.PutUint32(record[0:], uint32(changeRecordSize+len(change.tree)))
+	binary.BigEndian.PutUint64(record[4:], change.Sequence)
+	record[12] = byte(change.Kind)
+	if change.Existed {
+		record[13] = 1
+	}
+	binary.BigEndian.PutUint64(record[14:], uint64(change.Key))
+	copy(record[22:], change.OldValue[:])
+	copy(record[32:], change.NewValue[:])
+	binary.BigEndian.PutUint64(record[42:], uint64(change.Expires))
+	copy(record[50:], change.tree)
+
+	return record
+}
+
+// readChange reads the record of a change written by encodeChange
+func readChange(r io.Reader) (Change, error) {
+	var length [4]byte
+	_, err := io.ReadFull(r, length[:])
+	if err != nil {
+		return Change{}, err
+	}
+	// The length of a corrupt record must not make it allocate more than a record can take
+	if binary.BigEndian.Uint32(length[:]) < changeRecordSize || binary.BigEndian.Uint32(length[:]) > changeRecordSize+MaxTreeNameLength {
+		return Change{}, ErrBadValue
+	}
+	record := make([]byte, binary.BigEndian.Uint32(length[:]))
+	_, err = io.ReadFull(r, record)
+	if err != nil {
+		return Change{}, err
+	}
+
+	change := Change{
+		Sequence: binary.BigEndian.Uint64(record[0:]),
+		Kind:     ChangeKind(record[8]),
+		Existed:  record[9] == 1,
+		Key:      int(binary.BigEndian.Uint64(record[10:])),
+		Expires:  int64(binary.BigEndian.Uint64(record[38:])),
+		tree:     string(record[changeRecordSize:]),
+	}
+	copy(change.OldValue[:], record[18:28])
+	copy(change.NewValue[:], record[28:38])
+
+	return change, nil
+}
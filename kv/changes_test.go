@@ -0,0 +1,208 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receive returns the changes that are buffered in the subscription
+func receive(s *Subscription) []Change {
+	var changes []Change
+	for {
+		select {
+		case change, ok := <-s.C:
+			if !ok {
+				return changes
+			}
+			changes = append(changes, change)
+		default:
+			return changes
+		}
+	}
+}
+
+func TestWatch_Changes_Delivered(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	s, err := bpTreeImpl.Watch(10, 20)
+	assert.Nil(t, err)
+
+	bpTreeImpl.Put(10, [10]byte{1})
+	bpTreeImpl.Put(30, [10]byte{1})
+	bpTreeImpl.Update(10, [10]byte{2})
+	bpTreeImpl.Delete(10)
+
+	changes := receive(s)
+	assert.Equal(t, []Change{
+		{Sequence: 1, Kind: ChangePut, Key: 10, NewValue: [10]byte{1}},
+		{Sequence: 3, Kind: ChangePut, Key: 10, Existed: true, OldValue: [10]byte{1}, NewValue: [10]byte{2}},
+		{Sequence: 4, Kind: ChangeDelete, Key: 10, Existed: true, OldValue: [10]byte{2}},
+	}, changes)
+	assert.Equal(t, uint64(4), bpTreeImpl.LastSequence())
+}
+
+func TestWatch_FailedOperation_NoChange(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(1, [10]byte{1})
+	s, _ := bpTreeImpl.Watch(0, 100)
+
+	assert.Equal(t, ErrSameKeyTwice, bpTreeImpl.Put(1, [10]byte{2}))
+	assert.Equal(t, ErrNotFound, bpTreeImpl.Delete(2))
+	var batch WriteBatch
+	batch.Put(2, [10]byte{2})
+	batch.Delete(3)
+	assert.Equal(t, ErrNotFound, bpTreeImpl.Write(&batch))
+
+	assert.Empty(t, receive(s))
+	assert.Equal(t, uint64(1), bpTreeImpl.LastSequence())
+}
+
+func TestWatch_WriteBatch_ChangeForEveryOperation(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(5, [10]byte{5})
+	s, _ := bpTreeImpl.Watch(0, 100)
+
+	var batch WriteBatch
+	batch.Delete(5)
+	for key := 0; key < 20; key++ {
+		if key != 5 {
+			batch.Put(key, [10]byte{1})
+		}
+	}
+	assert.Nil(t, bpTreeImpl.Write(&batch))
+
+	changes := receive(s)
+	assert.Equal(t, 20, len(changes))
+	for i, change := range changes {
+		assert.Equal(t, uint64(i+2), change.Sequence)
+	}
+}
+
+func TestWatchFrom_Resume_ReplaysRetainedChanges(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 10; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, _ := bpTreeImpl.Open(".")
+	s, err := reopened.WatchFrom(0, 100, 6)
+	assert.Nil(t, err)
+	reopened.Put(10, [10]byte{1})
+
+	changes := receive(s)
+	assert.Equal(t, 5, len(changes))
+	assert.Equal(t, uint64(7), changes[0].Sequence)
+	assert.Equal(t, 10, changes[4].Key)
+	assert.Equal(t, uint64(11), reopened.LastSequence())
+}
+
+func TestWatchFrom_Truncated_Fails(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, Options{ChangeLogSize: 10})
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+
+	_, err := bpTreeImpl.WatchFrom(0, 100, 5)
+	assert.Equal(t, ErrChangesTruncated, err)
+
+	s, err := bpTreeImpl.WatchFrom(0, 100, 90)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(receive(s)))
+}
+
+func TestWatchFrom_LogEndLost_Truncated(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 10; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+	assert.Nil(t, os.Truncate("./KVCHANGES", 5*(4+changeRecordSize)))
+
+	reopened, _ := bpTreeImpl.Open(".")
+	_, err := reopened.WatchFrom(0, 100, 3)
+	assert.Equal(t, ErrChangesTruncated, err)
+	_, err = reopened.WatchFrom(0, 100, 7)
+	assert.Equal(t, ErrChangesTruncated, err)
+
+	s, err := reopened.WatchFrom(0, 100, 10)
+	assert.Nil(t, err)
+	reopened.Put(10, [10]byte{1})
+	assert.Equal(t, 1, len(receive(s)))
+}
+
+func TestReadChange_CorruptLength_ReturnsErrBadValue(t *testing.T) {
+	_, err := readChange(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assert.Equal(t, ErrBadValue, err)
+
+	_, err = readChange(bytes.NewReader([]byte{0, 0, 0, 1}))
+	assert.Equal(t, ErrBadValue, err)
+}
+
+func TestWatch_NamedTree_OnlyItsChanges(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	users, _ := bpTreeImpl.CreateTree("users")
+	s, _ := users.Watch(0, 100)
+
+	bpTreeImpl.Put(1, [10]byte{1})
+	users.Put(1, [10]byte{2})
+
+	changes := receive(s)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, [10]byte{2}, changes[0].NewValue)
+	assert.Equal(t, uint64(2), changes[0].Sequence)
+}
+
+func TestWatch_SlowWatcher_Closed(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	s, _ := bpTreeImpl.Watch(0, 10000)
+
+	for key := 0; key <= watchBuffer; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+
+	assert.Equal(t, watchBuffer, len(receive(s)))
+	_, ok := <-s.C
+	assert.False(t, ok)
+	assert.Equal(t, ErrWatcherTooSlow, s.Err())
+}
+
+func TestWatch_Expired_DeleteOnSweep(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.PutWithTTL(1, [10]byte{1}, time.Minute)
+	s, _ := bpTreeImpl.Watch(0, 10)
+	advance(t, time.Hour)
+
+	bpTreeImpl.Sweep()
+
+	changes := receive(s)
+	assert.Equal(t, 1, len(changes))
+	assert.Equal(t, ChangeDelete, changes[0].Kind)
+	assert.Equal(t, [10]byte{1}, changes[0].OldValue)
+}
+
+func TestSubscription_Close_ClosesChannel(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	s, _ := bpTreeImpl.Watch(0, 10)
+
+	s.Close()
+	bpTreeImpl.Put(1, [10]byte{1})
+
+	_, ok := <-s.C
+	assert.False(t, ok)
+	assert.Nil(t, s.Err())
+}
@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"os"
+	"strconv"
+
+	"main/infrastructure"
+)
+
+// checkpoint is a snapshot of the store that is being copied
+type checkpoint struct {
+	header  BpTreeImpl
+	pageIds []infrastructure.PageID
+	pager   *pager
+}
+
+// Checkpoint writes a consistent copy of the store to destPath while it stays in use. The copy holds the header and
+// the pages of all trees of the store as of the moment Checkpoint is called and can be opened like any other store,
+// with the same encryption key. The store is only locked to flush dirty pages and to collect the pages, and then once
+// for every copied page. Pages that are overwritten in between are copied as they were stored before (copy-on-write).
+// destPath must not contain a store yet. The header is written last, so an incomplete copy cannot be opened.
+func (bpTree *BpTreeImpl) Checkpoint(destPath string) error {
+	if _, err := os.Stat(destPath + "/KVSTORE"); err == nil {
+		return ErrInvalidPath
+	}
+
+	c, err := bpTree.beginCheckpoint()
+	if err != nil {
+		return err
+	}
+	defer c.end()
+
+	return c.write(destPath)
+}
+
+// beginCheckpoint flushes all dirty pages and starts a snapshot of the pages of the store: its tree, the catalog and
+// the named trees, each with its indexes
+func (bpTree *BpTreeImpl) beginCheckpoint() (_ *checkpoint, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	store := bpTree.storeTree()
+	catalog, err := store.readCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	err = bpTree.pager.pool.FlushAllpages()
+	if err != nil {
+		return nil, err
+	}
+	c := checkpoint{header: *store, pager: bpTree.pager}
+	c.header.Indexes = make(map[string]int, len(store.Indexes))
+	for name, rootPageId := range store.Indexes {
+		c.header.Indexes[name] = rootPageId
+	}
+
+	collect := func(node NodeInfo) bool {
+		c.pageIds = append(c.pageIds, infrastructure.PageID(node.PageId))
+		return true
+	}
+	bpTree.pager.walkNode(store.RootPageId, 0, collect)
+	for _, rootPageId := range store.Indexes {
+		bpTree.pager.walkIndex(rootPageId, collect)
+	}
+	_, catalogPageIds, err := store.catalogChain()
+	if err != nil {
+		return nil, err
+	}
+	for _, pageId := range catalogPageIds {
+		c.pageIds = append(c.pageIds, infrastructure.PageID(pageId))
+	}
+	for _, entry := range catalog {
+		bpTree.pager.walkNode(entry.RootPageId, 0, collect)
+		for _, rootPageId := range entry.Indexes {
+			bpTree.pager.walkIndex(rootPageId, collect)
+		}
+	}
+
+	err = c.pager.snapshots.Begin(c.pageIds)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// write copies the pages of the snapshot and then the header to destPath
+func (c *checkpoint) write(destPath string) error {
+	err := os.MkdirAll(destPath+"/KVSTOREPAGES", os.ModePerm)
+	if err != nil {
+		return ErrInvalidPath
+	}
+
+	for _, pageId := range c.pageIds {
+		c.pager.mu.Lock()
+		data, err := c.pager.snapshots.ReadSnapshot(pageId)
+		c.pager.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(destPath+"/KVSTOREPAGES/"+strconv.Itoa(int(pageId)), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	header := c.header
+	header.Path = destPath
+	header.Mapped = false // The copy has a file per page, also of a store with Options.Mmap
+	return CreateKVStore(header)
+}
+
+func (c *checkpoint) end() {
+	c.pager.mu.Lock()
+	defer c.pager.mu.Unlock()
+
+	c.pager.snapshots.End()
+}
@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openCheckpoint closes the store and opens the copy at path instead
+func openCheckpoint(t *testing.T, bpTreeImpl *BpTreeImpl, path string) *BpTreeImpl {
+	assert.Nil(t, bpTreeImpl.Close())
+
+	var store BpTreeImpl
+	copied, err := store.Open(path)
+	assert.Nil(t, err)
+	assert.Nil(t, copied.Verify())
+	return copied
+}
+
+func TestCheckpoint_ChangesAfterBegin_NotInCopy(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, Options{Durable: true})
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 200; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+
+	dest := t.TempDir()
+	c, err := bpTreeImpl.beginCheckpoint()
+	assert.Nil(t, err)
+
+	// Every change is flushed to disk right away, so the pages of the snapshot are overwritten
+	for key := 0; key < 200; key++ {
+		assert.Nil(t, bpTreeImpl.Update(key, [10]byte{2}))
+		assert.Nil(t, bpTreeImpl.Put(key+200, [10]byte{2}))
+	}
+	assert.Nil(t, bpTreeImpl.Delete(0))
+
+	assert.Nil(t, c.write(dest))
+	c.end()
+
+	copied := openCheckpoint(t, bpTreeImpl, dest)
+	defer copied.DeleteStore(dest)
+	assert.Equal(t, 200, treeStats(t, copied).Keys)
+	for key := 0; key < 200; key++ {
+		value, err := copied.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{1}, value)
+	}
+}
+
+func TestCheckpoint_ConcurrentWrites_ConsistentCopy(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 300; key++ {
+		bpTreeImpl.Put(key, [10]byte{'o', 'l', 'd'})
+	}
+
+	// The writer updates key i and then inserts key 1000+i, so every consistent copy has updated a prefix of the
+	// keys and inserted as many keys, or one less
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 300; i++ {
+			bpTreeImpl.Update(i, [10]byte{'n', 'e', 'w'})
+			bpTreeImpl.Put(1000+i, [10]byte{'n', 'e', 'w'})
+		}
+	}()
+
+	dest := t.TempDir()
+	err := bpTreeImpl.Checkpoint(dest)
+	wg.Wait()
+	assert.Nil(t, err)
+
+	copied := openCheckpoint(t, bpTreeImpl, dest)
+	defer copied.DeleteStore(dest)
+
+	updated, inserted := 0, 0
+	for i := 0; i < 300; i++ {
+		value, err := copied.Get(i)
+		assert.Nil(t, err)
+		if value == [10]byte{'n', 'e', 'w'} {
+			assert.Equal(t, i, updated, "key "+strconv.Itoa(i)+" updated out of order")
+			updated++
+		}
+		if _, err := copied.Get(1000 + i); err == nil {
+			assert.Equal(t, i, inserted, "key "+strconv.Itoa(1000+i)+" inserted out of order")
+			inserted++
+		}
+	}
+	assert.True(t, updated == inserted || updated == inserted+1)
+}
+
+func TestCheckpoint_DestinationHasStore_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	err := bpTreeImpl.Checkpoint(".")
+
+	assert.Equal(t, ErrInvalidPath, err)
+}
+
+func TestCheckpoint_IndexWithPostings_InCopy(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 200; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key % 4)})
+	}
+
+	dest := t.TempDir()
+	assert.Nil(t, bpTreeImpl.Checkpoint(dest))
+
+	copied := openCheckpoint(t, bpTreeImpl, dest)
+	defer copied.DeleteStore(dest)
+	assert.Nil(t, copied.RegisterIndex("first", byFirstByte))
+	primaryKeys, err := copied.LookupBy("first", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(primaryKeys))
+}
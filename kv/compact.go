@@ -0,0 +1,200 @@
+package kv
+
+import (
+	"math"
+
+	"main/infrastructure"
+)
+
+// Compact rewrites the tree into new pages and frees the old ones. Leaves are filled completely, as by Restore, and
+// the new pages are numbered in key order from the lowest free page id, so scans read fewer pages in sequence.
+// The new root is swapped in by rewriting the header, which happens atomically, so the store refers either to the
+// old or to the new tree.
+// The trees of the secondary indexes and the named trees of the store are rewritten the same way, so Compact
+// rewrites the whole store, no matter on which of its trees it is called.
+// Expired pairs are not rewritten, so their space is reclaimed.
+// Pages that are not part of the old tree, e.g. left behind by a failed Restore, are freed as well.
+// Compact returns the number of bytes by which the pages of the store shrank.
+func (bpTree *BpTreeImpl) Compact() (_ int64, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	store := bpTree.storeTree()
+	err = bpTree.pager.pool.FlushAllpages()
+	if err != nil {
+		return 0, err
+	}
+	oldPageIds, sizeBefore, err := bpTree.pager.storedPages(store.Path)
+	if err != nil {
+		return 0, err
+	}
+	// The old pages are only freed once the new ones have been written, which are at most as many
+	if bpTree.pager.freePages() < len(oldPageIds) {
+		return 0, ErrStoreFull
+	}
+
+	entry, err := bpTree.pager.rewriteEntry(store.Path, catalogEntry{RootPageId: store.RootPageId, Indexes: store.Indexes})
+	if err != nil {
+		return 0, err
+	}
+	catalog, err := store.readCatalog()
+	if err != nil {
+		return 0, err
+	}
+	for name := range catalog {
+		catalog[name], err = bpTree.pager.rewriteEntry(store.Path, catalog[name])
+		if err != nil {
+			return 0, err
+		}
+	}
+	catalogPageId := 0
+	if store.CatalogPageId != 0 {
+		data, err := encodeCatalog(catalog)
+		if err != nil {
+			return 0, err
+		}
+		catalogPageId = bpTree.pager.writeCatalogPages(store.Path, data, nil)[0]
+	}
+	err = bpTree.pager.pool.FlushAllpages()
+	if err != nil {
+		return 0, err
+	}
+
+	old := *store
+	store.RootPageId, store.Indexes, store.CatalogPageId = entry.RootPageId, entry.Indexes, catalogPageId
+	err = CreateKVStore(*store)
+	if err != nil {
+		store.RootPageId, store.Indexes, store.CatalogPageId = old.RootPageId, old.Indexes, old.CatalogPageId
+		return 0, err
+	}
+	for name, tree := range store.trees {
+		tree.RootPageId, tree.Indexes = catalog[name].RootPageId, catalog[name].Indexes
+	}
+
+	// The new pages have been allocated after the old ones were listed, so all of those can be freed
+	for _, pageId := range oldPageIds {
+		bpTree.pager.pool.DeletePage(infrastructure.PageID(pageId))
+	}
+
+	_, sizeAfter, err := bpTree.pager.storedPages(store.Path)
+	if err != nil {
+		return 0, err
+	}
+	return sizeBefore - sizeAfter, nil
+}
+
+// rewriteEntry rewrites a tree and its indexes and returns where they are now
+func (p *pager) rewriteEntry(path string, entry catalogEntry) (catalogEntry, error) {
+	tree := &BpTreeImpl{Path: path, RootPageId: entry.RootPageId, Indexes: entry.Indexes, pager: p}
+	rootPageId, err := rewriteTree(tree)
+	if err != nil {
+		return catalogEntry{}, err
+	}
+
+	var indexes map[string]int
+	if entry.Indexes != nil {
+		indexes = make(map[string]int, len(entry.Indexes))
+	}
+	for name := range entry.Indexes {
+		indexes[name], err = rewriteIndex(tree.index(name))
+		if err != nil {
+			return catalogEntry{}, err
+		}
+	}
+
+	return catalogEntry{RootPageId: rootPageId, Indexes: indexes, ByteKeys: entry.ByteKeys}, nil
+}
+
+// rewriteTree bulk loads the pairs of tree that have not expired into new pages and returns the page id of the new root
+func rewriteTree(tree *BpTreeImpl) (int, error) {
+	loader := bulkLoader{path: tree.Path, pager: tree.pager}
+	err := tree.scanKeys("", func(key string, value [10]byte, expires int64) bool {
+		loader.add(key, value, expires)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return loadRoot(tree, &loader), nil
+}
+
+// loadRoot finishes a bulk load into a new root page and returns its page id. The root of a tree without pairs is an
+// empty leaf.
+func loadRoot(tree *BpTreeImpl, loader *bulkLoader) int {
+	rootPageId := tree.pager.createNewNode(tree.Path).PageId
+	if loader.leaf == nil {
+		root := Node{IsLeaf: true, PageId: rootPageId}
+		tree.pager.writeNodeToPage(&root)
+	}
+	loader.finish(rootPageId)
+
+	return rootPageId
+}
+
+// rewriteIndex rewrites the tree of an index and its postings and returns the page id of the new root. Postings with
+// a single primary key left are folded into the index, secondary keys without any are dropped.
+func rewriteIndex(index *BpTreeImpl) (int, error) {
+	type indexEntry struct {
+		key     string
+		value   [10]byte
+		expires int64
+	}
+	var entries []indexEntry
+	err := index.scanKeys("", func(key string, value [10]byte, expires int64) bool {
+		entries = append(entries, indexEntry{key, value, expires})
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The postings are rewritten first, so that the pages of the index follow each other
+	loaded := entries[:0]
+	for _, entry := range entries {
+		if kind, n := splitIndexValue(entry.value); kind == indexPostings {
+			var primaryKeys []int
+			var expires []int64
+			index.postings(n).scanEntries(math.MinInt64, math.MaxInt64, func(key int, value [10]byte, expiry int64) bool {
+				primaryKeys = append(primaryKeys, key)
+				expires = append(expires, expiry)
+				return true
+			})
+
+			switch len(primaryKeys) {
+			case 0:
+				continue
+			case 1:
+				entry.value, entry.expires = indexValue(indexPrimaryKey, primaryKeys[0]), expires[0]
+			default:
+				rootPageId, err := rewriteTree(index.postings(n))
+				if err != nil {
+					return 0, err
+				}
+				entry.value = indexValue(indexPostings, rootPageId)
+			}
+		}
+		loaded = append(loaded, entry)
+	}
+
+	loader := bulkLoader{path: index.Path, pager: index.pager}
+	for _, entry := range loaded {
+		loader.add(entry.key, entry.value, entry.expires)
+	}
+	return loadRoot(index, &loader), nil
+}
+
+// storedPages returns the ids of the pages stored at path and their total size in bytes
+func (p *pager) storedPages(path string) ([]int, int64, error) {
+	stored, size, err := p.disk.StoredPages(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pageIds := make([]int, len(stored))
+	for i, pageId := range stored {
+		pageIds[i] = int(pageId)
+	}
+	return pageIds, size, nil
+}
@@ -0,0 +1,102 @@
+package kv
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func treePageIds(bpTree *BpTreeImpl) []int {
+	var pageIds []int
+	bpTree.Walk(func(node NodeInfo) bool {
+		pageIds = append(pageIds, node.PageId)
+		return true
+	})
+	sort.Ints(pageIds)
+
+	return pageIds
+}
+
+func TestCompact_SparseTree_ShrinksAndKeepsPairs(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 1000; i++ {
+		key := (i * 7919) % 1000
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	for key := 0; key < 1000; key++ {
+		if key%3 != 0 {
+			bpTreeImpl.Delete(key)
+		}
+	}
+	before := treeStats(t, bpTreeImpl)
+
+	saved, err := bpTreeImpl.Compact()
+
+	assert.Nil(t, err)
+	assert.True(t, saved > 0)
+	assert.Nil(t, bpTreeImpl.Verify())
+	after := treeStats(t, bpTreeImpl)
+	assert.Equal(t, before.Keys, after.Keys)
+	assert.True(t, after.Pages < before.Pages)
+	assert.True(t, after.FillFactor > 0.9)
+	for key := 0; key < 1000; key++ {
+		_, err := bpTreeImpl.Get(key)
+		assert.Equal(t, key%3 != 0, err == ErrNotFound)
+	}
+
+	// Only the pages of the new tree are left on disk
+	entries, _ := os.ReadDir("./KVSTOREPAGES")
+	assert.Equal(t, after.Pages, len(entries))
+}
+
+func TestCompact_Twice_ReusesLowestPageIds(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 500; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+
+	_, err := bpTreeImpl.Compact()
+	assert.Nil(t, err)
+	_, err = bpTreeImpl.Compact()
+	assert.Nil(t, err)
+
+	// The first compaction freed the pages the tree was built with, the second one writes to them in order
+	pageIds := treePageIds(bpTreeImpl)
+	for i, pageId := range pageIds {
+		assert.Equal(t, i+1, pageId)
+	}
+	assert.Nil(t, bpTreeImpl.Verify())
+}
+
+func TestCompact_ReopenedStore_KeepsPairs(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 300; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	_, err := bpTreeImpl.Compact()
+	assert.Nil(t, err)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(".")
+
+	assert.Nil(t, err)
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 300, treeStats(t, reopened).Keys)
+	assert.Nil(t, reopened.Put(1000, [10]byte{1}))
+}
+
+func TestCompact_EmptyTree(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	_, err := bpTreeImpl.Compact()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, treeStats(t, bpTreeImpl).Keys)
+	assert.Nil(t, bpTreeImpl.Put(1, [10]byte{1}))
+}
@@ -0,0 +1,34 @@
+package kv
+
+import (
+	"io/ioutil"
+	"main/infrastructure"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateWithOptions_Compression_SmallerPageFiles(t *testing.T) {
+	var bpTreeImpl BpTreeImpl
+	tree, err := bpTreeImpl.CreateWithOptions(".", mem, Options{Compression: true})
+	assert.Nil(t, err)
+	defer tree.DeleteStore(".")
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, tree.Put(i, [10]byte{1, 1, 1, 1, 1, 2, 2, 2, 2, 2}))
+	}
+	tree.pager.pool.FlushAllpages()
+
+	for i := 0; i < 300; i++ {
+		value, err := tree.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{1, 1, 1, 1, 1, 2, 2, 2, 2, 2}, value)
+	}
+
+	files, err := ioutil.ReadDir("./KVSTOREPAGES")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, files)
+	for _, file := range files {
+		assert.Less(t, file.Size(), int64(infrastructure.PageSize/2), file.Name())
+	}
+}
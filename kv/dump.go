@@ -0,0 +1,386 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+
+	"main/infrastructure"
+)
+
+// A dump holds the pairs of a store independently of the page layout, so it can be restored by other versions.
+// It starts with dumpMagic and a uint16 format version. Every pair is a record with a uint32 length followed by the
+// key as int64 and the value. Since version 2, pairs that expire have a longer record that ends with the expiry in
+// unix nanoseconds as int64. A record of length dumpEndMarker ends the pairs and is followed by the number of pairs
+// as uint64 and the CRC-32C of all bytes before it. All integers are big-endian.
+
+const (
+	dumpMagic     = "KVDUMP"
+	dumpVersion   = 2
+	dumpEndMarker = 0xFFFFFFFF
+
+	dumpRecordSize       = 8 + 10
+	dumpExpiryRecordSize = dumpRecordSize + 8
+)
+
+var (
+	// ErrInvalidDump is returned by Restore if the input is not a complete dump of a supported version
+	ErrInvalidDump = errors.New(Package + " - dump is not valid")
+
+	// ErrNotEmpty is returned by Restore if the store already contains keys
+	ErrNotEmpty = errors.New(Package + " - store is not empty")
+)
+
+var dumpChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Dump writes every pair in key order to w. It follows the chain of leaves like Scan, expired pairs are left out.
+func (bpTree BpTreeImpl) Dump(w io.Writer) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	buffered := bufio.NewWriter(w)
+	checksum := crc32.New(dumpChecksumTable)
+	out := io.MultiWriter(buffered, checksum)
+
+	var header [len(dumpMagic) + 2]byte
+	copy(header[:], dumpMagic)
+	binary.BigEndian.PutUint16(header[len(dumpMagic):], dumpVersion)
+	_, err = out.Write(header[:])
+	if err != nil {
+		return err
+	}
+
+	count := uint64(0)
+	var writeErr error
+	err = bpTree.scanEntries(math.MinInt64, math.MaxInt64, func(key int, value [10]byte, expires int64) bool {
+		var record [4 + dumpExpiryRecordSize]byte
+		size := dumpRecordSize
+		if expires != 0 {
+			size = dumpExpiryRecordSize
+			binary.BigEndian.PutUint64(record[4+dumpRecordSize:], uint64(expires))
+		}
+		binary.BigEndian.PutUint32(record[0:], uint32(size))
+		binary.BigEndian.PutUint64(record[4:], uint64(key))
+		copy(record[12:], value[:])
+		_, writeErr = out.Write(record[:4+size])
+		count++
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	var trailer [4 + 8]byte
+	binary.BigEndian.PutUint32(trailer[0:], dumpEndMarker)
+	binary.BigEndian.PutUint64(trailer[4:], count)
+	_, err = out.Write(trailer[:])
+	if err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], checksum.Sum32())
+	_, err = buffered.Write(sum[:])
+	if err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+// Restore loads a dump written by Dump into an empty store. The pairs are bulk loaded: leaves are filled
+// completely and written once, then the inner nodes are built on top of them level by level.
+// The root is only replaced once the whole dump has been read and its checksum verified, so the store stays empty
+// if Restore fails, and the pages written until then are freed again. If the store runs out of pages, Restore
+// returns ErrStoreFull.
+// The secondary indexes are built once all pairs have been loaded. Pairs that have expired in the meantime are
+// skipped.
+func (bpTree *BpTreeImpl) Restore(r io.Reader) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	root := bpTree.pager.getNodeFromPageId(bpTree.RootPageId)
+	if !root.IsLeaf || root.numKeys > 0 {
+		return ErrNotEmpty
+	}
+
+	err = bpTree.indexesRegistered()
+	if err != nil {
+		return err
+	}
+
+	reader := dumpReader{r: bufio.NewReader(r), checksum: crc32.New(dumpChecksumTable)}
+	err = reader.readHeader()
+	if err != nil {
+		return err
+	}
+
+	loader := bulkLoader{path: bpTree.Path, pager: bpTree.pager}
+	count, err := bpTree.loadDump(&reader, &loader)
+	if err == nil && bpTree.pager.freePages() < loader.innerPages()+len(bpTree.extractors)*pagesForIndex(count) {
+		err = ErrStoreFull
+	}
+	if err != nil {
+		loader.discard()
+		return err
+	}
+
+	loader.finish(bpTree.RootPageId)
+	bpTree.scanKeys("", func(key string, value [10]byte, expires int64) bool {
+		bpTree.indexInsert(key, value, expires)
+		bpTree.record(Change{Kind: ChangePut, Key: decodeKey(key), NewValue: value, Expires: expires})
+		return true
+	})
+
+	return bpTree.syncIfDurable()
+}
+
+// loadDump adds the pairs of the dump to the leaves of loader and returns their number. Page errors are returned
+// without failing the store, the leaves are not part of the tree yet.
+func (bpTree *BpTreeImpl) loadDump(reader *dumpReader, loader *bulkLoader) (count int, err error) {
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	lastKey := 0
+	t := now().UnixNano()
+	for {
+		key, value, expires, end, err := reader.readRecord()
+		if err != nil {
+			return 0, err
+		}
+		if end {
+			break
+		}
+		if count > 0 && key <= lastKey {
+			return 0, ErrInvalidDump
+		}
+
+		if !expired(expires, t) {
+			loader.add(encodeKey(key), value, expires)
+		}
+		lastKey = key
+		count++
+	}
+
+	return count, reader.readTrailer(uint64(count))
+}
+
+// dumpReader reads a dump and computes the checksum of what it has read
+type dumpReader struct {
+	r        *bufio.Reader
+	checksum hash.Hash32
+	version  uint16
+}
+
+// read fills data completely. A dump that ends early is invalid.
+func (d *dumpReader) read(data []byte) error {
+	_, err := io.ReadFull(d.r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidDump
+	}
+	if err != nil {
+		return err
+	}
+
+	d.checksum.Write(data)
+	return nil
+}
+
+func (d *dumpReader) readHeader() error {
+	var header [len(dumpMagic) + 2]byte
+	err := d.read(header[:])
+	if err != nil {
+		return err
+	}
+	d.version = binary.BigEndian.Uint16(header[len(dumpMagic):])
+	if !bytes.Equal(header[:len(dumpMagic)], []byte(dumpMagic)) || d.version < 1 || d.version > dumpVersion {
+		return ErrInvalidDump
+	}
+
+	return nil
+}
+
+// readRecord returns the next pair and its expiry, or end if the end marker has been reached
+func (d *dumpReader) readRecord() (key int, value [10]byte, expires int64, end bool, err error) {
+	var length [4]byte
+	err = d.read(length[:])
+	if err != nil {
+		return
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size == dumpEndMarker {
+		end = true
+		return
+	}
+	if size != dumpRecordSize && (size != dumpExpiryRecordSize || d.version < 2) {
+		err = ErrInvalidDump
+		return
+	}
+
+	var record [dumpExpiryRecordSize]byte
+	err = d.read(record[:size])
+	if err != nil {
+		return
+	}
+	key = int(binary.BigEndian.Uint64(record[:8]))
+	copy(value[:], record[8:18])
+	if size == dumpExpiryRecordSize {
+		expires = int64(binary.BigEndian.Uint64(record[18:]))
+	}
+	return
+}
+
+// readTrailer checks the number of pairs and the checksum at the end of the dump
+func (d *dumpReader) readTrailer(count uint64) error {
+	var trailer [8]byte
+	err := d.read(trailer[:])
+	if err != nil {
+		return err
+	}
+	expected := d.checksum.Sum32()
+
+	var sum [4]byte
+	err = d.read(sum[:])
+	if err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint64(trailer[:]) != count || binary.BigEndian.Uint32(sum[:]) != expected {
+		return ErrInvalidDump
+	}
+
+	return nil
+}
+
+// bulkLoader builds a tree bottom-up from pairs added in ascending key order
+type bulkLoader struct {
+	path    string
+	pager   *pager
+	leaf    *Node // Leaf that is being filled, not written yet
+	lastKey string
+	newIds  []int // Pages allocated so far, see discard
+
+	// Written leaves with the separators between them, separators[i] is the separator between pages[i] and pages[i+1]
+	pages      []int
+	separators []string
+}
+
+// add appends a pair with an encoded key
+func (l *bulkLoader) add(key string, value [10]byte, expires int64) {
+	if l.leaf == nil {
+		l.leaf = &Node{IsLeaf: true}
+	} else if l.leaf.numKeys == MAX_BRANCHING_FACTOR {
+		// The next leaf is allocated before the full one is written, so the full one can be linked to it
+		if l.leaf.PageId == 0 {
+			l.leaf.PageId = l.newPage()
+		}
+		next := &Node{IsLeaf: true, PageId: l.newPage()}
+		l.writeLeaf(next.PageId)
+		l.separators = append(l.separators, shortestSeparator(l.lastKey, key))
+		l.leaf = next
+	}
+
+	l.leaf.insertAt(l.leaf.numKeys, key, value, expires)
+	l.lastKey = key
+}
+
+// newPage allocates a page for a node and remembers it for discard
+func (l *bulkLoader) newPage() int {
+	pageId := l.pager.createNewNode(l.path).PageId
+	l.newIds = append(l.newIds, pageId)
+	return pageId
+}
+
+// discard frees the pages allocated so far, if the load is given up before finish
+func (l *bulkLoader) discard() {
+	for _, pageId := range l.newIds {
+		l.pager.pool.DeletePage(infrastructure.PageID(pageId))
+	}
+	l.newIds = nil
+}
+
+// innerPages returns the number of pages finish allocates for the inner nodes. The root is written to its own page.
+func (l *bulkLoader) innerPages() int {
+	if l.leaf == nil || len(l.pages) == 0 {
+		return 0
+	}
+
+	pages := 0
+	for nodes := len(l.pages) + 1; nodes > 1; {
+		nodes = (nodes + MAX_BRANCHING_FACTOR) / (MAX_BRANCHING_FACTOR + 1)
+		if nodes > 1 {
+			pages += nodes
+		}
+	}
+	return pages
+}
+
+// writeLeaf writes the current leaf, which links to the leaf on nextPageId
+func (l *bulkLoader) writeLeaf(nextPageId int) {
+	l.leaf.NextPageId = nextPageId
+	l.pager.writeNodeToPage(l.leaf)
+	l.pages = append(l.pages, l.leaf.PageId)
+}
+
+// finish writes the last leaf and the inner nodes above the leaves. The root is written to rootPageId.
+func (l *bulkLoader) finish(rootPageId int) {
+	if l.leaf == nil {
+		return
+	}
+	if len(l.pages) == 0 {
+		// A single leaf is the root
+		l.leaf.PageId = rootPageId
+		l.pager.writeNodeToPage(l.leaf)
+		return
+	}
+	l.writeLeaf(0)
+
+	pages, separators := l.pages, l.separators
+	for len(pages) > 1 {
+		pages, separators = l.buildLevel(pages, separators, rootPageId)
+	}
+}
+
+// buildLevel writes the inner nodes for the nodes on pages and returns the pages of the new level.
+// The children are distributed evenly, so every inner node has at least two. The only node of the top level
+// is written to rootPageId.
+func (l *bulkLoader) buildLevel(pages []int, separators []string, rootPageId int) ([]int, []string) {
+	numNodes := (len(pages) + MAX_BRANCHING_FACTOR) / (MAX_BRANCHING_FACTOR + 1)
+	var levelPages []int
+	var levelSeparators []string
+
+	first := 0
+	for i := 0; i < numNodes; i++ {
+		numChildren := len(pages) / numNodes
+		if i < len(pages)%numNodes {
+			numChildren++
+		}
+
+		node := Node{}
+		if numNodes == 1 {
+			node.PageId = rootPageId
+		} else {
+			node.PageId = l.newPage()
+		}
+		node.numKeys = numChildren - 1
+		copy(node.Children[:], pages[first:first+numChildren])
+		copy(node.Keys[:], separators[first:first+numChildren-1])
+		l.pager.writeNodeToPage(&node)
+
+		levelPages = append(levelPages, node.PageId)
+		if first+numChildren < len(pages) {
+			levelSeparators = append(levelSeparators, separators[first+numChildren-1])
+		}
+		first += numChildren
+	}
+
+	return levelPages, levelSeparators
+}
@@ -0,0 +1,125 @@
+package kv
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpRestore_AllPairsRestored(t *testing.T) {
+	for _, numKeys := range []int{0, 1, 10, 11, 121, 2000} {
+		bpTreeImpl, _ := setupTestDB(".", mem)
+		for i := 0; i < numKeys; i++ {
+			key := (i*7919)%numKeys - numKeys/2
+			assert.Nil(t, bpTreeImpl.Put(key, [10]byte{byte(key), byte(key >> 8)}))
+		}
+
+		var dump bytes.Buffer
+		assert.Nil(t, bpTreeImpl.Dump(&dump))
+		bpTreeImpl.DeleteStore(".")
+
+		restored, _ := setupTestDB(".", mem)
+		err := restored.Restore(bytes.NewReader(dump.Bytes()))
+
+		assert.Nil(t, err)
+		assert.Nil(t, restored.Verify())
+		assert.Equal(t, numKeys, treeStats(t, restored).Keys)
+		for i := 0; i < numKeys; i++ {
+			key := i - numKeys/2
+			value, err := restored.Get(key)
+			assert.Nil(t, err)
+			assert.Equal(t, [10]byte{byte(key), byte(key >> 8)}, value)
+		}
+
+		// The restored tree can be modified like any other
+		assert.Nil(t, restored.Put(math.MaxInt64, [10]byte{1}))
+		assert.Nil(t, restored.Delete(math.MaxInt64))
+		assert.Nil(t, restored.Verify())
+		restored.DeleteStore(".")
+	}
+}
+
+func TestDumpRestore_ReopenedStore_KeepsPairs(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	for key := 0; key < 500; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	var dump bytes.Buffer
+	bpTreeImpl.Dump(&dump)
+	bpTreeImpl.DeleteStore(".")
+
+	restored, _ := setupTestDB(".", mem)
+	defer restored.DeleteStore(".")
+	assert.Nil(t, restored.Restore(&dump))
+	assert.Nil(t, restored.Close())
+
+	reopened, err := restored.Open(".")
+	assert.Nil(t, err)
+	value, err := reopened.Get(499)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{byte(499 % 256)}, value)
+}
+
+func TestRestore_CorruptDump_StoreStaysEmpty(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	var dump bytes.Buffer
+	bpTreeImpl.Dump(&dump)
+	bpTreeImpl.DeleteStore(".")
+
+	valid := dump.Bytes()
+	flipped := append([]byte{}, valid...)
+	flipped[100] ^= 1
+	truncated := valid[:len(valid)-5]
+	wrongVersion := append([]byte{}, valid...)
+	wrongVersion[7] = dumpVersion + 1
+
+	restored, _ := setupTestDB(".", mem)
+	defer restored.DeleteStore(".")
+	before, _ := restored.Usage()
+	for _, data := range [][]byte{flipped, truncated, wrongVersion, []byte("not a dump")} {
+		err := restored.Restore(bytes.NewReader(data))
+
+		assert.Equal(t, ErrInvalidDump, err)
+		assert.Equal(t, 0, treeStats(t, restored).Keys)
+		after, _ := restored.Usage()
+		assert.Equal(t, before.Pages, after.Pages)
+	}
+}
+
+func TestRestore_StoreFull_ReturnsErrStoreFull(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	for key := 0; key < 500; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	var dump bytes.Buffer
+	bpTreeImpl.Dump(&dump)
+	bpTreeImpl.DeleteStore(".")
+
+	var store BpTreeImpl
+	restored, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 20})
+	defer restored.DeleteStore(restored.Path)
+	err := restored.Restore(bytes.NewReader(dump.Bytes()))
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Equal(t, 0, treeStats(t, restored).Keys)
+	usage, _ := restored.Usage()
+	assert.Equal(t, 1, usage.Pages)
+	assert.Nil(t, restored.Put(1, [10]byte{1}))
+}
+
+func TestRestore_NotEmpty_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(1, [10]byte{1})
+	var dump bytes.Buffer
+	bpTreeImpl.Dump(&dump)
+
+	err := bpTreeImpl.Restore(&dump)
+
+	assert.Equal(t, ErrNotEmpty, err)
+}
@@ -0,0 +1,65 @@
+package kv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var encryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestCreateWithOptions_EncryptionKey_NoPlaintextOnDisk(t *testing.T) {
+	var bpTreeImpl BpTreeImpl
+	tree, err := bpTreeImpl.CreateWithOptions(".", mem, Options{EncryptionKey: encryptionKey})
+	assert.Nil(t, err)
+	defer tree.DeleteStore(".")
+
+	secret := [10]byte{'s', 'e', 'c', 'r', 'e', 't', 'v', 'a', 'l', 'u'}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, tree.Put(i, secret))
+	}
+	tree.pager.pool.FlushAllpages()
+
+	files, err := ioutil.ReadDir("./KVSTOREPAGES")
+	assert.Nil(t, err)
+	for _, file := range files {
+		data, err := ioutil.ReadFile("./KVSTOREPAGES/" + file.Name())
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(data, secret[:]), file.Name())
+	}
+
+	for i := 0; i < 100; i++ {
+		value, err := tree.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, secret, value)
+	}
+}
+
+func TestOpenWithOptions_EncryptionKey(t *testing.T) {
+	var bpTreeImpl BpTreeImpl
+	tree, err := bpTreeImpl.CreateWithOptions(".", mem, Options{EncryptionKey: encryptionKey, Compression: true, Durable: true})
+	assert.Nil(t, err)
+	defer tree.DeleteStore(".")
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, tree.Put(i, [10]byte{byte(i)}))
+	}
+
+	_, err = bpTreeImpl.Open(".")
+	assert.EqualError(t, err, ErrInvalidEncryptionKey.Error())
+
+	_, err = bpTreeImpl.OpenWithOptions(".", Options{EncryptionKey: []byte("fedcba9876543210fedcba9876543210")})
+	assert.EqualError(t, err, ErrInvalidEncryptionKey.Error())
+
+	_, err = bpTreeImpl.OpenWithOptions(".", Options{EncryptionKey: []byte("too short")})
+	assert.EqualError(t, err, ErrInvalidEncryptionKey.Error())
+
+	tree, err = bpTreeImpl.OpenWithOptions(".", Options{EncryptionKey: encryptionKey})
+	assert.Nil(t, err)
+	for i := 0; i < 50; i++ {
+		value, err := tree.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(i)}, value)
+	}
+}
@@ -0,0 +1,207 @@
+package kv
+
+import (
+	"io"
+	"testing"
+
+	"main/infrastructure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupFaultyDB creates a store with 100 keys on a disk that injects faults. The disk is kept when the store is
+// opened again.
+func setupFaultyDB(t *testing.T, opts Options) (*BpTreeImpl, *infrastructure.FaultyDiskManager) {
+	var faulty *infrastructure.FaultyDiskManager
+	opts.wrapDisk = func(diskManager infrastructure.DiskManager) infrastructure.DiskManager {
+		if faulty == nil {
+			faulty = infrastructure.NewFaultyDiskManager(diskManager)
+		}
+		return faulty
+	}
+
+	var store BpTreeImpl
+	bpTreeImpl, err := store.CreateWithOptions(".", mem, opts)
+	assert.Nil(t, err)
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.OpenWithOptions(".", opts)
+	assert.Nil(t, err)
+	return reopened, faulty
+}
+
+// crash loses what has not been synced, then opens the store again with a new buffer pool
+func crash(t *testing.T, faulty *infrastructure.FaultyDiskManager, opts Options) *BpTreeImpl {
+	reopened, err := crashAndOpen(faulty, opts)
+	assert.Nil(t, err)
+	return reopened
+}
+
+// crashAndOpen works like crash, but returns the error of OpenWithOptions. The store is opened on the same disk.
+func crashAndOpen(faulty *infrastructure.FaultyDiskManager, opts Options) (*BpTreeImpl, error) {
+	err := faulty.Crash()
+	if err != nil {
+		return nil, err
+	}
+	opts.wrapDisk = func(infrastructure.DiskManager) infrastructure.DiskManager { return faulty }
+	var store BpTreeImpl
+	return store.OpenWithOptions(".", opts)
+}
+
+func TestGet_ReadFault_ReturnsError(t *testing.T) {
+	bpTreeImpl, faulty := setupFaultyDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+
+	faulty.FailRead(1)
+	failed := 0
+	for key := 0; key < 100; key++ {
+		_, err := bpTreeImpl.Get(key)
+		if err != nil {
+			assert.Equal(t, infrastructure.ErrInjectedFault, err)
+			failed++
+		}
+	}
+
+	assert.Equal(t, 1, failed)
+	assert.Nil(t, bpTreeImpl.Put(100, [10]byte{1}))
+	assert.Nil(t, bpTreeImpl.Verify())
+}
+
+func TestPut_WriteFault_FailsUntilReopened(t *testing.T) {
+	bpTreeImpl, faulty := setupFaultyDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+
+	faulty.FailWrite(1)
+	var err error
+	for key := 100; err == nil; key++ {
+		err = bpTreeImpl.Put(key, [10]byte{1})
+	}
+
+	assert.Equal(t, infrastructure.ErrInjectedFault, err)
+	assert.Equal(t, ErrFailed, bpTreeImpl.Put(1000, [10]byte{1}))
+	_, err = bpTreeImpl.Get(1)
+	assert.Equal(t, ErrFailed, err)
+	assert.Equal(t, ErrFailed, bpTreeImpl.Close())
+
+	reopened := crash(t, faulty, Options{})
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 100, treeStats(t, reopened).Keys)
+	assert.Nil(t, reopened.Put(1000, [10]byte{1}))
+}
+
+func TestFlushAllpages_WriteFault_PagesStayDirty(t *testing.T) {
+	bpTreeImpl, faulty := setupFaultyDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(100, [10]byte{1})
+
+	faulty.FailWrite(1)
+	assert.Equal(t, infrastructure.ErrInjectedFault, bpTreeImpl.pager.pool.FlushAllpages())
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened := crash(t, faulty, Options{})
+	value, err := reopened.Get(100)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{1}, value)
+}
+
+func TestCrash_Durable_KeepsSyncedChanges(t *testing.T) {
+	opts := Options{Durable: true}
+	bpTreeImpl, faulty := setupFaultyDB(t, opts)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 100; key += 2 {
+		assert.Nil(t, bpTreeImpl.Delete(key))
+	}
+
+	reopened := crash(t, faulty, opts)
+
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 50, treeStats(t, reopened).Keys)
+	_, err := reopened.Get(2)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestWatch_DurableSyncFault_ChangeDeliveredOnceWritten(t *testing.T) {
+	opts := Options{Durable: true}
+	bpTreeImpl, faulty := setupFaultyDB(t, opts)
+	defer bpTreeImpl.DeleteStore(".")
+	s, _ := bpTreeImpl.Watch(0, 1000)
+	last := bpTreeImpl.LastSequence()
+
+	faulty.FailWrite(1)
+	assert.Equal(t, infrastructure.ErrInjectedFault, bpTreeImpl.Put(100, [10]byte{1}))
+
+	assert.Empty(t, receive(s))
+	assert.Equal(t, last, bpTreeImpl.LastSequence())
+	assert.Nil(t, bpTreeImpl.Put(101, [10]byte{1}))
+	changes := receive(s)
+	assert.Equal(t, 2, len(changes))
+	assert.Equal(t, 100, changes[0].Key)
+	assert.Equal(t, last+2, bpTreeImpl.LastSequence())
+}
+
+func TestCrash_NotDurable_LosesUnsyncedChanges(t *testing.T) {
+	bpTreeImpl, faulty := setupFaultyDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 100; key < 300; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+
+	reopened := crash(t, faulty, Options{})
+
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 100, treeStats(t, reopened).Keys)
+}
+
+func TestFlipBit_Encrypted_Detected(t *testing.T) {
+	opts := Options{EncryptionKey: encryptionKey}
+	bpTreeImpl, faulty := setupFaultyDB(t, opts)
+	defer bpTreeImpl.DeleteStore(".")
+	var leaf int
+	bpTreeImpl.Walk(func(node NodeInfo) bool {
+		leaf = node.PageId
+		return !node.IsLeaf
+	})
+
+	assert.Nil(t, faulty.FlipBit(infrastructure.PageID(leaf), 100))
+
+	reopened := crash(t, faulty, opts)
+	assert.NotNil(t, reopened.Verify())
+	assert.NotNil(t, reopened.Scan(0, 100, func(key int, value [10]byte) bool { return true }))
+}
+
+func TestTearWrite_Encrypted_Detected(t *testing.T) {
+	opts := Options{EncryptionKey: encryptionKey}
+	bpTreeImpl, faulty := setupFaultyDB(t, opts)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Update(key, [10]byte{2})
+	}
+
+	faulty.TearWrite(1, 100)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	// The torn page can be the root, which Open reads already
+	reopened, err := crashAndOpen(faulty, opts)
+	if err == nil {
+		err = reopened.Verify()
+	}
+	assert.NotNil(t, err)
+}
+
+func TestStats_ReadFault_ReturnsError(t *testing.T) {
+	bpTreeImpl, faulty := setupFaultyDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+
+	faulty.FailRead(1)
+	_, err := bpTreeImpl.Stats()
+	assert.NotNil(t, err)
+	faulty.FailRead(1)
+	assert.NotNil(t, bpTreeImpl.Walk(func(node NodeInfo) bool { return true }))
+	faulty.FailRead(1)
+	assert.NotNil(t, bpTreeImpl.PrintTree(io.Discard))
+
+	assert.Equal(t, 100, treeStats(t, bpTreeImpl).Keys)
+}
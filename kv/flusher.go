@@ -0,0 +1,94 @@
+package kv
+
+import (
+	"time"
+)
+
+// DefaultDirtyRatio is the share of the buffer pool that may be dirty before the flusher started with
+// Options.FlushInterval writes pages back without waiting for the interval
+const DefaultDirtyRatio = 0.5
+
+// flusher writes dirty pages back in the background, so that foreground operations find clean frames to evict and do
+// not have to wait for a write
+type flusher struct {
+	ratio float64
+	wake  chan struct{} // Signaled by dirtied
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startFlusher writes dirty pages back every interval, and whenever more than ratio of the buffer pool is dirty,
+// until stopFlusher is called
+func (p *pager) startFlusher(interval time.Duration, ratio float64) {
+	if ratio <= 0 {
+		ratio = DefaultDirtyRatio
+	}
+	f := &flusher{ratio: ratio, wake: make(chan struct{}, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	p.flusher = f
+
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				p.writeBack()
+			case <-f.wake:
+				p.writeBack()
+			}
+		}
+	}()
+}
+
+// stopFlusher stops the flusher and waits until a running write back has finished. It must not be called with p.mu
+// held.
+func (p *pager) stopFlusher() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	f := p.flusher
+	p.flusher = nil
+	p.mu.Unlock()
+	if f == nil {
+		return
+	}
+
+	close(f.stop)
+	<-f.done
+}
+
+// writeBack writes the dirty pages that are not pinned, one at a time, so operations on the store can go on in
+// between. Errors are left to the operation that evicts the page, which tries to write it again. A failed store is
+// not written, its buffer pool may hold a half done change.
+func (p *pager) writeBack() {
+	for {
+		p.mu.Lock()
+		var written int
+		var err error
+		if p.failure == nil {
+			written, err = p.pool.WriteBack(1)
+		}
+		p.mu.Unlock()
+
+		if written == 0 || err != nil {
+			return
+		}
+	}
+}
+
+// dirtied wakes the flusher if more than its ratio of the buffer pool is dirty. The caller holds p.mu.
+func (p *pager) dirtied() {
+	if p.flusher == nil || p.pool.DirtyRatio() <= p.flusher.ratio {
+		return
+	}
+
+	select {
+	case p.flusher.wake <- struct{}{}:
+	default: // Already woken
+	}
+}
@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"testing"
+	"time"
+
+	"main/infrastructure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dirtyRatio returns the share of dirty frames in the buffer pool of the store
+func dirtyRatio(bpTree *BpTreeImpl) float64 {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+
+	return bpTree.pager.pool.DirtyRatio()
+}
+
+func TestFlusher_Interval_WritesDirtyPages(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, Options{FlushInterval: time.Millisecond, DirtyRatio: 1})
+	defer bpTreeImpl.DeleteStore(".")
+
+	bpTreeImpl.Put(1, [10]byte{1})
+
+	assert.Eventually(t, func() bool { return dirtyRatio(bpTreeImpl) == 0 }, time.Second, time.Millisecond)
+	value, err := bpTreeImpl.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{1}, value)
+}
+
+func TestFlusher_DirtyRatio_WritesBeforeInterval(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, Options{FlushInterval: time.Hour, DirtyRatio: 0.25})
+	defer bpTreeImpl.DeleteStore(".")
+
+	for key := 0; key < 100; key++ {
+		assert.Nil(t, bpTreeImpl.Put(key, [10]byte{1}))
+	}
+
+	assert.Eventually(t, func() bool { return dirtyRatio(bpTreeImpl) <= 0.25 }, time.Second, time.Millisecond)
+	assert.Nil(t, bpTreeImpl.Verify())
+}
+
+func TestClose_Flusher_Stopped(t *testing.T) {
+	opts := Options{FlushInterval: time.Millisecond}
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, opts)
+	defer bpTreeImpl.DeleteStore(".")
+	users, _ := bpTreeImpl.CreateTree("users")
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+		users.Put(key, [10]byte{2})
+	}
+
+	assert.Nil(t, users.Close())
+	assert.NotNil(t, bpTreeImpl.pager.flusher)
+	assert.Nil(t, bpTreeImpl.Close())
+	assert.Nil(t, bpTreeImpl.pager.flusher)
+
+	reopened, err := bpTreeImpl.OpenWithOptions(".", opts)
+	assert.Nil(t, err)
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 100, treeStats(t, reopened).Keys)
+	assert.Nil(t, reopened.Close())
+}
+
+func TestFlushAllpages_PinnedPage_StaysPinned(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	pool := bpTreeImpl.pager.pool
+	page, _ := pool.FetchPage(infrastructure.PageID(bpTreeImpl.RootPageId), infrastructure.AccessNormal)
+
+	assert.Nil(t, pool.FlushAllpages())
+
+	assert.Equal(t, 1, page.PinCounter)
+	assert.Nil(t, pool.UnpinPage(page.GetId(), false))
+}
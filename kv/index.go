@@ -0,0 +1,326 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"main/infrastructure"
+)
+
+// A secondary index is a tree of its own in the same store, whose keys are the secondary keys computed from the values
+// by the extractor of the index. The value of a secondary key that only one pair has holds its primary key and
+// expires with the pair. The value of a secondary key that several pairs share holds the root page id of a tree of
+// their primary keys, the postings, whose entries expire with the pairs. Postings that fit into a single leaf are
+// folded back into the value once a delete leaves one primary key, larger ones when Compact rewrites the index.
+// Both the secondary and the primary keys use the whole range of int, and the index is sorted by secondary key first
+// and primary key second.
+// The root page ids of the indexes are kept in the header, their extractors have to be registered again after Open.
+
+// ErrUnknownIndex is returned when an index has not been registered. The store cannot be modified as long as an index
+// it holds has not been registered again after Open, so that the index does not miss changes.
+var ErrUnknownIndex = errors.New(Package + " - index is not registered")
+
+// Extractor computes the secondary key of a value
+type Extractor func(value [10]byte) int
+
+// RegisterIndex adds a secondary index called name, which maps the secondary keys computed by extract to the primary
+// keys. A new index is built from all pairs in the store. If the store already holds an index with that name,
+// e.g. after Open, it is used as it is and extract has to compute the same secondary keys as before.
+func (bpTree *BpTreeImpl) RegisterIndex(name string, extract Extractor) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	if bpTree.Indexes == nil {
+		bpTree.Indexes = make(map[string]int)
+	}
+	if bpTree.extractors == nil {
+		bpTree.extractors = make(map[string]Extractor)
+	}
+	if _, ok := bpTree.Indexes[name]; ok {
+		bpTree.extractors[name] = extract
+		return nil
+	}
+
+	// The pages are checked first, so that a failed index is not left half built
+	pairs := 0
+	bpTree.scan(math.MinInt64, math.MaxInt64, func(key int, value [10]byte) bool {
+		pairs++
+		return true
+	})
+	if bpTree.pager.freePages() < pagesForIndex(pairs)+bpTree.catalogGrowth(len(name)) {
+		return ErrStoreFull
+	}
+
+	root := Node{IsLeaf: true, PageId: bpTree.pager.createNewNode(bpTree.Path).PageId}
+	bpTree.pager.writeNodeToPage(&root)
+	bpTree.Indexes[name] = root.PageId
+	bpTree.extractors[name] = extract
+
+	bpTree.scanKeys("", func(key string, value [10]byte, expires int64) bool {
+		bpTree.indexInsert(key, value, expires)
+		return true
+	})
+
+	return bpTree.syncIfDurable()
+}
+
+// DropIndex removes an index from the store and frees its pages
+func (bpTree *BpTreeImpl) DropIndex(name string) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	rootPageId, ok := bpTree.Indexes[name]
+	if !ok {
+		return ErrUnknownIndex
+	}
+
+	delete(bpTree.Indexes, name)
+	delete(bpTree.extractors, name)
+	bpTree.pager.freeIndex(rootPageId)
+
+	return bpTree.syncIfDurable()
+}
+
+// LookupBy returns the primary keys whose values have the secondary key key in the given index, in ascending order
+func (bpTree BpTreeImpl) LookupBy(index string, key int) ([]int, error) {
+	primaryKeys := []int{}
+	err := bpTree.ScanIndex(index, key, key, func(secondaryKey int, primaryKey int) bool {
+		primaryKeys = append(primaryKeys, primaryKey)
+		return true
+	})
+
+	return primaryKeys, err
+}
+
+// ScanIndex calls fn for every secondary key in [start, end] of the given index and the primary keys that have it,
+// sorted by secondary key first and primary key second, until fn returns false.
+// The store is locked while fn runs, so fn must not call methods of the store.
+func (bpTree BpTreeImpl) ScanIndex(index string, start int, end int, fn func(secondaryKey int, primaryKey int) bool) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	if _, ok := bpTree.extractors[index]; !ok {
+		return ErrUnknownIndex
+	}
+
+	return bpTree.index(index).scan(start, end, func(secondaryKey int, value [10]byte) bool {
+		kind, n := splitIndexValue(value)
+		if kind == indexPrimaryKey {
+			return fn(secondaryKey, n)
+		}
+
+		more := true
+		bpTree.postings(n).scan(math.MinInt64, math.MaxInt64, func(primaryKey int, _ [10]byte) bool {
+			more = fn(secondaryKey, primaryKey)
+			return more
+		})
+		return more
+	})
+}
+
+// index returns the tree of an index. Changes of its root have to be stored with setIndex.
+func (bpTree *BpTreeImpl) index(name string) *BpTreeImpl {
+	return &BpTreeImpl{Path: bpTree.Path, RootPageId: bpTree.Indexes[name], pager: bpTree.pager}
+}
+
+func (bpTree *BpTreeImpl) setIndex(name string, index *BpTreeImpl) {
+	bpTree.Indexes[name] = index.RootPageId
+}
+
+// postings returns the tree of the primary keys of a secondary key with the given root
+func (bpTree *BpTreeImpl) postings(rootPageId int) *BpTreeImpl {
+	return &BpTreeImpl{Path: bpTree.Path, RootPageId: rootPageId, pager: bpTree.pager}
+}
+
+// indexesRegistered returns ErrUnknownIndex if the store holds an index whose extractor has not been registered
+func (bpTree *BpTreeImpl) indexesRegistered() error {
+	for name := range bpTree.Indexes {
+		if _, ok := bpTree.extractors[name]; !ok {
+			return ErrUnknownIndex
+		}
+	}
+
+	return nil
+}
+
+// indexInsert adds a pair with an encoded key to all indexes, which have to be registered, see indexesRegistered.
+// The entries expire with the pair, so expired pairs are not found through the indexes either.
+func (bpTree *BpTreeImpl) indexInsert(key string, value [10]byte, expires int64) {
+	for name, extract := range bpTree.extractors {
+		index := bpTree.index(name)
+		index.insertPrimaryKey(extract(value), decodeKey(key), expires)
+		bpTree.setIndex(name, index)
+	}
+}
+
+// indexDelete removes a pair with an encoded key from all indexes
+func (bpTree *BpTreeImpl) indexDelete(key string, value [10]byte) {
+	for name, extract := range bpTree.extractors {
+		index := bpTree.index(name)
+		index.deletePrimaryKey(extract(value), decodeKey(key))
+		bpTree.setIndex(name, index)
+	}
+}
+
+// Kinds of the values of an index, followed by a primary key or a page id
+const (
+	indexPrimaryKey = 1 // The only primary key of the secondary key
+	indexPostings   = 2 // The root page id of the postings of the secondary key
+)
+
+func indexValue(kind byte, n int) [10]byte {
+	var value [10]byte
+	value[0] = kind
+	binary.BigEndian.PutUint64(value[1:], uint64(n))
+	return value
+}
+
+// splitIndexValue is the inverse of indexValue
+func splitIndexValue(value [10]byte) (byte, int) {
+	return value[0], int(binary.BigEndian.Uint64(value[1:]))
+}
+
+// insertPrimaryKey adds a primary key to a secondary key of the index. The first primary key of a secondary key is
+// stored in its value, the postings are created with the second one.
+func (index *BpTreeImpl) insertPrimaryKey(secondaryKey int, primaryKey int, expires int64) {
+	key := encodeKey(secondaryKey)
+	value, valueExpires, ok := index.entry(key)
+	if !ok {
+		index.putWithExpiry(key, indexValue(indexPrimaryKey, primaryKey), expires)
+		return
+	}
+
+	kind, n := splitIndexValue(value)
+	if kind == indexPrimaryKey && n == primaryKey {
+		index.setEntry(key, value, expires)
+		return
+	}
+	postings := index.postings(n)
+	if kind == indexPrimaryKey {
+		root := Node{IsLeaf: true, PageId: index.pager.createNewNode(index.Path).PageId}
+		index.pager.writeNodeToPage(&root)
+		postings = index.postings(root.PageId)
+		postings.putWithExpiry(encodeKey(n), [10]byte{}, valueExpires)
+	}
+	postings.putWithExpiry(encodeKey(primaryKey), [10]byte{}, expires)
+	index.setEntry(key, indexValue(indexPostings, postings.RootPageId), 0)
+}
+
+// deletePrimaryKey removes a primary key from a secondary key of the index. Postings whose root is a leaf are dropped
+// once one primary key or none is left.
+func (index *BpTreeImpl) deletePrimaryKey(secondaryKey int, primaryKey int) {
+	key := encodeKey(secondaryKey)
+	value, _, ok := index.entry(key)
+	if !ok {
+		return
+	}
+
+	kind, n := splitIndexValue(value)
+	if kind == indexPrimaryKey {
+		if n == primaryKey {
+			index.delete(key)
+		}
+		return
+	}
+
+	index.postings(n).delete(encodeKey(primaryKey))
+	root := index.pager.getNodeFromPageId(n)
+	if !root.IsLeaf {
+		return
+	}
+	var left []int
+	t := now().UnixNano()
+	for i := 0; i < root.numKeys; i++ {
+		if !expired(root.Expires[i], t) {
+			left = append(left, i)
+		}
+	}
+	switch len(left) {
+	case 0:
+		index.delete(key)
+	case 1:
+		index.setEntry(key, indexValue(indexPrimaryKey, decodeKey(root.Keys[left[0]])), root.Expires[left[0]])
+	default:
+		return
+	}
+	index.pager.pool.DeletePage(infrastructure.PageID(n))
+}
+
+// entry returns the value and the expiry of an encoded key of the tree and whether the key exists and has not expired
+func (bpTree *BpTreeImpl) entry(key string) ([10]byte, int64, bool) {
+	leaf, _ := bpTree.findLeaf(key)
+	i, found := leaf.search(key)
+	if !found || expired(leaf.Expires[i], now().UnixNano()) {
+		return [10]byte{}, 0, false
+	}
+
+	return leaf.Values[i], leaf.Expires[i], true
+}
+
+// setEntry replaces the value and the expiry of an existing encoded key of the tree
+func (bpTree *BpTreeImpl) setEntry(key string, value [10]byte, expires int64) {
+	leaf, _ := bpTree.findLeaf(key)
+	i, _ := leaf.search(key)
+	leaf.Values[i] = value
+	leaf.Expires[i] = expires
+	bpTree.pager.writeNodeToPage(leaf)
+}
+
+// postingRoots returns the root page ids of the postings of an index, also of secondary keys that have expired
+func (p *pager) postingRoots(rootPageId int) []int {
+	var roots []int
+	leaf, _ := BpTreeImpl{RootPageId: rootPageId, pager: p}.findLeaf("")
+	for {
+		for i := 0; i < leaf.numKeys; i++ {
+			if kind, n := splitIndexValue(leaf.Values[i]); kind == indexPostings {
+				roots = append(roots, n)
+			}
+		}
+
+		if leaf.NextPageId == 0 {
+			return roots
+		}
+		leaf = p.getNode(leaf.NextPageId, infrastructure.AccessSequential)
+	}
+}
+
+// freeIndex frees the pages of the index with the given root and of its postings
+func (p *pager) freeIndex(rootPageId int) {
+	for _, postingsRoot := range p.postingRoots(rootPageId) {
+		p.freeTree(postingsRoot)
+	}
+	p.freeTree(rootPageId)
+}
+
+// walkIndex calls fn for every node of the index with the given root and of its postings
+func (p *pager) walkIndex(rootPageId int, fn func(node NodeInfo) bool) {
+	p.walkNode(rootPageId, 0, fn)
+	for _, postingsRoot := range p.postingRoots(rootPageId) {
+		p.walkNode(postingsRoot, 0, fn)
+	}
+}
+
+// sweepIndex removes the entries that have expired at t from the index with the given root and from its postings
+func (p *pager) sweepIndex(rootPageId int, t int64) {
+	p.sweepTree(rootPageId, t, func(key string, value [10]byte) {})
+	for _, postingsRoot := range p.postingRoots(rootPageId) {
+		p.sweepTree(postingsRoot, t, func(key string, value [10]byte) {})
+	}
+}
+
+// freeTree frees the pages of the tree with the given root
+func (p *pager) freeTree(rootPageId int) {
+	var pageIds []int
+	p.walkNode(rootPageId, 0, func(node NodeInfo) bool {
+		pageIds = append(pageIds, node.PageId)
+		return true
+	})
+
+	for _, pageId := range pageIds {
+		p.pool.DeletePage(infrastructure.PageID(pageId))
+	}
+}
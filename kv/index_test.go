@@ -0,0 +1,245 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// byFirstByte indexes values by their first byte
+func byFirstByte(value [10]byte) int {
+	return int(value[0])
+}
+
+func TestRegisterIndex_ExistingPairs_Backfilled(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key % 3)})
+	}
+
+	assert.Nil(t, bpTreeImpl.RegisterIndex("mod3", byFirstByte))
+
+	primaryKeys, err := bpTreeImpl.LookupBy("mod3", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 33, len(primaryKeys))
+	for i, key := range primaryKeys {
+		assert.Equal(t, 3*i+1, key)
+	}
+}
+
+func TestIndex_PutDeleteUpdate_KeepsIndexInSync(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+
+	for key := 0; key < 50; key++ {
+		assert.Nil(t, bpTreeImpl.Put(key, [10]byte{'a'}))
+	}
+	assert.Nil(t, bpTreeImpl.Delete(10))
+	assert.Nil(t, bpTreeImpl.Update(20, [10]byte{'b'}))
+	assert.Nil(t, bpTreeImpl.Upsert(60, [10]byte{'b'}))
+	swapped, err := bpTreeImpl.CompareAndSwap(30, [10]byte{'a'}, [10]byte{'c'})
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+
+	a, _ := bpTreeImpl.LookupBy("first", 'a')
+	b, _ := bpTreeImpl.LookupBy("first", 'b')
+	c, _ := bpTreeImpl.LookupBy("first", 'c')
+	assert.Equal(t, 47, len(a))
+	assert.NotContains(t, a, 10)
+	assert.NotContains(t, a, 20)
+	assert.Equal(t, []int{20, 60}, b)
+	assert.Equal(t, []int{30}, c)
+	assert.Nil(t, bpTreeImpl.Verify())
+}
+
+func TestIndex_WriteBatch_KeepsIndexInSync(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	bpTreeImpl.Put(1, [10]byte{'a'})
+
+	var batch WriteBatch
+	batch.Delete(1)
+	for key := 2; key < 30; key++ {
+		batch.Put(key, [10]byte{'b'})
+	}
+	assert.Nil(t, bpTreeImpl.Write(&batch))
+
+	a, _ := bpTreeImpl.LookupBy("first", 'a')
+	b, _ := bpTreeImpl.LookupBy("first", 'b')
+	assert.Empty(t, a)
+	assert.Equal(t, 28, len(b))
+}
+
+func TestIndex_FullRangeKeys_SortedBySecondaryThenPrimaryKey(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("int64", func(value [10]byte) int {
+		return int(binary.BigEndian.Uint64(value[:]))
+	}))
+	secondaryKeys := []int{math.MinInt64, math.MinInt32 - 1, -1, 0, math.MaxInt32 + 1, math.MaxInt64}
+	primaryKeys := []int{math.MinInt64, -1 << 40, -1, 0, 1 << 40, math.MaxInt64}
+
+	for i, primaryKey := range primaryKeys {
+		for j := 0; j <= i; j++ {
+			var value [10]byte
+			binary.BigEndian.PutUint64(value[:], uint64(secondaryKeys[j]))
+			assert.Nil(t, bpTreeImpl.Upsert(primaryKey, value))
+		}
+	}
+
+	var pairs [][2]int
+	err := bpTreeImpl.ScanIndex("int64", math.MinInt64, math.MaxInt64, func(secondaryKey int, primaryKey int) bool {
+		pairs = append(pairs, [2]int{secondaryKey, primaryKey})
+		return true
+	})
+	assert.Nil(t, err)
+	expected := make([][2]int, len(primaryKeys))
+	for i, primaryKey := range primaryKeys {
+		expected[i] = [2]int{secondaryKeys[i], primaryKey}
+	}
+	assert.Equal(t, expected, pairs)
+}
+
+func TestIndex_SharedSecondaryKey_PostingsFoldedWhenOneLeft(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	bpTreeImpl.Put(1, [10]byte{'a'})
+	bpTreeImpl.pager.pool.FlushAllpages()
+	before, _, _ := bpTreeImpl.pager.storedPages(".")
+
+	for key := 2; key <= 5; key++ {
+		assert.Nil(t, bpTreeImpl.Put(key, [10]byte{'a'}))
+	}
+	primaryKeys, _ := bpTreeImpl.LookupBy("first", 'a')
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, primaryKeys)
+	assert.Equal(t, 1, treeStats(t, bpTreeImpl.index("first")).Keys)
+
+	for key := 5; key >= 2; key-- {
+		assert.Nil(t, bpTreeImpl.Delete(key))
+	}
+
+	primaryKeys, _ = bpTreeImpl.LookupBy("first", 'a')
+	assert.Equal(t, []int{1}, primaryKeys)
+	value, _, _ := bpTreeImpl.index("first").entry(encodeKey('a'))
+	assert.Equal(t, indexValue(indexPrimaryKey, 1), value)
+	bpTreeImpl.pager.pool.FlushAllpages()
+	after, _, _ := bpTreeImpl.pager.storedPages(".")
+	assert.Equal(t, len(before), len(after))
+}
+
+func TestCompact_LargePostings_Folded(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key % 2)})
+	}
+	for key := 2; key < 100; key++ {
+		bpTreeImpl.Delete(key)
+	}
+
+	_, err := bpTreeImpl.Compact()
+
+	assert.Nil(t, err)
+	assert.Nil(t, bpTreeImpl.Verify())
+	assert.Empty(t, bpTreeImpl.pager.postingRoots(bpTreeImpl.Indexes["first"]))
+	even, _ := bpTreeImpl.LookupBy("first", 0)
+	odd, _ := bpTreeImpl.LookupBy("first", 1)
+	assert.Equal(t, []int{0}, even)
+	assert.Equal(t, []int{1}, odd)
+	usage, _ := bpTreeImpl.Usage()
+	assert.Equal(t, 2, usage.Pages)
+}
+
+func TestScanIndex_Range_SortedBySecondaryKey(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 40; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(40 - key)})
+	}
+
+	var secondaryKeys, primaryKeys []int
+	err := bpTreeImpl.ScanIndex("first", 10, 14, func(secondaryKey int, primaryKey int) bool {
+		secondaryKeys = append(secondaryKeys, secondaryKey)
+		primaryKeys = append(primaryKeys, primaryKey)
+		return true
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{10, 11, 12, 13, 14}, secondaryKeys)
+	assert.Equal(t, []int{30, 29, 28, 27, 26}, primaryKeys)
+
+	_, err = bpTreeImpl.LookupBy("missing", 1)
+	assert.Equal(t, ErrUnknownIndex, err)
+}
+
+func TestIndex_Reopened_RequiresRegistration(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key % 2)})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(".")
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrUnknownIndex, reopened.Put(100, [10]byte{1}))
+	assert.Equal(t, ErrUnknownIndex, reopened.Delete(1))
+	assert.Nil(t, reopened.RegisterIndex("first", byFirstByte))
+	assert.Nil(t, reopened.Put(100, [10]byte{1}))
+	odd, _ := reopened.LookupBy("first", 1)
+	assert.Equal(t, 51, len(odd))
+}
+
+func TestIndex_CompactAndRestore_KeepIndex(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 200; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key % 4)})
+	}
+
+	_, err := bpTreeImpl.Compact()
+	assert.Nil(t, err)
+	primaryKeys, _ := bpTreeImpl.LookupBy("first", 3)
+	assert.Equal(t, 50, len(primaryKeys))
+
+	var dump bytes.Buffer
+	assert.Nil(t, bpTreeImpl.Dump(&dump))
+	assert.Nil(t, bpTreeImpl.DeleteStore("."))
+
+	restored, _ := setupTestDB(".", mem)
+	assert.Nil(t, restored.RegisterIndex("first", byFirstByte))
+	assert.Nil(t, restored.Restore(&dump))
+	primaryKeys, _ = restored.LookupBy("first", 3)
+	assert.Equal(t, 50, len(primaryKeys))
+	assert.Equal(t, 3, primaryKeys[0])
+}
+
+func TestDropIndex_FreesPages(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key % 3)})
+	}
+	bpTreeImpl.pager.pool.FlushAllpages()
+	before, _, _ := bpTreeImpl.pager.storedPages(".")
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+
+	assert.Nil(t, bpTreeImpl.DropIndex("first"))
+
+	bpTreeImpl.pager.pool.FlushAllpages()
+	after, _, _ := bpTreeImpl.pager.storedPages(".")
+	assert.Equal(t, len(before), len(after))
+	assert.Equal(t, ErrUnknownIndex, bpTreeImpl.DropIndex("first"))
+}
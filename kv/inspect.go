@@ -0,0 +1,209 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"main/infrastructure"
+)
+
+// ErrCorrupt is returned by Verify when the tree does not satisfy the properties of a B+-tree
+var ErrCorrupt = errors.New(Package + " - store is corrupt")
+
+// NodeInfo describes a node of the tree as it is stored on its page
+type NodeInfo struct {
+	PageId     int
+	Level      int // Distance from the root
+	IsLeaf     bool
+	Keys       []int // Decoded keys, the separators of inner nodes as they are truncated
+	Children   []int // Page ids of the children of an inner node
+	NextPageId int   // Page id of the next leaf, 0 for the last leaf
+}
+
+// Stats summarizes the shape of the tree
+type Stats struct {
+	Height     int
+	Keys       int
+	Leaves     int
+	InnerNodes int
+	Pages      int
+	FillFactor float64 // Share of the key slots of all leaves that is in use
+}
+
+func (node *Node) info(level int) NodeInfo {
+	keys := make([]int, node.numKeys)
+	for i := range keys {
+		keys[i] = decodeKey(node.Keys[i])
+	}
+	info := NodeInfo{node.PageId, level, node.IsLeaf, keys, nil, node.NextPageId}
+	if !node.IsLeaf {
+		info.Children = append([]int{}, node.Children[:node.numKeys+1]...)
+	}
+
+	return info
+}
+
+// Walk visits every node depth-first, parents before their children, until fn returns false.
+// The store is locked while fn runs, so fn must not call methods of the store.
+func (bpTree BpTreeImpl) Walk(fn func(node NodeInfo) bool) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	bpTree.pager.walkNode(bpTree.RootPageId, 0, fn)
+	return nil
+}
+
+func (p *pager) walkNode(pageId int, level int, fn func(node NodeInfo) bool) bool {
+	node := p.getNode(pageId, infrastructure.AccessOnce)
+	if !fn(node.info(level)) {
+		return false
+	}
+
+	if !node.IsLeaf {
+		for i := 0; i < node.numKeys+1; i++ {
+			if !p.walkNode(node.Children[i], level+1, fn) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// PrintTree writes every node depth-first, one per line, with its page id and keys
+func (bpTree BpTreeImpl) PrintTree(w io.Writer) error {
+	return bpTree.Walk(func(node NodeInfo) bool {
+		fmt.Fprint(w, "PageId:[", node.PageId, "] --- [")
+		for _, key := range node.Keys {
+			fmt.Fprint(w, key, " | ")
+		}
+		fmt.Fprintln(w, "]")
+		return true
+	})
+}
+
+// Stats returns statistics about the shape of the tree
+func (bpTree BpTreeImpl) Stats() (_ Stats, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	var stats Stats
+	bpTree.pager.walkNode(bpTree.RootPageId, 0, func(node NodeInfo) bool {
+		stats.Pages++
+		if node.Level+1 > stats.Height {
+			stats.Height = node.Level + 1
+		}
+
+		if node.IsLeaf {
+			stats.Leaves++
+			stats.Keys += len(node.Keys)
+		} else {
+			stats.InnerNodes++
+		}
+		return true
+	})
+
+	if stats.Leaves > 0 {
+		stats.FillFactor = float64(stats.Keys) / float64(stats.Leaves*MAX_BRANCHING_FACTOR)
+	}
+
+	return stats, nil
+}
+
+// Verify checks that the keys of every node are sorted and within the range given by the separators of its parent,
+// that all leaves are on the same level and that the chain of leaves visits them in key order.
+// Violations are reported as ErrCorrupt.
+func (bpTree BpTreeImpl) Verify() (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	verifier := treeVerifier{pager: bpTree.pager, leafLevel: -1}
+	err = verifier.verifyNode(bpTree.RootPageId, 0, keyRange{})
+	if err != nil {
+		return err
+	}
+
+	if verifier.previousLeaf != nil && verifier.previousLeaf.NextPageId != 0 {
+		return corruptf(verifier.previousLeaf.PageId, "last leaf points to page %d", verifier.previousLeaf.NextPageId)
+	}
+
+	return nil
+}
+
+// keyRange holds the bounds a node's encoded keys have to be in, lower <= key < upper
+type keyRange struct {
+	lower, upper       string
+	hasLower, hasUpper bool
+}
+
+func (r keyRange) contains(key string) bool {
+	return (!r.hasLower || key >= r.lower) && (!r.hasUpper || key < r.upper)
+}
+
+type treeVerifier struct {
+	pager        *pager
+	leafLevel    int   // Level of the first leaf, -1 before it has been found
+	previousLeaf *Node // Last leaf visited in key order
+}
+
+func (v *treeVerifier) verifyNode(pageId int, level int, bounds keyRange) error {
+	node := v.pager.getNode(pageId, infrastructure.AccessOnce)
+
+	if node.PageId != pageId {
+		return corruptf(pageId, "node claims to be stored on page %d", node.PageId)
+	}
+	if node.numKeys < 0 || node.numKeys > MAX_BRANCHING_FACTOR {
+		return corruptf(pageId, "%d keys", node.numKeys)
+	}
+	for i := 0; i < node.numKeys; i++ {
+		if i > 0 && node.Keys[i-1] >= node.Keys[i] {
+			return corruptf(pageId, "keys %x and %x are not sorted", node.Keys[i-1], node.Keys[i])
+		}
+		if !bounds.contains(node.Keys[i]) {
+			return corruptf(pageId, "key %x is outside of the range of its parent", node.Keys[i])
+		}
+	}
+
+	if node.IsLeaf {
+		if v.leafLevel == -1 {
+			v.leafLevel = level
+		} else if v.leafLevel != level {
+			return corruptf(pageId, "leaf on level %d, expected level %d", level, v.leafLevel)
+		}
+
+		if v.previousLeaf != nil && v.previousLeaf.NextPageId != pageId {
+			return corruptf(v.previousLeaf.PageId, "next leaf is page %d, expected page %d", v.previousLeaf.NextPageId, pageId)
+		}
+		v.previousLeaf = node
+
+		return nil
+	}
+
+	if node.numKeys == 0 {
+		return corruptf(pageId, "inner node without keys")
+	}
+	for i := 0; i < node.numKeys+1; i++ {
+		childBounds := bounds
+		if i > 0 {
+			childBounds.lower, childBounds.hasLower = node.Keys[i-1], true
+		}
+		if i < node.numKeys {
+			childBounds.upper, childBounds.hasUpper = node.Keys[i], true
+		}
+
+		err := v.verifyNode(node.Children[i], level+1, childBounds)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func corruptf(pageId int, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: page %d: %s", ErrCorrupt, pageId, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,224 @@
+package kv
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"main/infrastructure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScan(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 200; i++ {
+		bpTreeImpl.Put(i*2, [10]byte{byte(i)})
+	}
+
+	keys := make([]int, 0)
+	err := bpTreeImpl.Scan(51, 120, func(key int, value [10]byte) bool {
+		assert.Equal(t, [10]byte{byte(key / 2)}, value)
+		keys = append(keys, key)
+		return true
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 35, len(keys))
+	assert.Equal(t, 52, keys[0])
+	assert.Equal(t, 120, keys[len(keys)-1])
+}
+
+func TestScan_StopEarly(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 100; i++ {
+		bpTreeImpl.Put(i, [10]byte{})
+	}
+
+	count := 0
+	bpTreeImpl.Scan(0, 100, func(key int, value [10]byte) bool {
+		count++
+		return count < 25
+	})
+
+	assert.Equal(t, 25, count)
+}
+
+// countingDiskManager counts the pages read by the wrapped disk manager
+type countingDiskManager struct {
+	infrastructure.DiskManager
+	reads int64
+}
+
+func (c *countingDiskManager) ReadPage(pageID infrastructure.PageID) (*infrastructure.Page, error) {
+	atomic.AddInt64(&c.reads, 1)
+	return c.DiskManager.ReadPage(pageID)
+}
+
+// setupCountingDB creates a store with 200 keys and opens it again with an empty buffer pool, whose reads are counted
+func setupCountingDB(t *testing.T, opts Options) (*BpTreeImpl, *countingDiskManager) {
+	var counting *countingDiskManager
+	opts.wrapDisk = func(diskManager infrastructure.DiskManager) infrastructure.DiskManager {
+		counting = &countingDiskManager{DiskManager: diskManager}
+		return counting
+	}
+
+	var store BpTreeImpl
+	bpTreeImpl, err := store.CreateWithOptions(".", mem, opts)
+	assert.Nil(t, err)
+	for key := 0; key < 200; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.OpenWithOptions(".", opts)
+	assert.Nil(t, err)
+	return reopened, counting
+}
+
+func TestScan_ReadAhead_ReadsLeavesWhileFnRuns(t *testing.T) {
+	bpTreeImpl, counting := setupCountingDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+
+	count := 0
+	err := bpTreeImpl.Scan(0, 1000, func(key int, value [10]byte) bool {
+		if count == 0 {
+			reads := atomic.LoadInt64(&counting.reads)
+			assert.Eventually(t, func() bool {
+				return atomic.LoadInt64(&counting.reads) >= reads+DefaultReadAhead
+			}, time.Second, time.Millisecond)
+		}
+		assert.Equal(t, [10]byte{byte(key)}, value)
+		count++
+		return true
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, count)
+}
+
+func TestScan_ReadAheadDisabled_NoReadsWhileFnRuns(t *testing.T) {
+	bpTreeImpl, counting := setupCountingDB(t, Options{ReadAhead: -1})
+	defer bpTreeImpl.DeleteStore(".")
+
+	count := 0
+	err := bpTreeImpl.Scan(0, 1000, func(key int, value [10]byte) bool {
+		if count == 0 {
+			reads := atomic.LoadInt64(&counting.reads)
+			time.Sleep(10 * time.Millisecond)
+			assert.Equal(t, reads, atomic.LoadInt64(&counting.reads))
+		}
+		count++
+		return true
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, count)
+}
+
+func TestScan_ReadAhead_EmptiedLeaves(t *testing.T) {
+	bpTreeImpl, _ := setupCountingDB(t, Options{ReadAhead: 2})
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 20; key < 180; key++ {
+		bpTreeImpl.Delete(key)
+	}
+
+	keys := make([]int, 0)
+	err := bpTreeImpl.Scan(0, 1000, func(key int, value [10]byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 40, len(keys))
+	assert.Equal(t, 19, keys[19])
+	assert.Equal(t, 180, keys[20])
+}
+
+func TestStats(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 100; i++ {
+		bpTreeImpl.Put(i, [10]byte{})
+	}
+
+	stats := treeStats(t, bpTreeImpl)
+
+	assert.Equal(t, 100, stats.Keys)
+	assert.Equal(t, stats.Leaves+stats.InnerNodes, stats.Pages)
+	assert.Greater(t, stats.Height, 1)
+	assert.Greater(t, stats.FillFactor, 0.5)
+}
+
+func TestVerify(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 300; i++ {
+		bpTreeImpl.Put((i*7919)%300, [10]byte{})
+	}
+
+	assert.Nil(t, bpTreeImpl.Verify())
+}
+
+func TestVerify_UnsortedLeaf_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 30; i++ {
+		bpTreeImpl.Put(i, [10]byte{})
+	}
+
+	leaf, _ := bpTreeImpl.findLeaf(encodeKey(0))
+	leaf.Keys[0], leaf.Keys[1] = leaf.Keys[1], leaf.Keys[0]
+	bpTreeImpl.pager.writeNodeToPage(leaf)
+
+	err := bpTreeImpl.Verify()
+	assert.True(t, errors.Is(err, ErrCorrupt))
+}
+
+func TestClose_Reopen_KeysPresent(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 100; i++ {
+		bpTreeImpl.Put(i, [10]byte{byte(i)})
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(".")
+
+	assert.Nil(t, err)
+	assert.Equal(t, bpTreeImpl.RootPageId, reopened.RootPageId)
+	for i := 0; i < 100; i++ {
+		value, err := reopened.Get(i)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(i)}, value)
+	}
+}
+
+func TestScan_WorkingSet_StaysInPool(t *testing.T) {
+	bpTreeImpl, counting := setupCountingDB(t, Options{ReadAhead: -1})
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Get(0)
+
+	assert.Nil(t, bpTreeImpl.Scan(0, 1000, func(key int, value [10]byte) bool { return true }))
+	reads := atomic.LoadInt64(&counting.reads)
+	_, err := bpTreeImpl.Get(0)
+
+	assert.Nil(t, err)
+	assert.Equal(t, reads, atomic.LoadInt64(&counting.reads))
+}
+
+func TestVerify_WorkingSet_StaysInPool(t *testing.T) {
+	bpTreeImpl, counting := setupCountingDB(t, Options{})
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Get(100)
+
+	assert.Nil(t, bpTreeImpl.Verify())
+	reads := atomic.LoadInt64(&counting.reads)
+	_, err := bpTreeImpl.Get(100)
+
+	assert.Nil(t, err)
+	assert.Equal(t, reads, atomic.LoadInt64(&counting.reads))
+}
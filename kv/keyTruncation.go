@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"main/infrastructure"
+)
+
+// Keys are stored in nodes and on pages in their encoded form, as strings of bytes that are compared byte-wise.
+// Ints are encoded big-endian with the sign bit flipped, so that the byte order equals the numeric order and keys of
+// similar magnitude share their high-order bytes. Byte-string keys, see BytesTree, are stored as they are, so
+// URL-like keys share their scheme and host.
+
+// ErrKeyTooLong is returned for a byte-string key that is longer than MaxKeyLength bytes
+var ErrKeyTooLong = errors.New(Package + " - key is too long")
+
+const keySize = 8 // Size of an encoded int key
+
+// nodeHeaderSize is the size of the fields every node starts with on its page
+const nodeHeaderSize = 1 + 4*8
+
+// MaxKeyLength is the length of the longest byte-string key. A full leaf of such keys that share no prefix and
+// expire still fits into a page: every entry takes the key, its length, the value and the expiry.
+const MaxKeyLength = (infrastructure.PageSize - nodeHeaderSize - 2 - MAX_BRANCHING_FACTOR*(1+10+8)) / MAX_BRANCHING_FACTOR
+
+// encodeKey returns the byte-wise comparable encoding of key
+func encodeKey(key int) string {
+	var data [keySize]byte
+	binary.BigEndian.PutUint64(data[:], uint64(key)^(1<<63))
+	return string(data[:])
+}
+
+// decodeKey is the inverse of encodeKey. Missing low-order bytes of a truncated key are taken to be zero.
+func decodeKey(data string) int {
+	var full [keySize]byte
+	copy(full[:], data)
+	return int(binary.BigEndian.Uint64(full[:]) ^ (1 << 63))
+}
+
+// commonPrefixLen returns the length of the longest common prefix of a and b
+func commonPrefixLen(a string, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// splitPrefix returns the prefix shared by all keys and the remaining suffix of every key
+func splitPrefix(keys []string) (string, []string) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	prefixLen := len(keys[0])
+	for _, key := range keys[1:] {
+		if n := commonPrefixLen(keys[0][:prefixLen], key); n < prefixLen {
+			prefixLen = n
+		}
+	}
+
+	prefix := keys[0][:prefixLen]
+	suffixes := make([]string, len(keys))
+	for i, key := range keys {
+		suffixes[i] = key[len(prefix):]
+	}
+
+	return prefix, suffixes
+}
+
+// shortestSeparator returns the shortest s with left < s <= right. left has to be smaller than right.
+func shortestSeparator(left string, right string) string {
+	n := commonPrefixLen(left, right)
+	return right[:n+1]
+}
@@ -0,0 +1,129 @@
+package kv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeKey_KeepsOrder(t *testing.T) {
+	keys := []int{-1 << 40, -300, -1, 0, 1, 255, 256, 1 << 40}
+
+	for i := 1; i < len(keys); i++ {
+		assert.Less(t, encodeKey(keys[i-1]), encodeKey(keys[i]))
+		assert.Equal(t, keys[i], decodeKey(encodeKey(keys[i])))
+	}
+}
+
+func TestSplitPrefix_EncodedKeys(t *testing.T) {
+	keys := []string{encodeKey(1<<40 + 1), encodeKey(1<<40 + 2<<8), encodeKey(1<<40 + 3<<16)}
+
+	prefix, suffixes := splitPrefix(keys)
+
+	assert.Equal(t, encodeKey(1 << 40)[:5], prefix)
+	assert.Equal(t, "\x00\x00\x01", suffixes[0])
+	assert.Equal(t, "\x00\x02\x00", suffixes[1])
+	assert.Equal(t, "\x03\x00\x00", suffixes[2])
+}
+
+func TestSplitPrefix_ByteStringKeys(t *testing.T) {
+	keys := []string{"https://example.com/a", "https://example.com/b/c", "https://example.org"}
+
+	prefix, suffixes := splitPrefix(keys)
+
+	assert.Equal(t, "https://example.", prefix)
+	assert.Equal(t, []string{"com/a", "com/b/c", "org"}, suffixes)
+}
+
+func TestShortestSeparator_BetweenNeighbors(t *testing.T) {
+	pairs := [][2]string{
+		{encodeKey(100), encodeKey(300)},
+		{encodeKey(-1), encodeKey(0)},
+		{encodeKey(1<<40 + 17), encodeKey(1<<40 + 1<<20)},
+		{"https://example.com/abc", "https://example.com/b"},
+		{"https://a", "https://example"},
+		{"a", "ab"},
+	}
+
+	for _, pair := range pairs {
+		separator := shortestSeparator(pair[0], pair[1])
+
+		assert.Less(t, pair[0], separator)
+		assert.LessOrEqual(t, separator, pair[1])
+	}
+
+	// Only the bytes up to the first one that tells the keys apart are kept
+	assert.Equal(t, encodeKey(1<<40 + 2<<16)[:6], shortestSeparator(encodeKey(1<<40+1<<16+5), encodeKey(1<<40+2<<16)))
+	assert.Equal(t, "https://e", shortestSeparator("https://a", "https://example"))
+}
+
+func Test_NodeToPageSharedPrefix_PageToNode(t *testing.T) {
+	var leaf Node
+	leaf.IsLeaf = true
+	leaf.numKeys = MAX_BRANCHING_FACTOR
+	for i := 0; i < leaf.numKeys; i++ {
+		leaf.Keys[i] = encodeKey(1<<40 + i*3)
+		leaf.Values[i] = [10]byte{byte(i), 1, 2, 3}
+	}
+
+	data := leaf.serializeNode()
+	leafFromData := initializeNodeFromData(data)
+
+	assert.Equal(t, leaf.Keys, leafFromData.Keys)
+	assert.Equal(t, leaf.Values, leafFromData.Values)
+
+	// 33 bytes of header, the 7 byte prefix once and 1 byte of length, 1 byte of key + 10 bytes of value per entry
+	used := 33 + 1 + 7 + leaf.numKeys*12
+	assert.Equal(t, make([]byte, len(data)-used), data[used:])
+}
+
+func Test_NodeToPageByteStringKeys_PageToNode(t *testing.T) {
+	var leaf Node
+	leaf.IsLeaf = true
+	leaf.numKeys = 3
+	leaf.Keys[0] = "https://example.com/a"
+	leaf.Keys[1] = "https://example.com/b/c"
+	leaf.Keys[2] = "https://example.org"
+
+	data := leaf.serializeNode()
+
+	assert.Equal(t, leaf.Keys, initializeNodeFromData(data).Keys)
+	assert.Equal(t, 1, bytes.Count(data, []byte("https://example.")))
+}
+
+func Test_NodeToPageNegativeKeys_PageToNode(t *testing.T) {
+	var inner Node
+	inner.numKeys = 3
+	inner.Keys[0] = encodeKey(-1 << 40)
+	inner.Keys[1] = encodeKey(-1)
+	inner.Keys[2] = shortestSeparator(encodeKey(1<<50-1), encodeKey(1<<50))
+	inner.Children[0] = 1
+	inner.Children[1] = 2
+	inner.Children[2] = 3
+	inner.Children[3] = 4
+
+	innerFromData := initializeNodeFromData(inner.serializeNode())
+
+	assert.Equal(t, inner.Keys, innerFromData.Keys)
+	assert.Equal(t, inner.Children, innerFromData.Children)
+}
+
+func TestGet_LargeAndNegativeKeys(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	keys := make([]int, 0)
+	for i := -200; i < 200; i++ {
+		keys = append(keys, i*(1<<33)+i)
+	}
+	for _, key := range keys {
+		assert.Nil(t, bpTreeImpl.Put(key, [10]byte{byte(key)}))
+	}
+
+	for _, key := range keys {
+		value, err := bpTreeImpl.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(key)}, value)
+	}
+}
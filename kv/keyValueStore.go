@@ -5,6 +5,7 @@ import (
 	"errors"
 	"main/infrastructure"
 	"os"
+	"time"
 	"unsafe"
 )
 
@@ -25,12 +26,29 @@ var (
 
 	// ErrInvalidPath is returned when the path that has been given is not valid (inexistent/not writable)
 	ErrInvalidPath = errors.New(Package + " - 'path' is not valid")
+
+	// ErrInvalidEncryptionKey is returned when the encryption key is missing, has an invalid length or does not match the store
+	ErrInvalidEncryptionKey = errors.New(Package + " - encryption key is not valid")
+
+	// ErrFailed is returned by every operation after a change of the store was interrupted by a page error, until the
+	// store is opened again
+	ErrFailed = errors.New(Package + " - store failed, open it again")
+
+	// ErrUnsupportedVersion is returned by Open if the store has been written in another format, see FormatVersion
+	ErrUnsupportedVersion = errors.New(Package + " - format version of the store is not supported")
 )
 
+// FormatVersion is the format of the header and the pages of the stores written by this package. It is kept in the
+// header, and Open only opens stores of this version; Dump and Restore migrate the pairs of other versions.
+const FormatVersion = 2
+
 // Optimal max branching factor with our page structrue would be:  PageSize - Sizeof(bool) - 2x Sizeof(PageId)  / Sizeof(key,value)
 // Note that Sizeof(PageId) describes the
 const MAX_BRANCHING_FACTOR = 10 // int(((float32(infrastructure.PageSize - 1 - 16)) * 0.8) / 18)
 
+// DefaultReadAhead is the number of leaves a scan reads ahead unless Options.ReadAhead is set
+const DefaultReadAhead = 4
+
 type Page = infrastructure.Page
 
 type KeyValueStore interface {
@@ -45,10 +63,44 @@ type KeyValueStore interface {
 	DeleteStore(string) error                  // Deletes the KeyValueStore. Error if no KeyValueStore at that path
 }
 
+// Options configures a store when it is created or opened. The zero value is the default configuration.
+type Options struct {
+	Durable       bool          // Flush dirty pages and rewrite the KVSTORE header after every write
+	Compression   bool          // Compress pages before they are written to disk
+	EncryptionKey []byte        // Encrypt pages on disk with AES-GCM. 16, 24 or 32 bytes long, it is never persisted.
+	SweepInterval time.Duration // Remove expired pairs in the background at this interval until Close, 0 disables it
+	ChangeLogSize int           // Number of changes retained for WatchFrom, DefaultChangeLogSize if 0
+	Mmap          bool          // Store the pages in one memory-mapped file, which is read without copying. Only used by Create.
+	FlushInterval time.Duration // Write dirty pages back in the background at this interval until Close, 0 disables it
+	DirtyRatio    float64       // Share of dirty frames at which the flusher does not wait for the interval, DefaultDirtyRatio if 0
+	ReadAhead     int           // Leaves a scan reads ahead in the background, DefaultReadAhead if 0, none if negative
+	MaxPages      int           // Pages the store may use, the size given to Create divided by PageSize if 0. Only used by Create.
+
+	// wrapDisk wraps the disk manager that stores the pages if it is set, e.g. to inject faults in tests
+	wrapDisk func(infrastructure.DiskManager) infrastructure.DiskManager
+}
+
 type BpTreeImpl struct {
-	MaxMem     int
-	Path       string
-	RootPageId int
+	MaxMem         int
+	Path           string
+	RootPageId     int
+	Encrypted      bool           // Pages can only be read with the EncryptionKey the store was created with
+	Indexes        map[string]int // Root page ids of the secondary indexes by name
+	CatalogPageId  int            // First page of the catalog of the named trees, 0 without named trees
+	Sequence       uint64         // Sequence number of the last change, see Watch
+	LeaderSequence uint64         // Sequence number of the leader up to which changes have been applied, see Follower
+	Mapped         bool           // Pages are stored in one memory-mapped file, see Options.Mmap
+	MaxPages       int            // Pages the store may use, see Options.MaxPages. 0 for stores created before, which use DiskMaxNumPages.
+	Version        int            // Format of the header and the pages, see FormatVersion
+	options        Options
+	extractors     map[string]Extractor
+	name           string                 // Name of a named tree, empty for the store
+	byteKeys       bool                   // Keys are byte strings, see BytesTree
+	store          *BpTreeImpl            // Store of a named tree, nil for the store
+	trees          map[string]*BpTreeImpl // Named trees of the store that have been created or opened
+	sweeper        *sweeper               // Started with Options.SweepInterval
+	changes        *changeLog             // Change log of the store, nil for named trees and indexes
+	pager          *pager                 // Pages of the store, shared by its named trees and indexes
 }
 type Node struct {
 	IsLeaf       bool
@@ -56,14 +108,19 @@ type Node struct {
 	ParentPageId int
 	NextPageId   int
 	numKeys      int
-	Keys         [MAX_BRANCHING_FACTOR + 1]int
+	Keys         [MAX_BRANCHING_FACTOR + 1]string // Encoded keys, see encodeKey
 	Values       [MAX_BRANCHING_FACTOR + 1][10]byte
+	Expires      [MAX_BRANCHING_FACTOR + 1]int64 // Expiry of every pair in unix nanoseconds, 0 if it does not expire
 	Children     [MAX_BRANCHING_FACTOR + 1]int
 	page         *Page
 }
 
 func (k *BpTreeImpl) Create(Path string, size int) (*BpTreeImpl, error) {
+	return k.CreateWithOptions(Path, size, Options{})
+}
 
+// CreateWithOptions creates a store like Create and configures it with opts
+func (k *BpTreeImpl) CreateWithOptions(Path string, size int, opts Options) (_ *BpTreeImpl, err error) {
 	if size <= 0 {
 		k.MaxMem = 1 << (10 * 3) // 1 GB = Default value
 	} else {
@@ -81,46 +138,65 @@ func (k *BpTreeImpl) Create(Path string, size int) (*BpTreeImpl, error) {
 		return nil, ErrInvalidPath
 	}
 
-	// Initialize bufferPoolManager
-	diskManager := infrastructure.NewDiskManagerMock()
-	clockReplacer := infrastructure.NewClockReplacer(infrastructure.PoolSize)
-	bufferPoolManager = *infrastructure.NewBufferPoolManager(diskManager, clockReplacer)
+	// The store gets its own buffer pool and disk manager. Pages left behind by a deleted store must not be taken over.
+	if opts.Mmap {
+		os.Remove(k.Path + "/KVSTOREDATA")
+	}
+	pager, err := newPager(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer pager.recoverPageError(&err, false)
+	maxPages := opts.MaxPages
+	if maxPages <= 0 {
+		maxPages = k.MaxMem / infrastructure.PageSize
+	}
+	pager.setMaxPages(maxPages)
 
 	// Create root node
 	var bpTree BpTreeImpl
-	rootPage := bufferPoolManager.NewPage(k.Path)
-
-	rootPage.IncPinCount()
+	rootPage := pager.newPage(k.Path)
 
 	var root Node
 	root.page = rootPage
 	root.IsLeaf = true
-	bpTree.Path = Path
-	bpTree.RootPageId = int(rootPage.GetId())
-
-	bufferPoolManager.FlushPage(rootPage.GetId())
-
-	CreateKVStore(bpTree)
-
-	return &bpTree, nil
-}
-
-var bufferPoolManager infrastructure.BufferPoolManager // Move to bpTree?
+	root.PageId = int(rootPage.GetId())
+	bpTree.MaxMem = k.MaxMem
+	bpTree.Path = k.Path
+	bpTree.RootPageId = root.PageId
+	bpTree.Encrypted = opts.EncryptionKey != nil
+	bpTree.Mapped = opts.Mmap
+	bpTree.MaxPages = maxPages
+	bpTree.Version = FormatVersion
+	bpTree.options = opts
+	bpTree.pager = pager
+
+	pager.pool.UnpinPage(rootPage.GetId(), true)
+	pager.writeNodeToPage(&root)
+	err = pager.pool.FlushPage(rootPage.GetId())
+	if err != nil {
+		return nil, err
+	}
 
-func getNodeFromPageId(pageId int) *Node {
-	page := bufferPoolManager.FetchPage(infrastructure.PageID(pageId))
-	return initializeNodeFromData(page.GetData())
-}
+	err = CreateKVStore(bpTree)
+	if err != nil {
+		return nil, err
+	}
 
-func (node *Node) writeNodeToPage() {
-	data := node.serializeNode()
-	page := bufferPoolManager.FetchPage(infrastructure.PageID(node.PageId))
+	// A change log left behind by a deleted store must not be continued
+	os.Remove(bpTree.Path + "/KVCHANGES")
+	bpTree.changes, _, err = openChangeLog(bpTree.Path, opts.ChangeLogSize)
+	if err != nil {
+		return nil, err
+	}
 
-	if page != nil { // Unpersisted page
-		page.SetData(data)
-	} else {
-		bufferPoolManager.NewPage(page.Path).SetData(data)
+	if opts.SweepInterval > 0 {
+		bpTree.startSweeper(opts.SweepInterval)
+	}
+	if opts.FlushInterval > 0 {
+		bpTree.pager.startFlusher(opts.FlushInterval, opts.DirtyRatio)
 	}
+	return &bpTree, nil
 }
 
 func initializeNodeFromData(data []byte) *Node {
@@ -151,22 +227,42 @@ func initializeNodeFromData(data []byte) *Node {
 	curIndex += intSize
 
 	if node.IsLeaf == false {
-		// Parsing inner node
+		// Parsing inner node, every separator is stored with its length
 		for i := 0; i < node.numKeys+1; i++ {
 			node.Children[i] = *(*int)(unsafe.Pointer(&data[curIndex]))
 			curIndex += (int)(unsafe.Sizeof(node.Children[i]))
-			node.Keys[i] = *(*int)(unsafe.Pointer(&data[curIndex]))
-			curIndex += (int)(unsafe.Sizeof(node.Keys[i]))
+			if i < node.numKeys {
+				keyLen := int(data[curIndex])
+				curIndex++
+				node.Keys[i] = string(data[curIndex : curIndex+keyLen])
+				curIndex += keyLen
+			}
 		}
 
 	} else {
-		// Parsing leaf node
-		for i := 0; i < node.numKeys+1; i++ {
-			node.Keys[i] = *(*int)(unsafe.Pointer(&data[curIndex]))
-			curIndex += (int)(unsafe.Sizeof(node.Keys[i]))
+		// Parsing leaf node, the common prefix of all keys is stored once and every suffix with its length
+		prefixLen := int(data[curIndex])
+		curIndex++
+		prefix := string(data[curIndex : curIndex+prefixLen])
+		curIndex += prefixLen
+
+		for i := 0; i < node.numKeys; i++ {
+			suffixLen := int(data[curIndex])
+			curIndex++
+			node.Keys[i] = prefix + string(data[curIndex:curIndex+suffixLen])
+			curIndex += suffixLen
 			node.Values[i] = *(*[10]byte)(unsafe.Pointer(&data[curIndex]))
 			curIndex += 10
 		}
+
+		// Expiries are only stored if a pair of the leaf expires, older pages are padded with zeros here
+		if curIndex < len(data) && data[curIndex] == 1 {
+			curIndex++
+			for i := 0; i < node.numKeys; i++ {
+				node.Expires[i] = *(*int64)(unsafe.Pointer(&data[curIndex]))
+				curIndex += 8
+			}
+		}
 	}
 
 	return &node
@@ -180,94 +276,191 @@ func (node *Node) serializeNode() []byte {
 	currentIndex++
 
 	// PageId
-	data[currentIndex] = *(*byte)(unsafe.Pointer(&node.PageId))
-	var len = (int)(unsafe.Sizeof(node.PageId))
-	currentIndex += len
+	*(*int)(unsafe.Pointer(&data[currentIndex])) = node.PageId
+	intSize := (int)(unsafe.Sizeof(node.PageId))
+	currentIndex += intSize
 
 	// ParentPageId
-	data[currentIndex] = *(*byte)(unsafe.Pointer(&node.ParentPageId))
-	currentIndex += len
+	*(*int)(unsafe.Pointer(&data[currentIndex])) = node.ParentPageId
+	currentIndex += intSize
 
 	// NextPageId
-	data[currentIndex] = *(*byte)(unsafe.Pointer(&node.NextPageId))
-	currentIndex += len
+	*(*int)(unsafe.Pointer(&data[currentIndex])) = node.NextPageId
+	currentIndex += intSize
 
 	// numKeys
-	data[currentIndex] = *(*byte)(unsafe.Pointer(&node.numKeys))
-	currentIndex += len
+	*(*int)(unsafe.Pointer(&data[currentIndex])) = node.numKeys
+	currentIndex += intSize
 
 	if node.IsLeaf {
+		// Key prefix truncation: store the prefix shared by all keys once, followed by the suffix of every key
+		prefix, suffixes := splitPrefix(node.Keys[:node.numKeys])
+
+		data[currentIndex] = byte(len(prefix))
+		currentIndex++
+		currentIndex += copy(data[currentIndex:], prefix)
+
 		for i := 0; i < node.numKeys; i++ {
-			data[currentIndex] = *(*byte)(unsafe.Pointer(&node.Keys[i]))
-			var len = (int)(unsafe.Sizeof(node.Keys[i]))
-			currentIndex += len
+			data[currentIndex] = byte(len(suffixes[i]))
+			currentIndex++
+			currentIndex += copy(data[currentIndex:], suffixes[i])
 			for j := 0; j < 10; j++ {
-				data[currentIndex] = *(*byte)(unsafe.Pointer(&node.Values[i][j]))
+				data[currentIndex] = node.Values[i][j]
 				currentIndex++
 			}
 		}
+
+		if node.hasExpiries() {
+			data[currentIndex] = 1
+			currentIndex++
+			for i := 0; i < node.numKeys; i++ {
+				*(*int64)(unsafe.Pointer(&data[currentIndex])) = node.Expires[i]
+				currentIndex += 8
+			}
+		}
 	} else {
-		for i := 0; i < MAX_BRANCHING_FACTOR+1; i++ {
-			data[currentIndex] = *(*byte)(unsafe.Pointer(&node.Children[i]))
-			var len = (int)(unsafe.Sizeof(node.Children[i]))
-			currentIndex += len
-			data[currentIndex] = *(*byte)(unsafe.Pointer(&node.Keys[i]))
-			currentIndex += len
+		for i := 0; i < node.numKeys+1; i++ {
+			*(*int)(unsafe.Pointer(&data[currentIndex])) = node.Children[i]
+			currentIndex += intSize
+			if i < node.numKeys {
+				key := node.Keys[i]
+				data[currentIndex] = byte(len(key))
+				currentIndex++
+				currentIndex += copy(data[currentIndex:], key)
+			}
 		}
 	}
 
 	return data[:]
 }
 
-func (bpTree BpTreeImpl) Get(key int) ([10]byte, error) {
-	var retValue [10]byte
-	var root *Node = getNodeFromPageId(bpTree.RootPageId)
+// Scan calls fn for every key in [start, end] in ascending order, until fn returns false.
+// It follows the chain of leaves, so only the first leaf has to be looked up from the root.
+// The store is locked while fn runs, so fn must not call methods of the store.
+func (bpTree BpTreeImpl) Scan(start int, end int, fn func(key int, value [10]byte) bool) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
 
-	if root == nil {
-		return retValue, ErrNotFound
-	}
+	return bpTree.scan(start, end, fn)
+}
 
-	iteratorNode := root
+func (bpTree BpTreeImpl) scan(start int, end int, fn func(key int, value [10]byte) bool) error {
+	return bpTree.scanEntries(start, end, func(key int, value [10]byte, expires int64) bool {
+		return fn(key, value)
+	})
+}
 
-	for !iteratorNode.IsLeaf {
-		for i := 0; i < iteratorNode.numKeys; i++ {
-			if key < iteratorNode.Keys[i] {
-				iteratorNode = getNodeFromPageId(iteratorNode.Children[i])
-				break
+// scanEntries works like scan, but also passes the expiry of every pair to fn. Expired pairs are skipped.
+func (bpTree BpTreeImpl) scanEntries(start int, end int, fn func(key int, value [10]byte, expires int64) bool) error {
+	last := encodeKey(end)
+	return bpTree.scanKeys(encodeKey(start), func(key string, value [10]byte, expires int64) bool {
+		return key <= last && fn(decodeKey(key), value, expires)
+	})
+}
+
+// scanKeys calls fn for every encoded key from start on in ascending order, with its value and expiry, until fn
+// returns false. Expired pairs are skipped. The leaves that follow are read ahead while fn runs, see readAhead.
+func (bpTree BpTreeImpl) scanKeys(start string, fn func(key string, value [10]byte, expires int64) bool) error {
+	defer bpTree.pager.pool.CancelPrefetches()
+	leaf, parent := bpTree.findLeaf(start)
+	t := now().UnixNano()
+
+	for {
+		parent = bpTree.readAhead(leaf, parent)
+
+		for i := 0; i < leaf.numKeys; i++ {
+			if leaf.Keys[i] < start || expired(leaf.Expires[i], t) {
+				continue
 			}
-			if i == iteratorNode.numKeys-1 {
-				iteratorNode = getNodeFromPageId(iteratorNode.Children[i])
+			if !fn(leaf.Keys[i], leaf.Values[i], leaf.Expires[i]) {
+				return nil
 			}
 		}
-	}
 
-	for i := 0; i < iteratorNode.numKeys; i++ {
-		if iteratorNode.Keys[i] == key {
-			return iteratorNode.Values[i], nil
+		if leaf.NextPageId == 0 {
+			return nil
 		}
+		leaf = bpTree.pager.getNode(leaf.NextPageId, infrastructure.AccessSequential)
+	}
+}
+
+func (bpTree BpTreeImpl) Get(key int) (_ [10]byte, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, false)
+
+	return bpTree.get(encodeKey(key))
+}
+
+func (bpTree BpTreeImpl) get(key string) ([10]byte, error) {
+	var retValue [10]byte
+	var root *Node = bpTree.pager.getNodeFromPageId(bpTree.RootPageId)
+
+	if root == nil {
+		return retValue, ErrNotFound
+	}
+
+	iteratorNode, _ := bpTree.findLeaf(key)
+
+	if i, found := iteratorNode.search(key); found && !expired(iteratorNode.Expires[i], now().UnixNano()) {
+		return iteratorNode.Values[i], nil
 	}
 
 	return retValue, ErrNotFound
 }
 
-func (bpTree *BpTreeImpl) Put(key int, value [10]byte) error {
-	// Root node should always be pinned
-	rootNode := getNodeFromPageId(bpTree.RootPageId)
+// readAhead prefetches the leaves that follow leaf below parent, at most Options.ReadAhead of them, and returns
+// parent. Leaves do not know their parent, so once the scan has left parent, the parent of leaf is looked up again.
+func (bpTree BpTreeImpl) readAhead(leaf *Node, parent *Node) *Node {
+	count := bpTree.options.ReadAhead
+	if count == 0 {
+		count = DefaultReadAhead
+	}
+	if count < 0 || parent == leaf || bpTree.pager.failure != nil {
+		return parent
+	}
 
-	// Empty bpTree insert at root
-	if rootNode.numKeys == 0 {
-		rootNode.PageId = bpTree.RootPageId
-		rootNode.Keys[0] = key
-		rootNode.Values[0] = value
-		rootNode.IsLeaf = true
-		rootNode.numKeys = 1
+	i := parent.childIndex(leaf.PageId)
+	if i < 0 {
+		if leaf.numKeys == 0 {
+			return parent // An emptied leaf cannot be looked up, the next one can
+		}
+		_, parent = bpTree.descend(leaf.Keys[0], infrastructure.AccessSequential)
+		i = parent.childIndex(leaf.PageId)
+		if i < 0 {
+			return parent
+		}
+	}
 
-		rootNode.writeNodeToPage()
+	var pageIds []infrastructure.PageID
+	for j := i + 1; j <= parent.numKeys && len(pageIds) < count; j++ {
+		pageIds = append(pageIds, infrastructure.PageID(parent.Children[j]))
+	}
+	bpTree.pager.pool.Prefetch(pageIds...)
+	return parent
+}
 
-		return nil
+// childIndex returns the position of pageId among the children of an inner node, or -1
+func (node *Node) childIndex(pageId int) int {
+	for i := 0; i <= node.numKeys; i++ {
+		if node.Children[i] == pageId {
+			return i
+		}
 	}
 
-	var iteratorNode *Node = getNodeFromPageId(bpTree.RootPageId)
+	return -1
+}
+
+// findLeaf descends from the root to the leaf that is responsible for key.
+// It also returns the parent of that leaf, which is the leaf itself if the root is a leaf.
+func (bpTree BpTreeImpl) findLeaf(key string) (*Node, *Node) {
+	return bpTree.descend(key, infrastructure.AccessNormal)
+}
+
+// descend works like findLeaf and reads the nodes below the root with hint, see infrastructure.AccessHint
+func (bpTree BpTreeImpl) descend(key string, hint infrastructure.AccessHint) (*Node, *Node) {
+	var iteratorNode *Node = bpTree.pager.getNodeFromPageId(bpTree.RootPageId)
 	parent := iteratorNode
 
 	for iteratorNode.IsLeaf == false {
@@ -275,125 +468,352 @@ func (bpTree *BpTreeImpl) Put(key int, value [10]byte) error {
 		for i := 0; i < parent.numKeys; i++ {
 			// Travers pointer to the left of tree (key < fence pointer)
 			if key < iteratorNode.Keys[i] {
-				iteratorNode = getNodeFromPageId(iteratorNode.Children[i])
+				iteratorNode = bpTree.pager.getNode(iteratorNode.Children[i], hint)
 				break
 			}
 
 			// Travers pointer to the right of tree (key > fence pointer)
 			if i == iteratorNode.numKeys-1 {
-				iteratorNode = getNodeFromPageId(iteratorNode.Children[i+1])
+				iteratorNode = bpTree.pager.getNode(iteratorNode.Children[i+1], hint)
 				break
 			}
 		}
 	}
 
-	// Current node has space
-	if iteratorNode.numKeys < MAX_BRANCHING_FACTOR {
+	return iteratorNode, parent
+}
 
-		// Find insertion point
-		i := 0
-		for key > iteratorNode.Keys[i] && i < iteratorNode.numKeys {
-			i++
-		}
+// search returns the position of key in the node and whether it is present.
+// If it is not present, the position is where the key would have to be inserted.
+func (node *Node) search(key string) (int, bool) {
+	i := 0
+	for i < node.numKeys && key > node.Keys[i] {
+		i++
+	}
 
-		if key == iteratorNode.Keys[i] {
-			return ErrSameKeyTwice
-		}
+	return i, i < node.numKeys && node.Keys[i] == key
+}
 
-		// Move keys
-		for j := iteratorNode.numKeys; j > i; j-- {
-			iteratorNode.Keys[j] = iteratorNode.Keys[j-1]
-		}
+// insertAt inserts a key / value pair that expires at expires at position i of a leaf that has space left
+func (node *Node) insertAt(i int, key string, value [10]byte, expires int64) {
+	for j := node.numKeys; j > i; j-- {
+		node.Keys[j] = node.Keys[j-1]
+		node.Values[j] = node.Values[j-1]
+		node.Expires[j] = node.Expires[j-1]
+	}
 
-		// Insert at found position
-		iteratorNode.Keys[i] = key
-		iteratorNode.Values[i] = value
+	node.Keys[i] = key
+	node.Values[i] = value
+	node.Expires[i] = expires
+	node.numKeys++
+}
+
+// removeAt removes the key / value pair at position i of a leaf
+func (node *Node) removeAt(i int) {
+	for j := i; j < node.numKeys-1; j++ {
+		node.Keys[j] = node.Keys[j+1]
+		node.Values[j] = node.Values[j+1]
+		node.Expires[j] = node.Expires[j+1]
+	}
+
+	node.numKeys--
+	node.Keys[node.numKeys] = ""
+	node.Values[node.numKeys] = [10]byte{}
+	node.Expires[node.numKeys] = 0
+}
+
+func (bpTree *BpTreeImpl) Put(key int, value [10]byte) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	err = bpTree.reserve(1)
+	if err != nil {
+		return err
+	}
+	err = bpTree.put(encodeKey(key), value)
+	if err != nil {
+		return err
+	}
+
+	return bpTree.syncIfDurable()
+}
+
+func (bpTree *BpTreeImpl) put(key string, value [10]byte) error {
+	return bpTree.putWithExpiry(key, value, 0)
+}
+
+// putWithExpiry inserts a pair that expires at expires, in unix nanoseconds, or never if it is 0.
+// An expired pair with the same key is replaced. The pages it may allocate have to be reserved before, see reserve.
+func (bpTree *BpTreeImpl) putWithExpiry(key string, value [10]byte, expires int64) error {
+	err := bpTree.indexesRegistered()
+	if err != nil {
+		return err
+	}
+
+	rootNode := bpTree.pager.getNodeFromPageId(bpTree.RootPageId)
+
+	// Empty bpTree insert at root
+	if rootNode.numKeys == 0 {
+		rootNode.PageId = bpTree.RootPageId
+		rootNode.Keys[0] = key
+		rootNode.Values[0] = value
+		rootNode.Expires[0] = expires
+		rootNode.IsLeaf = true
+		rootNode.numKeys = 1
+
+		bpTree.pager.writeNodeToPage(rootNode)
+		bpTree.indexInsert(key, value, expires)
+		bpTree.record(Change{Kind: ChangePut, Key: decodeKey(key), NewValue: value, Expires: expires})
 
-		iteratorNode.numKeys++
+		return nil
+	}
 
-		// Shift pointers
-		iteratorNode.Children[iteratorNode.numKeys] = iteratorNode.Children[iteratorNode.numKeys-1]
-		iteratorNode.Children[iteratorNode.numKeys-1] = 0
+	iteratorNode, parent := bpTree.findLeaf(key)
 
-		iteratorNode.writeNodeToPage()
+	// Find insertion point
+	i, found := iteratorNode.search(key)
+	if found && !expired(iteratorNode.Expires[i], now().UnixNano()) {
+		return ErrSameKeyTwice
+	}
+
+	if found {
+		// The expired pair is replaced in place
+		old := iteratorNode.Values[i]
+		iteratorNode.Values[i] = value
+		iteratorNode.Expires[i] = expires
+		bpTree.pager.writeNodeToPage(iteratorNode)
+		bpTree.indexDelete(key, old)
+		bpTree.indexInsert(key, value, expires)
+		bpTree.record(Change{Kind: ChangePut, Key: decodeKey(key), NewValue: value, Expires: expires})
+		return nil
+	}
+
+	// Current node has space
+	if iteratorNode.numKeys < MAX_BRANCHING_FACTOR {
+		iteratorNode.insertAt(i, key, value, expires)
+		bpTree.pager.writeNodeToPage(iteratorNode)
 	} else // Current node has no space
 	{
-		var newLeaf = createNewNode(bpTree.Path)
+		var newLeaf = bpTree.pager.createNewNode(bpTree.Path)
 
-		var copyKeys [MAX_BRANCHING_FACTOR + 2]int
+		var copyKeys [MAX_BRANCHING_FACTOR + 1]string
+		var copyValues [MAX_BRANCHING_FACTOR + 1][10]byte
+		var copyExpires [MAX_BRANCHING_FACTOR + 1]int64
 
 		// Copy keys from current node
 		copy(copyKeys[:MAX_BRANCHING_FACTOR], iteratorNode.Keys[:MAX_BRANCHING_FACTOR])
+		copy(copyValues[:MAX_BRANCHING_FACTOR], iteratorNode.Values[:MAX_BRANCHING_FACTOR])
+		copy(copyExpires[:MAX_BRANCHING_FACTOR], iteratorNode.Expires[:MAX_BRANCHING_FACTOR])
 
-		// Find insertion point
-		i := 0
-		for key > copyKeys[i] && i < MAX_BRANCHING_FACTOR {
-			i++
-		}
-
-		if key == iteratorNode.Keys[i] {
-			return ErrSameKeyTwice
-		}
-
-		for j := MAX_BRANCHING_FACTOR + 1; j > i; j-- {
+		for j := MAX_BRANCHING_FACTOR; j > i; j-- {
 			copyKeys[j] = copyKeys[j-1]
+			copyValues[j] = copyValues[j-1]
+			copyExpires[j] = copyExpires[j-1]
 		}
 
 		copyKeys[i] = key
+		copyValues[i] = value
+		copyExpires[i] = expires
 		L := (MAX_BRANCHING_FACTOR + 1) / 2
 		iteratorNode.numKeys = L
 
 		// Create new leaf
 		newLeaf.IsLeaf = true
-		newLeaf.Values[0] = value
 		newLeaf.numKeys = MAX_BRANCHING_FACTOR + 1 - L
 
-		// Point current node to new leaf
-		iteratorNode.Children[iteratorNode.numKeys] = newLeaf.PageId
+		// Chain the new leaf in after the current one
+		newLeaf.NextPageId = iteratorNode.NextPageId
+		iteratorNode.NextPageId = newLeaf.PageId
 
-		newLeaf.Children[newLeaf.numKeys] = iteratorNode.Children[MAX_BRANCHING_FACTOR]
-		iteratorNode.Children[MAX_BRANCHING_FACTOR] = 0
+		copy(iteratorNode.Keys[:], copyKeys[:L])
+		copy(iteratorNode.Values[:], copyValues[:L])
+		copy(iteratorNode.Expires[:], copyExpires[:L])
+		copy(newLeaf.Keys[:], copyKeys[L:])
+		copy(newLeaf.Values[:], copyValues[L:])
+		copy(newLeaf.Expires[:], copyExpires[L:])
 
-		copy(iteratorNode.Keys[:iteratorNode.numKeys], copyKeys[:iteratorNode.numKeys])
+		bpTree.pager.writeNodeToPage(newLeaf)
+		bpTree.pager.writeNodeToPage(iteratorNode)
 
-		k := iteratorNode.numKeys
-		for i := 0; i < newLeaf.numKeys; i++ {
-			newLeaf.Keys[i] = copyKeys[k]
-			k++
-		}
-
-		newLeaf.writeNodeToPage()
-		iteratorNode.writeNodeToPage()
+		// Suffix truncation: the parent only needs the shortest key that tells the two leaves apart
+		separator := shortestSeparator(iteratorNode.Keys[L-1], newLeaf.Keys[0])
 
 		if iteratorNode.PageId == bpTree.RootPageId {
-			var newRoot = createNewNode(bpTree.Path)
+			var newRoot = bpTree.pager.createNewNode(bpTree.Path)
 
-			newRoot.Keys[0] = newLeaf.Keys[0]
+			newRoot.Keys[0] = separator
 			newRoot.Children[0] = iteratorNode.PageId
 			newRoot.Children[1] = newLeaf.PageId
 			newRoot.IsLeaf = false
 			newRoot.numKeys = 1
 			bpTree.RootPageId = newRoot.PageId
 
-			newRoot.writeNodeToPage()
+			bpTree.pager.writeNodeToPage(newRoot)
 		} else {
-			internalInsertion(newLeaf.Keys[0], parent.PageId, newLeaf.PageId, bpTree)
+			internalInsertion(separator, parent.PageId, newLeaf.PageId, bpTree)
 		}
 	}
+	bpTree.indexInsert(key, value, expires)
+	bpTree.record(Change{Kind: ChangePut, Key: decodeKey(key), NewValue: value, Expires: expires})
+
 	return nil
 }
 
-func createNewNode(path string) *Node {
-	var newNode Node
-	newNode.page = bufferPoolManager.NewPage(path)
-	newNode.PageId = int(newNode.page.GetId())
+// Delete removes key from the tree. Leaves that underflow are not merged with their siblings.
+func (bpTree *BpTreeImpl) Delete(key int) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	err = bpTree.delete(encodeKey(key))
+	if err != nil {
+		return err
+	}
 
-	return &newNode
+	return bpTree.syncIfDurable()
 }
 
-func internalInsertion(key int, iteratorPageId int, childPageId int, bpTree *BpTreeImpl) error {
+func (bpTree *BpTreeImpl) delete(key string) error {
+	leaf, _ := bpTree.findLeaf(key)
+
+	i, found := leaf.search(key)
+	if !found || expired(leaf.Expires[i], now().UnixNano()) {
+		return ErrNotFound
+	}
+	err := bpTree.indexesRegistered()
+	if err != nil {
+		return err
+	}
+
+	value := leaf.Values[i]
+	leaf.removeAt(i)
+	bpTree.pager.writeNodeToPage(leaf)
+	bpTree.indexDelete(key, value)
+	bpTree.record(Change{Kind: ChangeDelete, Key: decodeKey(key), Existed: true, OldValue: value})
+
+	return nil
+}
 
-	iterator := getNodeFromPageId(iteratorPageId)
+// Update replaces the value of an existing key and keeps its expiry. It returns ErrNotFound if the key does not exist.
+func (bpTree *BpTreeImpl) Update(key int, value [10]byte) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	err = bpTree.update(encodeKey(key), value)
+	if err != nil {
+		return err
+	}
+
+	return bpTree.syncIfDurable()
+}
+
+// Upsert inserts key with the given value or replaces the value if the key already exists
+func (bpTree *BpTreeImpl) Upsert(key int, value [10]byte) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	err = bpTree.update(encodeKey(key), value)
+	if err == ErrNotFound {
+		err = bpTree.reserve(1)
+		if err == nil {
+			err = bpTree.put(encodeKey(key), value)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return bpTree.syncIfDurable()
+}
+
+// CompareAndSwap replaces the value of key with newValue, but only if its current value is oldValue.
+// It reports whether the value was replaced and returns ErrNotFound if the key does not exist.
+func (bpTree *BpTreeImpl) CompareAndSwap(key int, oldValue [10]byte, newValue [10]byte) (_ bool, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	leaf, _ := bpTree.findLeaf(encodeKey(key))
+
+	i, found := leaf.search(encodeKey(key))
+	if !found || expired(leaf.Expires[i], now().UnixNano()) {
+		return false, ErrNotFound
+	}
+	if leaf.Values[i] != oldValue {
+		return false, nil
+	}
+
+	err = bpTree.update(encodeKey(key), newValue)
+	if err != nil {
+		return false, err
+	}
+
+	return true, bpTree.syncIfDurable()
+}
+
+func (bpTree *BpTreeImpl) update(key string, value [10]byte) error {
+	leaf, _ := bpTree.findLeaf(key)
+
+	i, found := leaf.search(key)
+	if !found || expired(leaf.Expires[i], now().UnixNano()) {
+		return ErrNotFound
+	}
+	err := bpTree.indexesRegistered()
+	if err != nil {
+		return err
+	}
+
+	old := leaf.Values[i]
+	leaf.Values[i] = value
+	bpTree.pager.writeNodeToPage(leaf)
+	if old != value {
+		bpTree.indexDelete(key, old)
+		bpTree.indexInsert(key, value, leaf.Expires[i])
+	}
+	bpTree.record(Change{Kind: ChangePut, Key: decodeKey(key), Existed: true, OldValue: old, NewValue: value, Expires: leaf.Expires[i]})
+
+	return nil
+}
+
+// syncIfDurable flushes all dirty pages and persists the header if the store was opened with Options.Durable.
+// Named trees are written to the catalog after every change anyway, so the store does not miss their roots.
+// It is called at the end of every operation that changes the store and commits the recorded changes last, so with
+// Options.Durable watchers never see a change that a crash loses, and KVCHANGES is synced with the pages. If writing
+// fails, the changes stay pending and are committed with those of the next operation.
+func (bpTree *BpTreeImpl) syncIfDurable() error {
+	if bpTree.store != nil {
+		err := bpTree.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	if bpTree.options.Durable {
+		err := bpTree.pager.pool.FlushAllpages()
+		if err != nil {
+			return err
+		}
+		// The log has to be synced before the header refers to its last sequence number
+		if log := bpTree.storeTree().changes; log != nil {
+			err = log.logPending(true)
+			if err != nil {
+				return err
+			}
+		}
+		err = bpTree.storeTree().writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+
+	return bpTree.commitChanges()
+}
+
+func internalInsertion(key string, iteratorPageId int, childPageId int, bpTree *BpTreeImpl) error {
+
+	iterator := bpTree.pager.getNodeFromPageId(iteratorPageId)
 
 	// Enough space to add a new key
 	if iterator.numKeys < MAX_BRANCHING_FACTOR {
@@ -413,12 +833,12 @@ func internalInsertion(key int, iteratorPageId int, childPageId int, bpTree *BpT
 		iterator.Keys[i] = key
 		iterator.numKeys++
 		iterator.Children[i+1] = childPageId
-		iterator.writeNodeToPage()
+		bpTree.pager.writeNodeToPage(iterator)
 
 	} else { // Node is full, need to split
-		var newNode = createNewNode(bpTree.Path)
+		var newNode = bpTree.pager.createNewNode(bpTree.Path)
 
-		var copyKeys [MAX_BRANCHING_FACTOR + 1]int
+		var copyKeys [MAX_BRANCHING_FACTOR + 1]string
 		var copyChildren [MAX_BRANCHING_FACTOR + 2]int
 
 		for i := 0; i < MAX_BRANCHING_FACTOR; i++ {
@@ -447,6 +867,9 @@ func internalInsertion(key int, iteratorPageId int, childPageId int, bpTree *BpT
 		newNode.IsLeaf = false
 		var L = (MAX_BRANCHING_FACTOR + 1) / 2
 		iterator.numKeys = L
+		copy(iterator.Keys[:], copyKeys[:L])
+		copy(iterator.Children[:], copyChildren[:L+1])
+		upKey := copyKeys[L] // Moves up to the parent, it is neither kept here nor in the new node
 
 		newNode.numKeys = MAX_BRANCHING_FACTOR - L
 
@@ -462,43 +885,43 @@ func internalInsertion(key int, iteratorPageId int, childPageId int, bpTree *BpT
 			k++
 		}
 
-		newNode.writeNodeToPage()
-		iterator.writeNodeToPage()
+		bpTree.pager.writeNodeToPage(newNode)
+		bpTree.pager.writeNodeToPage(iterator)
 
 		if iterator.PageId == bpTree.RootPageId {
-			var newRoot = createNewNode(bpTree.Path)
+			var newRoot = bpTree.pager.createNewNode(bpTree.Path)
 
-			newRoot.Keys[0] = iterator.Keys[iterator.numKeys]
+			newRoot.Keys[0] = upKey
 			newRoot.Children[0] = iterator.PageId
 			newRoot.Children[1] = newNode.PageId
 			newRoot.IsLeaf = false
 			newRoot.numKeys = 1
 			bpTree.RootPageId = newRoot.PageId
 
-			newRoot.writeNodeToPage()
+			bpTree.pager.writeNodeToPage(newRoot)
 		} else {
-			internalInsertion(iterator.Keys[iterator.numKeys], findParent(bpTree.RootPageId, iterator.PageId).PageId, newNode.PageId, bpTree)
+			internalInsertion(upKey, bpTree.pager.findParent(bpTree.RootPageId, iterator.PageId).PageId, newNode.PageId, bpTree)
 		}
 	}
 
 	return nil
 }
 
-func findParent(iteratorPageId int, childPageId int) *Node {
+func (p *pager) findParent(iteratorPageId int, childPageId int) *Node {
 	var parent *Node
-	iterator := getNodeFromPageId(iteratorPageId)
-	child := getNodeFromPageId(childPageId)
+	iterator := p.getNodeFromPageId(iteratorPageId)
+	child := p.getNodeFromPageId(childPageId)
 
-	if iterator.IsLeaf || getNodeFromPageId(iterator.Children[0]).IsLeaf {
+	if iterator.IsLeaf || p.getNodeFromPageId(iterator.Children[0]).IsLeaf {
 		return nil
 	}
 
 	for i := 0; i < iterator.numKeys+1; i++ {
 		if iterator.Children[i] == child.PageId {
-			parent = getNodeFromPageId(iterator.PageId)
+			parent = p.getNodeFromPageId(iterator.PageId)
 			return parent
 		} else {
-			parent = findParent(iterator.Children[i], childPageId)
+			parent = p.findParent(iterator.Children[i], childPageId)
 			if parent != nil {
 				return parent
 			}
@@ -508,24 +931,151 @@ func findParent(iteratorPageId int, childPageId int) *Node {
 }
 
 func (k *BpTreeImpl) Open(path string) (*BpTreeImpl, error) {
-	return OpenKVStore(path)
+	return k.OpenWithOptions(path, Options{})
+}
+
+// OpenWithOptions opens a store like Open and configures it with opts.
+// Encrypted stores can only be opened with the EncryptionKey they were created with.
+func (k *BpTreeImpl) OpenWithOptions(path string, opts Options) (_ *BpTreeImpl, err error) {
+	bpTree, err := OpenKVStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if bpTree.Version != FormatVersion {
+		return nil, ErrUnsupportedVersion
+	}
+	if bpTree.Encrypted != (opts.EncryptionKey != nil) {
+		return nil, ErrInvalidEncryptionKey
+	}
+
+	// The store gets its own buffer pool and disk manager, its pages are stored as it was created
+	opts.Mmap = bpTree.Mapped
+	pager, err := newPager(opts)
+	if err != nil {
+		return nil, err
+	}
+	err = pager.disk.OpenPages(bpTree.Path)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if bpTree.MaxPages == 0 {
+		bpTree.MaxPages = infrastructure.DiskMaxNumPages
+	}
+	pager.setMaxPages(bpTree.MaxPages)
+	bpTree.pager = pager
+
+	// A wrong key is noticed as soon as the first page cannot be decrypted
+	rootPage, err := pager.pool.FetchPage(infrastructure.PageID(bpTree.RootPageId), infrastructure.AccessNormal)
+	if err != nil {
+		pager.release(bpTree.Path)
+		if bpTree.Encrypted {
+			return nil, ErrInvalidEncryptionKey
+		}
+		return nil, ErrNotFound
+	}
+	pager.pool.UnpinPage(rootPage.GetId(), false)
+
+	// Changes are logged before the header is written, so the log may be ahead of it
+	var last uint64
+	bpTree.changes, last, err = openChangeLog(bpTree.Path, opts.ChangeLogSize)
+	if err != nil {
+		return nil, err
+	}
+	if last > bpTree.Sequence {
+		bpTree.Sequence = last
+	}
+
+	bpTree.options = opts
+	if opts.SweepInterval > 0 {
+		bpTree.startSweeper(opts.SweepInterval)
+	}
+	if opts.FlushInterval > 0 {
+		bpTree.pager.startFlusher(opts.FlushInterval, opts.DirtyRatio)
+	}
+	return bpTree, nil
 }
 
-// What should this do?
-func (k *BpTreeImpl) Close() error {
+// Close writes all dirty pages to disk and persists the header, e.g. with a new RootPageId, or the catalog entry of
+// a named tree. Closing a named tree does not close the store.
+// Nothing is written if the store has already been deleted. A failed store is closed without writing anything, so
+// it can be opened with what has been persisted before.
+func (k *BpTreeImpl) Close() (err error) {
+	k.stopSweeper()
+	if k.pager == nil {
+		// Only the header has been read with OpenKVStore, there are no pages to flush
+		return k.writeHeader()
+	}
+	if k.store == nil {
+		k.pager.stopFlusher() // Named trees share the flusher of the store
+	}
+	k.pager.mu.Lock()
+	defer k.pager.mu.Unlock()
+	defer k.pager.recoverPageError(&err, true)
+
+	if k.changes != nil {
+		defer k.changes.close()
+	}
+	if _, err := os.Stat(k.Path + "/KVSTORE"); err != nil {
+		return nil
+	}
+	if k.pager.failure != nil {
+		return ErrFailed
+	}
+
+	// The catalog entry of a named tree is written to a page, the header of the store must not refer to pages that
+	// have not been written
+	if k.store != nil {
+		err = k.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	err = k.pager.pool.FlushAllpages()
+	if err != nil {
+		return err
+	}
+	if k.store == nil {
+		return k.writeHeader()
+	}
 	return nil
 }
 
 func (k *BpTreeImpl) DeleteStore(path string) error {
+	k.stopSweeper()
+	if k != nil && k.pager != nil {
+		k.pager.stopFlusher()
+		k.pager.mu.Lock()
+		defer k.pager.mu.Unlock()
+		k.pager.release(path)
+	}
+
+	if k != nil && k.changes != nil {
+		k.changes.close()
+	}
+
 	return DeleteKVStore(path)
 }
 
+// CreateKVStore writes the header of the store. It replaces an existing header atomically, so the header
+// refers either to the old or to the new root if the process stops while it is written.
 func CreateKVStore(btree BpTreeImpl) error {
-	file, err := os.Create(btree.Path + "/KVSTORE")
-	defer file.Close()
+	tmpPath := btree.Path + "/KVSTORE.tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
 	encoder := gob.NewEncoder(file)
 	err = encoder.Encode(btree)
-	return err
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, btree.Path+"/KVSTORE")
 }
 
 func OpenKVStore(path string) (*BpTreeImpl, error) {
@@ -545,6 +1095,14 @@ func DeleteKVStore(path string) error {
 	if err != nil {
 		return err
 	}
+	err = os.Remove(path + "/KVSTOREDATA")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Remove(path + "/KVCHANGES")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	err = os.Remove(path + "/KVSTORE")
 	return err
 }
@@ -24,39 +24,19 @@ func setupTestDB(path string, size int) (*BpTreeImpl, error) {
 	return bpTreeImpl.Open(path)
 }
 
+// treeStats returns the statistics of a tree and fails the test if they cannot be read
+func treeStats(t *testing.T, tree interface{ Stats() (Stats, error) }) Stats {
+	stats, err := tree.Stats()
+	assert.Nil(t, err)
+	return stats
+}
+
 func closeTestDb(t *testing.T, bpTreeImpl *BpTreeImpl) {
 	assert.Nil(t, bpTreeImpl.Close())
 }
 
 func printBPTree(bpTree *BpTreeImpl, t *testing.T) {
-	rootNode := getNodeFromPageId(bpTree.RootPageId)
-	if rootNode == nil {
-		fmt.Println("Empty tree.")
-		return
-	}
-
-	printNode(rootNode, t)
-}
-
-func printNode(cursor *Node, t *testing.T) {
-
-	if cursor != nil {
-		fmt.Print("PageId:[", cursor.PageId)
-		fmt.Print("] --- ")
-		// Print keys
-		fmt.Print("[")
-		for i := 0; i < cursor.numKeys; i++ {
-			fmt.Print(cursor.Keys[i], " | ")
-		}
-		fmt.Print("]")
-		fmt.Println()
-		// Print Values for Leaf Nodes
-		if !cursor.IsLeaf {
-			for i := 0; i < cursor.numKeys+1; i++ {
-				printNode(getNodeFromPageId(cursor.Children[i]), t)
-			}
-		}
-	}
+	bpTree.PrintTree(os.Stdout)
 }
 
 func TestCreate(t *testing.T) {
@@ -89,6 +69,23 @@ func TestOpen(t *testing.T) {
 	bpTreeImpl.DeleteStore(".")
 }
 
+func TestOpen_OtherVersion_ReturnsErrUnsupportedVersion(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.Create(t.TempDir(), mem)
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	assert.Nil(t, bpTreeImpl.Close())
+	header, _ := OpenKVStore(bpTreeImpl.Path)
+	assert.Equal(t, FormatVersion, header.Version)
+
+	for _, version := range []int{0, FormatVersion + 1} {
+		header.Version = version
+		CreateKVStore(*header)
+
+		_, err := bpTreeImpl.Open(bpTreeImpl.Path)
+		assert.Equal(t, ErrUnsupportedVersion, err)
+	}
+}
+
 func TestDeleteStore(t *testing.T) {
 	const defPath = "." // create in local directory
 
@@ -205,6 +202,146 @@ func TestGet(t *testing.T) {
 	bpTreeImpl.DeleteStore(".")
 }
 
+func TestGet_AfterManySplits_ReturnsValues(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	// Insert in an interleaved order so that splits happen in the middle of nodes, not only at the right edge
+	for i := 0; i < 500; i++ {
+		key := (i * 7919) % 500
+		err := bpTreeImpl.Put(key*1000, [10]byte{byte(key), byte(key >> 8)})
+		assert.Nil(t, err)
+	}
+
+	for key := 0; key < 500; key++ {
+		value, err := bpTreeImpl.Get(key * 1000)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(key), byte(key >> 8)}, value)
+	}
+
+	_, err := bpTreeImpl.Get(1)
+	assert.EqualError(t, err, ErrNotFound.Error())
+}
+
+func TestDelete(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 1; i < 30; i++ {
+		bpTreeImpl.Put(i, [10]byte{byte(i)})
+	}
+
+	err := bpTreeImpl.Delete(17)
+
+	assert.Nil(t, err)
+	_, err = bpTreeImpl.Get(17)
+	assert.EqualError(t, err, ErrNotFound.Error())
+	value, err := bpTreeImpl.Get(18)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{18}, value)
+}
+
+func TestDelete_SameKeyTwice_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(123, [10]byte{0, 0, 0, 0, 0, 1, 1, 1, 1, 1})
+
+	assert.Nil(t, bpTreeImpl.Delete(123))
+	assert.EqualError(t, bpTreeImpl.Delete(123), ErrNotFound.Error())
+
+	// The key can be inserted again afterwards
+	assert.Nil(t, bpTreeImpl.Put(123, [10]byte{1}))
+}
+
+func TestUpdate(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 30; i++ {
+		bpTreeImpl.Put(i, [10]byte{byte(i)})
+	}
+
+	err := bpTreeImpl.Update(21, [10]byte{1, 2, 3})
+
+	assert.Nil(t, err)
+	value, _ := bpTreeImpl.Get(21)
+	assert.Equal(t, [10]byte{1, 2, 3}, value)
+}
+
+func TestUpdate_MissingKey_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	err := bpTreeImpl.Update(21, [10]byte{1, 2, 3})
+
+	assert.EqualError(t, err, ErrNotFound.Error())
+	_, err = bpTreeImpl.Get(21)
+	assert.EqualError(t, err, ErrNotFound.Error())
+}
+
+func TestUpsert(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	assert.Nil(t, bpTreeImpl.Upsert(5, [10]byte{1}))
+	assert.Nil(t, bpTreeImpl.Upsert(5, [10]byte{2}))
+
+	value, err := bpTreeImpl.Get(5)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{2}, value)
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(5, [10]byte{1})
+
+	swapped, err := bpTreeImpl.CompareAndSwap(5, [10]byte{1}, [10]byte{2})
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+
+	// The value is no longer {1}
+	swapped, err = bpTreeImpl.CompareAndSwap(5, [10]byte{1}, [10]byte{3})
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+
+	value, _ := bpTreeImpl.Get(5)
+	assert.Equal(t, [10]byte{2}, value)
+}
+
+func TestOpen_SeveralStores_SideBySide(t *testing.T) {
+	for store := 0; store < 4; store++ {
+		store := store
+		t.Run(fmt.Sprint(store), func(t *testing.T) {
+			t.Parallel()
+			path := t.TempDir()
+			bpTreeImpl, err := setupTestDB(path, mem)
+			assert.Nil(t, err)
+			for key := 0; key < 500; key++ {
+				assert.Nil(t, bpTreeImpl.Put(key, [10]byte{byte(store)}))
+			}
+			assert.Nil(t, bpTreeImpl.Close())
+
+			reopened, err := bpTreeImpl.Open(path)
+			assert.Nil(t, err)
+			defer reopened.DeleteStore(path)
+			assert.Nil(t, reopened.Verify())
+			assert.Equal(t, 500, treeStats(t, reopened).Keys)
+			value, err := reopened.Get(499)
+			assert.Nil(t, err)
+			assert.Equal(t, [10]byte{byte(store)}, value)
+		})
+	}
+}
+
+func TestCompareAndSwap_MissingKey_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	swapped, err := bpTreeImpl.CompareAndSwap(5, [10]byte{}, [10]byte{2})
+
+	assert.False(t, swapped)
+	assert.EqualError(t, err, ErrNotFound.Error())
+}
+
 func Test_NodeToPage_PageToNode(t *testing.T) {
 	var root Node
 
@@ -263,9 +400,9 @@ func Test_NodeToPageMultipleKeyValue_PageToNode(t *testing.T) {
 	val2 := [10]byte{1, 0, 0, 0, 1, 1, 1, 1, 1, 1}
 
 	root.numKeys = 2
-	root.Keys[0] = 9
+	root.Keys[0] = encodeKey(9)
 	root.Values[0] = val1
-	root.Keys[1] = 2
+	root.Keys[1] = encodeKey(2)
 	root.Values[1] = val2
 	data := root.serializeNode()
 
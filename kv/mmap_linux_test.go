@@ -0,0 +1,251 @@
+//go:build linux
+// +build linux
+
+package kv
+
+import (
+	"os"
+	"testing"
+
+	"main/infrastructure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupMappedDB creates a store with Options.Mmap and the given number of keys in a temporary directory
+func setupMappedDB(t *testing.T, keys int, opts Options) *BpTreeImpl {
+	opts.Mmap = true
+	var store BpTreeImpl
+	bpTreeImpl, err := store.CreateWithOptions(t.TempDir(), mem, opts)
+	assert.Nil(t, err)
+	for key := 0; key < keys; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+	return bpTreeImpl
+}
+
+func TestCreateWithOptions_Mmap_OneDataFile(t *testing.T) {
+	bpTreeImpl := setupMappedDB(t, 1000, Options{})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(bpTreeImpl.Path)
+
+	assert.Nil(t, err)
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 1000, treeStats(t, reopened).Keys)
+	value, err := reopened.Get(998)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{byte(998 % 256)}, value)
+	_, err = os.Stat(bpTreeImpl.Path + "/KVSTOREDATA")
+	assert.Nil(t, err)
+	_, err = os.Stat(bpTreeImpl.Path + "/KVSTOREPAGES")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateWithOptions_MmapEncryptedCompressed_RoundTrip(t *testing.T) {
+	opts := Options{Compression: true, EncryptionKey: encryptionKey}
+	bpTreeImpl := setupMappedDB(t, 300, opts)
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.OpenWithOptions(bpTreeImpl.Path, opts)
+
+	assert.Nil(t, err)
+	assert.Nil(t, reopened.Verify())
+	assert.Equal(t, 300, treeStats(t, reopened).Keys)
+}
+
+func TestPut_MmapStoreFull_ReturnsErrStoreFull(t *testing.T) {
+	bpTreeImpl := setupMappedDB(t, 0, Options{MaxPages: 20})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+
+	_, err := fillStore(bpTreeImpl)
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Nil(t, bpTreeImpl.Verify())
+	usage, _ := bpTreeImpl.Usage()
+	assert.LessOrEqual(t, usage.Pages, 20)
+}
+
+func TestCompact_Mmap_FreedPagesReused(t *testing.T) {
+	bpTreeImpl := setupMappedDB(t, 500, Options{})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	for key := 0; key < 500; key += 2 {
+		bpTreeImpl.Delete(key)
+	}
+
+	reclaimed, err := bpTreeImpl.Compact()
+	assert.Nil(t, err)
+	assert.Greater(t, reclaimed, int64(0))
+	info, _ := os.Stat(bpTreeImpl.Path + "/KVSTOREDATA")
+	_, err = bpTreeImpl.Compact()
+
+	assert.Nil(t, err)
+	assert.Nil(t, bpTreeImpl.Verify())
+	assert.Equal(t, 250, treeStats(t, bpTreeImpl).Keys)
+	compacted, _ := os.Stat(bpTreeImpl.Path + "/KVSTOREDATA")
+	assert.Equal(t, info.Size(), compacted.Size())
+}
+
+func TestCheckpoint_Mmap_CopyHasFilePerPage(t *testing.T) {
+	bpTreeImpl := setupMappedDB(t, 200, Options{})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	destPath := t.TempDir()
+
+	assert.Nil(t, bpTreeImpl.Checkpoint(destPath))
+
+	copied, err := bpTreeImpl.Open(destPath)
+	assert.Nil(t, err)
+	defer copied.DeleteStore(destPath)
+	assert.False(t, copied.Mapped)
+	assert.Nil(t, copied.Verify())
+	assert.Equal(t, 200, treeStats(t, copied).Keys)
+}
+
+func TestMmapDiskManager_Grow_EarlierPagesStayValid(t *testing.T) {
+	path := t.TempDir()
+	disk := infrastructure.NewMmapDiskManager()
+	defer disk.ReleasePages(path)
+	first, err := disk.AllocatePage(path)
+	assert.Nil(t, err)
+	assert.Nil(t, disk.WritePage(&infrastructure.Page{Id: *first, Data: []byte("first page")}))
+	page, err := disk.ReadPage(*first)
+	assert.Nil(t, err)
+
+	for i := 0; i < 200; i++ {
+		_, err := disk.AllocatePage(path)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, []byte("first page"), page.Data)
+	reread, err := disk.ReadPage(*first)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("first page"), reread.Data)
+}
+
+func TestMmapDiskManager_OpenPages_InvalidFile_Fails(t *testing.T) {
+	path := t.TempDir()
+	os.WriteFile(path+"/KVSTOREDATA", make([]byte, 4096), 0644)
+
+	err := infrastructure.NewMmapDiskManager().OpenPages(path)
+
+	assert.Equal(t, infrastructure.ErrInvalidPageFile, err)
+}
+
+func TestMmapDiskManager_WritePage_TooLarge_Fails(t *testing.T) {
+	path := t.TempDir()
+	disk := infrastructure.NewMmapDiskManager()
+	defer disk.ReleasePages(path)
+	pageID, _ := disk.AllocatePage(path)
+
+	err := disk.WritePage(&infrastructure.Page{Id: *pageID, Data: make([]byte, 2*infrastructure.PageSize)})
+
+	assert.Equal(t, infrastructure.ErrPageTooLarge, err)
+}
+
+func TestMmapDiskManager_AllocatePage_Full_ReturnsError(t *testing.T) {
+	path := t.TempDir()
+	disk := infrastructure.NewMmapDiskManager()
+	defer disk.ReleasePages(path)
+	disk.SetMaxPages(2)
+
+	_, err := disk.AllocatePage(path)
+	assert.Nil(t, err)
+	_, err = disk.AllocatePage(t.TempDir())
+	assert.Equal(t, infrastructure.ErrOtherPath, err)
+	_, err = disk.AllocatePage(path)
+	assert.Nil(t, err)
+	_, err = disk.AllocatePage(path)
+	assert.Equal(t, infrastructure.ErrDiskFull, err)
+}
+
+func TestMmapDiskManager_ReadPage_InvalidLength_Fails(t *testing.T) {
+	path := t.TempDir()
+	disk := infrastructure.NewMmapDiskManager()
+	defer disk.ReleasePages(path)
+	pageID, _ := disk.AllocatePage(path)
+	assert.Nil(t, disk.WritePage(&infrastructure.Page{Id: *pageID, Data: []byte("page")}))
+
+	// The length follows the state in the header of the slot, slots are a page and 128 bytes large
+	file, _ := os.OpenFile(path+"/KVSTOREDATA", os.O_RDWR, 0)
+	_, err := file.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, int64(int(*pageID)*(infrastructure.PageSize+128)+4))
+	file.Close()
+	assert.Nil(t, err)
+
+	_, err = disk.ReadPage(*pageID)
+	assert.Equal(t, infrastructure.ErrInvalidSlot, err)
+}
+
+func TestDeleteStore_Mmap_PagesDropped(t *testing.T) {
+	bpTreeImpl := setupMappedDB(t, 200, Options{})
+	assert.Nil(t, bpTreeImpl.Close())
+	reopened, _ := bpTreeImpl.Open(bpTreeImpl.Path)
+	_, err := reopened.Get(1)
+	assert.Nil(t, err)
+
+	assert.Nil(t, reopened.DeleteStore(reopened.Path))
+
+	// The pages read before are not used anymore, their data is no longer mapped
+	_, err = reopened.Get(1)
+	assert.NotNil(t, err)
+}
+
+// benchmarkReadPage reads 100 full pages stored by disk in turn
+func benchmarkReadPage(b *testing.B, disk pageStore) {
+	path := b.TempDir()
+	defer disk.ReleasePages(path)
+	var pageIDs []infrastructure.PageID
+	for i := 0; i < 100; i++ {
+		pageID, _ := disk.AllocatePage(path)
+		disk.WritePage(&infrastructure.Page{Id: *pageID, Data: make([]byte, infrastructure.PageSize)})
+		pageIDs = append(pageIDs, *pageID)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := disk.ReadPage(pageIDs[i%len(pageIDs)])
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPage_Files(b *testing.B) {
+	benchmarkReadPage(b, infrastructure.NewDiskManagerMock())
+}
+
+func BenchmarkReadPage_Mmap(b *testing.B) {
+	benchmarkReadPage(b, infrastructure.NewMmapDiskManager())
+}
+
+// benchmarkGet gets keys of a store with 1000 keys. The buffer pool is tiny, so most pages are read from disk.
+func benchmarkGet(b *testing.B, opts Options) {
+	var store BpTreeImpl
+	bpTreeImpl, err := store.CreateWithOptions(b.TempDir(), mem, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	for key := 0; key < 1000; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := bpTreeImpl.Get(i * 7919 % 1000)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet_Files(b *testing.B) {
+	benchmarkGet(b, Options{})
+}
+
+func BenchmarkGet_Mmap(b *testing.B) {
+	benchmarkGet(b, Options{Mmap: true})
+}
@@ -0,0 +1,155 @@
+package kv
+
+import (
+	"sync"
+
+	"main/infrastructure"
+)
+
+// pager holds the pages of a store: its buffer pool and the disk managers below it. Every store that is created or
+// opened has its own, which its named trees and indexes share, so stores at different paths work side by side.
+type pager struct {
+	// mu serializes the operations on the store, because the buffer pool is not safe for concurrent use.
+	// Every exported method holds it, unexported ones expect the caller to hold it.
+	mu sync.Mutex
+
+	pool      *infrastructure.BufferPoolManager
+	disk      pageStore
+	snapshots *infrastructure.SnapshotDiskManager // Preserves pages as they are stored while a checkpoint copies them
+	maxPages  int                                 // Pages the disk manager may allocate, see setMaxPages
+
+	flusher *flusher // Started with Options.FlushInterval
+
+	// failure is the page error that interrupted a change of the store. The buffer pool may then hold a half done
+	// change, so no page is read anymore until the store is opened again with a new pager.
+	failure error
+}
+
+// pageStore is the disk manager at the bottom of a pager, which stores the pages at the path of the store: a file per
+// page or, with Options.Mmap, one memory-mapped file
+type pageStore interface {
+	infrastructure.DiskManager
+	OpenPages(path string) error
+	ReleasePages(path string)
+	StoredPages(path string) ([]infrastructure.PageID, int64, error)
+	SetMaxPages(maxPages int)
+	AllocatedPages() int
+}
+
+// pageError is raised with panic when a page cannot be read or written, so it does not have to be returned by every
+// function that walks a tree. Exported methods turn it back into an error with recoverPageError.
+type pageError struct {
+	err error
+}
+
+// newPager returns a pager with an empty buffer pool whose disk manager stores pages as configured by opts.
+// Pages are compressed before they are encrypted, encrypted pages would not compress.
+func newPager(opts Options) (*pager, error) {
+	p := &pager{disk: infrastructure.NewDiskManagerMock()}
+	if opts.Mmap {
+		p.disk = infrastructure.NewMmapDiskManager()
+	}
+
+	var diskManager infrastructure.DiskManager = p.disk
+	if opts.wrapDisk != nil {
+		diskManager = opts.wrapDisk(diskManager)
+	}
+	p.snapshots = infrastructure.NewSnapshotDiskManager(diskManager)
+	diskManager = p.snapshots
+	if opts.EncryptionKey != nil {
+		encryptingDiskManager, err := infrastructure.NewEncryptingDiskManager(diskManager, opts.EncryptionKey)
+		if err != nil {
+			return nil, ErrInvalidEncryptionKey
+		}
+		diskManager = encryptingDiskManager
+	}
+	diskManager = infrastructure.NewCompressingDiskManager(diskManager, opts.Compression)
+
+	clockReplacer := infrastructure.NewClockReplacer(infrastructure.PoolSize)
+	p.pool = infrastructure.NewBufferPoolManager(diskManager, clockReplacer)
+
+	return p, nil
+}
+
+// release drops the pages of the buffer pool and releases the pages stored at path, whose data the dropped pages may
+// still reference
+func (p *pager) release(path string) {
+	p.pool.Clear()
+	p.disk.ReleasePages(path)
+}
+
+// recoverPageError stores a raised page error in err. If the operation changes the store, the store has failed.
+func (p *pager) recoverPageError(err *error, changes bool) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	pageErr, ok := r.(pageError)
+	if !ok {
+		panic(r)
+	}
+
+	*err = pageErr.err
+	if changes && p.failure == nil {
+		p.failure = pageErr.err
+	}
+}
+
+// getNodeFromPageId reads the node stored on the given page. The page is only pinned while it is copied.
+func (p *pager) getNodeFromPageId(pageId int) *Node {
+	return p.getNode(pageId, infrastructure.AccessNormal)
+}
+
+// getNode works like getNodeFromPageId and tells the buffer pool how the page is used, see infrastructure.AccessHint
+func (p *pager) getNode(pageId int, hint infrastructure.AccessHint) *Node {
+	page := p.fetchPage(pageId, hint)
+	node := initializeNodeFromData(page.GetData())
+	p.pool.UnpinPage(page.GetId(), false)
+	return node
+}
+
+// writeNodeToPage stores node on its page
+func (p *pager) writeNodeToPage(node *Node) {
+	data := node.serializeNode()
+	page := p.fetchPage(node.PageId, infrastructure.AccessNormal)
+	page.SetData(data)
+	p.pool.UnpinPage(page.GetId(), true)
+	p.dirtied()
+}
+
+// createNewNode allocates a page for a new node
+func (p *pager) createNewNode(path string) *Node {
+	var newNode Node
+	newNode.page = p.newPage(path)
+	newNode.PageId = int(newNode.page.GetId())
+	p.pool.UnpinPage(newNode.page.GetId(), true)
+	p.dirtied()
+
+	return &newNode
+}
+
+// fetchPage pins a page of the buffer pool. It raises a pageError if the page cannot be fetched or the store has
+// failed.
+func (p *pager) fetchPage(pageId int, hint infrastructure.AccessHint) *Page {
+	if p.failure != nil {
+		panic(pageError{ErrFailed})
+	}
+
+	page, err := p.pool.FetchPage(infrastructure.PageID(pageId), hint)
+	if err != nil {
+		panic(pageError{err})
+	}
+	return page
+}
+
+// newPage allocates a pinned page in the buffer pool. It raises a pageError if no page can be allocated.
+func (p *pager) newPage(path string) *Page {
+	page, err := p.pool.NewPage(path)
+	if err == infrastructure.ErrDiskFull {
+		err = ErrStoreFull
+	}
+	if err != nil {
+		panic(pageError{err})
+	}
+	return page
+}
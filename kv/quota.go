@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"errors"
+)
+
+// ErrStoreFull is returned when a write needs more pages than the store may still allocate, see Options.MaxPages.
+// Writes that return it have not changed the store.
+var ErrStoreFull = errors.New(Package + " - store is full")
+
+// maxHeight bounds the height of a tree: nodes never lose children and split in halves, so a tree of that height would
+// have more than (MAX_BRANCHING_FACTOR/2)^(maxHeight-2) pages
+const maxHeight = 16
+
+// Usage tells how much of its maximum size a store uses, e.g. to alert before writes fail with ErrStoreFull
+type Usage struct {
+	Pages     int   // Pages that are allocated
+	FreePages int   // Pages that can still be allocated
+	MaxPages  int   // Pages the store may use, see Options.MaxPages
+	Bytes     int64 // Size of the pages on disk, without the changes that have not been written back yet
+}
+
+// Usage returns the usage of the store. Named trees and indexes use the pages of their store.
+func (bpTree *BpTreeImpl) Usage() (Usage, error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+
+	_, size, err := bpTree.pager.storedPages(bpTree.Path)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	pages := bpTree.pager.disk.AllocatedPages()
+	return Usage{pages, bpTree.pager.freePages(), bpTree.pager.maxPages, size}, nil
+}
+
+// setMaxPages sets the number of pages the store may use
+func (p *pager) setMaxPages(maxPages int) {
+	p.maxPages = maxPages
+	p.disk.SetMaxPages(maxPages)
+}
+
+// freePages returns the number of pages that can still be allocated
+func (p *pager) freePages() int {
+	free := p.maxPages - p.disk.AllocatedPages()
+	if free < 0 {
+		return 0 // The limit is below the pages in use, e.g. after the header has been edited
+	}
+
+	return free
+}
+
+// reserve returns ErrStoreFull unless the given number of pairs can be put into the tree and its indexes. The puts
+// must not reserve pages again. The heights of the trees are only read if the store is close to full.
+// A named tree also reserves the pages by which its new root page ids may grow the catalog.
+func (bpTree *BpTreeImpl) reserve(puts int) error {
+	free := bpTree.pager.freePages()
+	catalog := bpTree.catalogGrowth(0)
+	if puts == 1 && free >= (maxHeight+1)+len(bpTree.extractors)*(2*(maxHeight+1)+1)+catalog {
+		return nil
+	}
+
+	needed := bpTree.pagesForPuts(puts) + catalog
+	for name := range bpTree.extractors {
+		needed += bpTree.index(name).pagesForIndexPuts(puts)
+	}
+	if needed > free {
+		return ErrStoreFull
+	}
+
+	return nil
+}
+
+// pagesForPuts returns the number of pages the given number of puts into the tree allocate at most
+func (bpTree *BpTreeImpl) pagesForPuts(puts int) int {
+	return bpTree.pager.pagesForPuts(puts, bpTree.height())
+}
+
+// pagesForIndexPuts returns the number of pages the given number of puts into the tree of an index allocate at most.
+// A put adds its primary key either to the index itself or to the postings of its secondary key, which it may have to
+// create first. The postings are trees of the store like any other, see heightBound.
+func (index *BpTreeImpl) pagesForIndexPuts(puts int) int {
+	return index.pagesForPuts(puts) + index.pager.pagesForPuts(puts, index.pager.heightBound()) + puts
+}
+
+// pagesForPuts returns the number of pages the given number of puts into trees of at most the given height allocate
+// at most. A single put splits at most every node on the path to its leaf and adds a new root.
+// Over many puts, every split adds an entry to the level above, and a level may get a new root. Only the nodes that
+// are full already split when a single entry is added, any other split takes MAX_BRANCHING_FACTOR/2 more entries,
+// and a level has at most a fifth of the nodes of the level below. Nodes are counted over all pages of the store, so
+// the puts may go into different trees.
+func (p *pager) pagesForPuts(puts int, height int) int {
+	if puts == 1 {
+		return height + 1
+	}
+
+	half := MAX_BRANCHING_FACTOR / 2
+	levels := height + 1
+	for n := puts; n >= half; n /= half {
+		levels++ // The root may split again
+	}
+
+	pages := 0
+	added := puts                        // Entries added to the level
+	nodes := p.disk.AllocatedPages() + 1 // Nodes of the level, at most
+	for level := 0; level < levels && added > 0; level++ {
+		splits := added/half + nodes
+		if added < nodes {
+			splits = added/half + added
+		}
+		pages += splits + 1
+		added = splits
+		nodes = nodes/half + 1
+	}
+
+	return pages
+}
+
+// pagesForIndex returns the number of pages a new index of the given number of pairs allocates at most. Nodes are at
+// least half full after a split, and there are fewer inner nodes than leaves. Every secondary key with postings has
+// at least two of the pairs, and its postings may have a leaf that is less than half full.
+func pagesForIndex(pairs int) int {
+	return 2*(pairs/(MAX_BRANCHING_FACTOR/2)+1) + pairs/2
+}
+
+// heightBound returns a height that no tree of the store exceeds, see maxHeight
+func (p *pager) heightBound() int {
+	height := 2
+	for pages := p.disk.AllocatedPages(); pages >= MAX_BRANCHING_FACTOR/2 && height < maxHeight; pages /= MAX_BRANCHING_FACTOR / 2 {
+		height++
+	}
+
+	return height
+}
+
+// height returns the number of levels of the tree, 1 if the root is a leaf
+func (bpTree *BpTreeImpl) height() int {
+	height := 1
+	node := bpTree.pager.getNodeFromPageId(bpTree.RootPageId)
+	for !node.IsLeaf {
+		node = bpTree.pager.getNodeFromPageId(node.Children[0])
+		height++
+	}
+
+	return height
+}
@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"testing"
+
+	"main/infrastructure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fillStore puts ascending keys into the store until a put fails and returns the number of keys put and the error
+func fillStore(bpTree *BpTreeImpl) (int, error) {
+	for key := 0; ; key++ {
+		err := bpTree.Put(key, [10]byte{byte(key)})
+		if err != nil {
+			return key, err
+		}
+	}
+}
+
+func TestPut_StoreFull_ReturnsErrStoreFull(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 20})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+
+	keys, err := fillStore(bpTreeImpl)
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Greater(t, keys, 50)
+	assert.Nil(t, bpTreeImpl.Verify())
+	assert.Equal(t, keys, treeStats(t, bpTreeImpl).Keys)
+	assert.Nil(t, bpTreeImpl.Update(0, [10]byte{1}))
+	value, err := bpTreeImpl.Get(0)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{1}, value)
+}
+
+func TestCreate_Size_LimitsPages(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.Create(t.TempDir(), 30*infrastructure.PageSize)
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+
+	_, err := fillStore(bpTreeImpl)
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Equal(t, 30, bpTreeImpl.MaxPages)
+	usage, _ := bpTreeImpl.Usage()
+	assert.LessOrEqual(t, usage.Pages, 30)
+}
+
+func TestOpen_MaxPages_Persisted(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 20})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	keys, _ := fillStore(bpTreeImpl)
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, err := bpTreeImpl.Open(bpTreeImpl.Path)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 20, reopened.MaxPages)
+	assert.Equal(t, ErrStoreFull, reopened.Put(keys, [10]byte{1}))
+	assert.Nil(t, reopened.Close())
+}
+
+func TestOpen_HeaderWithoutMaxPages_DiskMaxNumPages(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.Create(t.TempDir(), mem)
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	assert.Nil(t, bpTreeImpl.Close())
+	header, _ := OpenKVStore(bpTreeImpl.Path)
+	header.MaxPages = 0
+	CreateKVStore(*header)
+
+	reopened, err := bpTreeImpl.Open(bpTreeImpl.Path)
+
+	assert.Nil(t, err)
+	usage, err := reopened.Usage()
+	assert.Nil(t, err)
+	assert.Equal(t, infrastructure.DiskMaxNumPages, usage.MaxPages)
+}
+
+func TestUsage_AfterPuts_CountsPages(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 100})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+	bpTreeImpl.pager.pool.FlushAllpages()
+
+	usage, err := bpTreeImpl.Usage()
+
+	assert.Nil(t, err)
+	assert.Equal(t, treeStats(t, bpTreeImpl).Pages, usage.Pages)
+	assert.Equal(t, 100, usage.MaxPages)
+	assert.Equal(t, 100-usage.Pages, usage.FreePages)
+	assert.Greater(t, usage.Bytes, int64(0))
+}
+
+func TestWrite_StoreFull_NothingApplied(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 20})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	batch := NewWriteBatch()
+	for key := 0; key < 1000; key++ {
+		batch.Put(key, [10]byte{1})
+	}
+
+	err := bpTreeImpl.Write(batch)
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Equal(t, 0, treeStats(t, bpTreeImpl).Keys)
+	assert.Nil(t, bpTreeImpl.Put(1, [10]byte{1}))
+}
+
+func TestPut_StoreFullWithIndex_IndexComplete(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 40})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	assert.Nil(t, bpTreeImpl.RegisterIndex("first", func(value [10]byte) int { return int(value[0]) }))
+
+	keys, err := fillStore(bpTreeImpl)
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Nil(t, bpTreeImpl.Verify())
+	indexed := 0
+	bpTreeImpl.ScanIndex("first", 0, 255, func(secondaryKey int, primaryKey int) bool {
+		indexed++
+		return true
+	})
+	assert.Equal(t, keys, indexed)
+}
+
+func TestRegisterIndex_StoreFull_ReturnsErrStoreFull(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 40})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	fillStore(bpTreeImpl)
+
+	err := bpTreeImpl.RegisterIndex("first", func(value [10]byte) int { return int(value[0]) })
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Empty(t, bpTreeImpl.Indexes)
+	assert.Nil(t, bpTreeImpl.Verify())
+}
+
+func TestCompact_StoreFull_ReturnsErrStoreFull(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(t.TempDir(), mem, Options{MaxPages: 20})
+	defer bpTreeImpl.DeleteStore(bpTreeImpl.Path)
+	keys, _ := fillStore(bpTreeImpl)
+
+	_, err := bpTreeImpl.Compact()
+
+	assert.Equal(t, ErrStoreFull, err)
+	assert.Nil(t, bpTreeImpl.Verify())
+	assert.Equal(t, keys, treeStats(t, bpTreeImpl).Keys)
+}
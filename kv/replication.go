@@ -0,0 +1,627 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Leader ships the committed changes of one tree to followers over a connection, a Follower applies them to a tree
+// of its own store and serves reads from it. The stream is resumed from the last sequence number the follower has
+// applied, which it keeps in the header of its store as LeaderSequence. If the leader does not retain the changes
+// after it anymore, it sends a snapshot of all pairs first.
+// The follower opens the stream with replicationMagic, a version byte and its sequence number as uint64. The leader
+// then sends messages that start with their kind: a snapshot with its sequence number, the number of pairs and the
+// pairs with key, value and expiry, a change as a record of the change log, or a heartbeat with the last sequence
+// number of the leader once all changes up to it have been sent. The follower acknowledges what it has applied with
+// its sequence number as uint64. All integers are big-endian.
+
+const (
+	replicationMagic   = "KVREPL"
+	replicationVersion = 1
+
+	msgSnapshot  = 'S'
+	msgChange    = 'C'
+	msgHeartbeat = 'H'
+
+	snapshotPairSize = 8 + 10 + 8
+)
+
+// DefaultHeartbeatInterval is the interval of heartbeats if Leader.HeartbeatInterval is 0
+const DefaultHeartbeatInterval = time.Second
+
+// ErrInvalidReplicationStream is returned if a connection does not carry a replication stream of a supported version
+var ErrInvalidReplicationStream = errors.New(Package + " - replication stream is not valid")
+
+// Leader streams the changes of a tree to its followers
+type Leader struct {
+	HeartbeatInterval time.Duration // Interval at which followers learn the last sequence number, see FollowerStatus
+
+	tree      *BpTreeImpl
+	mu        sync.Mutex
+	followers map[net.Conn]*FollowerStatus
+	listeners map[net.Listener]bool
+	done      chan struct{}
+}
+
+// FollowerStatus describes a follower connected to a leader
+type FollowerStatus struct {
+	Addr         string // Remote address of the connection
+	Acknowledged uint64 // Sequence number up to which the follower has applied the changes
+	Lag          uint64 // Number of changes of the store the follower has not acknowledged yet
+}
+
+// Follower applies the changes streamed by a leader to a tree. The tree must not be changed otherwise.
+type Follower struct {
+	tree   *BpTreeImpl
+	mu     sync.Mutex
+	conn   net.Conn
+	status ReplicationStatus
+	closed bool
+}
+
+// ReplicationStatus describes how far a follower is behind its leader
+type ReplicationStatus struct {
+	Applied     uint64    // Sequence number of the leader up to which changes have been applied
+	Leader      uint64    // Last sequence number of the leader the follower knows of
+	Lag         uint64    // Leader - Applied
+	LastContact time.Time // When the follower last received a message from the leader
+	Snapshots   int       // Number of snapshots the follower had to apply
+}
+
+// snapshotPair is a pair sent with a snapshot
+type snapshotPair struct {
+	key     int
+	value   [10]byte
+	expires int64
+}
+
+// NewLeader returns a leader for the changes of tree
+func NewLeader(tree *BpTreeImpl) *Leader {
+	return &Leader{
+		tree:      tree,
+		followers: make(map[net.Conn]*FollowerStatus),
+		listeners: make(map[net.Listener]bool),
+		done:      make(chan struct{}),
+	}
+}
+
+// Serve accepts followers on listener and serves each of them with ServeConn until the leader is closed
+func (l *Leader) Serve(listener net.Listener) error {
+	l.mu.Lock()
+	select {
+	case <-l.done:
+		l.mu.Unlock()
+		return listener.Close()
+	default:
+	}
+	l.listeners[listener] = true
+	l.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go l.ServeConn(conn)
+	}
+}
+
+// ServeConn streams changes to the follower on conn until the connection fails, the store or the leader is closed
+func (l *Leader) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	sequence, err := readHello(conn)
+	if err != nil {
+		return err
+	}
+
+	status := &FollowerStatus{Addr: conn.RemoteAddr().String(), Acknowledged: sequence}
+	l.mu.Lock()
+	select {
+	case <-l.done:
+		l.mu.Unlock()
+		return nil
+	default:
+	}
+	l.followers[conn] = status
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.followers, conn)
+		l.mu.Unlock()
+	}()
+	go l.readAcknowledgements(conn, status)
+
+	w := bufio.NewWriter(conn)
+	for {
+		s, err := l.subscribe(w, sequence)
+		if err != nil {
+			return l.stopped(err)
+		}
+
+		var resubscribe bool
+		sequence, resubscribe, err = l.stream(w, s, sequence)
+		s.Close()
+		if err != nil || !resubscribe {
+			return l.stopped(err)
+		}
+	}
+}
+
+// Followers returns the status of the connected followers, ordered by address
+func (l *Leader) Followers() []FollowerStatus {
+	last := l.tree.LastSequence()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	followers := make([]FollowerStatus, 0, len(l.followers))
+	for _, status := range l.followers {
+		follower := *status
+		if follower.Acknowledged < last {
+			follower.Lag = last - follower.Acknowledged
+		}
+		followers = append(followers, follower)
+	}
+	sort.Slice(followers, func(i, j int) bool { return followers[i].Addr < followers[j].Addr })
+
+	return followers
+}
+
+// Close stops serving, closes the listeners and disconnects the followers
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	default:
+	}
+
+	close(l.done)
+	for listener := range l.listeners {
+		listener.Close()
+	}
+	for conn := range l.followers {
+		conn.Close()
+	}
+
+	return nil
+}
+
+// stopped returns nil instead of err if the leader has been closed
+func (l *Leader) stopped(err error) error {
+	select {
+	case <-l.done:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (l *Leader) heartbeatInterval() time.Duration {
+	if l.HeartbeatInterval <= 0 {
+		return DefaultHeartbeatInterval
+	}
+
+	return l.HeartbeatInterval
+}
+
+// subscribe watches the changes after sequence. If they are not retained anymore, or the follower is ahead of the
+// leader, it sends a snapshot and watches the changes after it.
+func (l *Leader) subscribe(w *bufio.Writer, sequence uint64) (*Subscription, error) {
+	s, snapshot, last, pairs, err := l.watchOrSnapshot(sequence)
+	if err != nil || !snapshot {
+		return s, err
+	}
+
+	err = writeSnapshot(w, last, pairs)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// watchOrSnapshot watches the changes after sequence if they are retained and the follower is not ahead. Otherwise it
+// takes a snapshot of the pairs and watches the changes after its sequence number.
+func (l *Leader) watchOrSnapshot(sequence uint64) (s *Subscription, snapshot bool, last uint64, pairs []snapshotPair, err error) {
+	l.tree.pager.mu.Lock()
+	defer l.tree.pager.mu.Unlock()
+	defer l.tree.pager.recoverPageError(&err, false)
+
+	last = l.tree.storeTree().committedSequence()
+	if sequence <= last {
+		s, err = l.tree.watch(math.MinInt64, math.MaxInt64, sequence)
+		if err != ErrChangesTruncated {
+			return s, false, last, nil, err
+		}
+	}
+
+	l.tree.scanEntries(math.MinInt64, math.MaxInt64, func(key int, value [10]byte, expires int64) bool {
+		pairs = append(pairs, snapshotPair{key: key, value: value, expires: expires})
+		return true
+	})
+	s, err = l.tree.watch(math.MinInt64, math.MaxInt64, last)
+	return s, true, last, pairs, err
+}
+
+// stream sends the changes delivered to s and heartbeats. It returns the sequence number up to which everything has
+// been sent and whether the follower has to subscribe again, because s fell behind.
+func (l *Leader) stream(w *bufio.Writer, s *Subscription, sequence uint64) (uint64, bool, error) {
+	ticker := time.NewTicker(l.heartbeatInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case change, ok := <-s.C:
+			if !ok {
+				return sequence, s.Err() == ErrWatcherTooSlow, nil
+			}
+			w.WriteByte(msgChange)
+			w.Write(encodeChange(change))
+			sequence = change.Sequence
+			if len(s.C) > 0 {
+				continue // Sent with the next change
+			}
+
+		case <-ticker.C:
+			// Changes up to last have been delivered to s before LastSequence returned. Only if they have all been
+			// sent, the follower may skip to last.
+			last := l.tree.LastSequence()
+			if len(s.C) > 0 {
+				continue
+			}
+			var message [1 + 8]byte
+			message[0] = msgHeartbeat
+			binary.BigEndian.PutUint64(message[1:], last)
+			w.Write(message[:])
+			if last > sequence {
+				sequence = last
+			}
+
+		case <-l.done:
+			return sequence, false, nil
+		}
+
+		err := w.Flush()
+		if err != nil {
+			return sequence, false, err
+		}
+	}
+}
+
+// readAcknowledgements updates status with the sequence numbers the follower acknowledges until conn is closed
+func (l *Leader) readAcknowledgements(conn net.Conn, status *FollowerStatus) {
+	var ack [8]byte
+	for {
+		_, err := io.ReadFull(conn, ack[:])
+		if err != nil {
+			return
+		}
+
+		l.mu.Lock()
+		status.Acknowledged = binary.BigEndian.Uint64(ack[:])
+		l.mu.Unlock()
+	}
+}
+
+// NewFollower returns a follower that applies the changes of a leader to tree
+func NewFollower(tree *BpTreeImpl) *Follower {
+	return &Follower{tree: tree}
+}
+
+// Run connects the follower to the leader on conn and applies the streamed changes until the connection fails or
+// the follower is closed. It resumes after the changes that have already been applied, also by a previous Run.
+func (f *Follower) Run(conn net.Conn) error {
+	f.tree.pager.mu.Lock()
+	applied := f.tree.storeTree().LeaderSequence
+	f.tree.pager.mu.Unlock()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return conn.Close()
+	}
+	f.conn = conn
+	f.status.Applied = applied
+	f.mu.Unlock()
+	defer conn.Close()
+
+	var hello [len(replicationMagic) + 1 + 8]byte
+	copy(hello[:], replicationMagic)
+	hello[len(replicationMagic)] = replicationVersion
+	binary.BigEndian.PutUint64(hello[len(replicationMagic)+1:], applied)
+	_, err := conn.Write(hello[:])
+	if err != nil {
+		return f.stopped(err)
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return f.stopped(err)
+		}
+
+		switch kind {
+		case msgSnapshot:
+			err = f.applySnapshot(r)
+		case msgChange:
+			err = f.applyChange(r)
+		case msgHeartbeat:
+			err = f.applyHeartbeat(r)
+		default:
+			err = ErrInvalidReplicationStream
+		}
+		if err != nil {
+			return f.stopped(err)
+		}
+
+		// Changes that arrived together are acknowledged together
+		if r.Buffered() == 0 {
+			f.mu.Lock()
+			var ack [8]byte
+			binary.BigEndian.PutUint64(ack[:], f.status.Applied)
+			f.mu.Unlock()
+			_, err = conn.Write(ack[:])
+			if err != nil {
+				return f.stopped(err)
+			}
+		}
+	}
+}
+
+// Get returns the value of key from the tree of the follower
+func (f *Follower) Get(key int) (_ [10]byte, err error) {
+	f.tree.pager.mu.Lock()
+	defer f.tree.pager.mu.Unlock()
+	defer f.tree.pager.recoverPageError(&err, false)
+
+	return f.tree.get(encodeKey(key))
+}
+
+// Scan calls fn for every key in [start, end] of the tree of the follower like BpTreeImpl.Scan
+func (f *Follower) Scan(start int, end int, fn func(key int, value [10]byte) bool) (err error) {
+	f.tree.pager.mu.Lock()
+	defer f.tree.pager.mu.Unlock()
+	defer f.tree.pager.recoverPageError(&err, false)
+
+	return f.tree.scan(start, end, fn)
+}
+
+// Status returns how far the follower is behind the leader
+func (f *Follower) Status() ReplicationStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := f.status
+	if status.Leader > status.Applied {
+		status.Lag = status.Leader - status.Applied
+	}
+	return status
+}
+
+// Close disconnects the follower from the leader. The tree stays open and keeps what has been applied.
+func (f *Follower) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.conn != nil {
+		return f.conn.Close()
+	}
+	return nil
+}
+
+// stopped returns nil instead of err if the follower has been closed or the leader has ended the stream
+func (f *Follower) stopped(err error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed || err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// applySnapshot replaces the pairs of the tree with the pairs of a snapshot
+func (f *Follower) applySnapshot(r io.Reader) error {
+	var header [8 + 8]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return err
+	}
+	sequence := binary.BigEndian.Uint64(header[0:])
+	count := binary.BigEndian.Uint64(header[8:])
+
+	keep := make(map[int]bool)
+	var pairs []snapshotPair
+	for i := uint64(0); i < count; i++ {
+		var record [snapshotPairSize]byte
+		_, err := io.ReadFull(r, record[:])
+		if err != nil {
+			return err
+		}
+		pair := snapshotPair{key: int(binary.BigEndian.Uint64(record[0:])), expires: int64(binary.BigEndian.Uint64(record[18:]))}
+		copy(pair.value[:], record[8:18])
+		pairs = append(pairs, pair)
+		keep[pair.key] = true
+	}
+
+	f.tree.pager.mu.Lock()
+	err = func() (err error) {
+		defer f.tree.pager.recoverPageError(&err, true)
+		var stale []int
+		f.tree.scan(math.MinInt64, math.MaxInt64, func(key int, value [10]byte) bool {
+			if !keep[key] {
+				stale = append(stale, key)
+			}
+			return true
+		})
+		for _, key := range stale {
+			err := f.tree.delete(encodeKey(key))
+			if err != nil {
+				return err
+			}
+		}
+		for _, pair := range pairs {
+			err := f.tree.replace(encodeKey(pair.key), pair.value, pair.expires)
+			if err != nil {
+				return err
+			}
+		}
+
+		f.tree.storeTree().LeaderSequence = sequence
+		return f.tree.syncIfDurable()
+	}()
+	f.tree.pager.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.status.Applied = sequence
+	f.status.Leader = sequence
+	f.status.LastContact = now()
+	f.status.Snapshots++
+	f.mu.Unlock()
+	return nil
+}
+
+// applyChange applies a change to the tree. Changes that have already been applied are applied again without harm.
+func (f *Follower) applyChange(r io.Reader) error {
+	change, err := readChange(r)
+	if err != nil {
+		return err
+	}
+
+	f.tree.pager.mu.Lock()
+	err = func() (err error) {
+		defer f.tree.pager.recoverPageError(&err, true)
+		if change.Kind == ChangeDelete {
+			err = f.tree.delete(encodeKey(change.Key))
+			if err == ErrNotFound {
+				err = nil
+			}
+		} else {
+			err = f.tree.replace(encodeKey(change.Key), change.NewValue, change.Expires)
+		}
+		if err != nil {
+			return err
+		}
+
+		f.tree.storeTree().LeaderSequence = change.Sequence
+		return f.tree.syncIfDurable()
+	}()
+	f.tree.pager.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.status.Applied = change.Sequence
+	if change.Sequence > f.status.Leader {
+		f.status.Leader = change.Sequence
+	}
+	f.status.LastContact = now()
+	f.mu.Unlock()
+	return nil
+}
+
+// applyHeartbeat skips to the last sequence number of the leader, all changes of the tree up to it have been applied
+func (f *Follower) applyHeartbeat(r io.Reader) error {
+	var message [8]byte
+	_, err := io.ReadFull(r, message[:])
+	if err != nil {
+		return err
+	}
+	sequence := binary.BigEndian.Uint64(message[:])
+
+	f.tree.pager.mu.Lock()
+	if sequence > f.tree.storeTree().LeaderSequence {
+		f.tree.storeTree().LeaderSequence = sequence
+	}
+	f.tree.pager.mu.Unlock()
+
+	f.mu.Lock()
+	if sequence > f.status.Applied {
+		f.status.Applied = sequence
+	}
+	f.status.Leader = sequence
+	f.status.LastContact = now()
+	f.mu.Unlock()
+	return nil
+}
+
+// replace sets the value and the expiry of an encoded key, whether it exists or not
+func (bpTree *BpTreeImpl) replace(key string, value [10]byte, expires int64) error {
+	// The pair must not be deleted below if it cannot be put again
+	err := bpTree.reserve(1)
+	if err != nil {
+		return err
+	}
+
+	leaf, _ := bpTree.findLeaf(key)
+	i, found := leaf.search(key)
+	if !found || expired(leaf.Expires[i], now().UnixNano()) {
+		return bpTree.putWithExpiry(key, value, expires)
+	}
+
+	if leaf.Expires[i] != expires {
+		err := bpTree.delete(key)
+		if err != nil {
+			return err
+		}
+		return bpTree.putWithExpiry(key, value, expires)
+	}
+	if leaf.Values[i] == value {
+		return nil
+	}
+	return bpTree.update(key, value)
+}
+
+// readHello reads the start of a replication stream and returns the sequence number of the follower
+func readHello(r io.Reader) (uint64, error) {
+	var hello [len(replicationMagic) + 1 + 8]byte
+	_, err := io.ReadFull(r, hello[:])
+	if err != nil {
+		return 0, err
+	}
+	if string(hello[:len(replicationMagic)]) != replicationMagic || hello[len(replicationMagic)] != replicationVersion {
+		return 0, ErrInvalidReplicationStream
+	}
+
+	return binary.BigEndian.Uint64(hello[len(replicationMagic)+1:]), nil
+}
+
+func writeSnapshot(w *bufio.Writer, sequence uint64, pairs []snapshotPair) error {
+	var header [1 + 8 + 8]byte
+	header[0] = msgSnapshot
+	binary.BigEndian.PutUint64(header[1:], sequence)
+	binary.BigEndian.PutUint64(header[9:], uint64(len(pairs)))
+	w.Write(header[:])
+
+	for _, pair := range pairs {
+		var record [snapshotPairSize]byte
+		binary.BigEndian.PutUint64(record[0:], uint64(pair.key))
+		copy(record[8:], pair.value[:])
+		binary.BigEndian.PutUint64(record[18:], uint64(pair.expires))
+		w.Write(record[:])
+	}
+
+	return w.Flush()
+}
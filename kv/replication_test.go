@@ -0,0 +1,194 @@
+package kv
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupReplicaDB creates a second store next to the one of setupTestDB
+func setupReplicaDB(t *testing.T, opts Options) *BpTreeImpl {
+	var store BpTreeImpl
+	replica, err := store.CreateWithOptions(t.TempDir(), mem, opts)
+	assert.Nil(t, err)
+	return replica
+}
+
+// replicate connects a follower of replica to a leader of tree over a pipe
+func replicate(t *testing.T, tree *BpTreeImpl, replica *BpTreeImpl) (*Leader, *Follower) {
+	leader := NewLeader(tree)
+	leader.HeartbeatInterval = time.Millisecond
+	follower := NewFollower(replica)
+	leaderConn, followerConn := net.Pipe()
+	done := make(chan struct{}, 2)
+	go func() {
+		leader.ServeConn(leaderConn)
+		done <- struct{}{}
+	}()
+	go func() {
+		follower.Run(followerConn)
+		done <- struct{}{}
+	}()
+
+	// Both goroutines have to end with the test, they read the clock that other tests move
+	t.Cleanup(func() {
+		follower.Close()
+		leader.Close()
+		leaderConn.Close()
+		followerConn.Close()
+		<-done
+		<-done
+	})
+	return leader, follower
+}
+
+// caughtUp waits until the follower has applied all changes of tree
+func caughtUp(t *testing.T, tree *BpTreeImpl, follower *Follower) {
+	assert.Eventually(t, func() bool {
+		status := follower.Status()
+		return status.Applied == tree.LastSequence() && status.Lag == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestFollower_Changes_Applied(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	replica := setupReplicaDB(t, Options{})
+	defer replica.DeleteStore(replica.Path)
+	for key := 0; key < 50; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+	_, follower := replicate(t, bpTreeImpl, replica)
+
+	for key := 0; key < 50; key += 2 {
+		bpTreeImpl.Delete(key)
+		bpTreeImpl.Update(key+1, [10]byte{2})
+	}
+	bpTreeImpl.PutWithTTL(100, [10]byte{3}, time.Minute)
+	caughtUp(t, bpTreeImpl, follower)
+
+	count := 0
+	follower.Scan(0, 99, func(key int, value [10]byte) bool {
+		assert.Equal(t, 1, key%2)
+		assert.Equal(t, [10]byte{2}, value)
+		count++
+		return true
+	})
+	assert.Equal(t, 25, count)
+	value, err := follower.Get(100)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{3}, value)
+	assert.Equal(t, 0, follower.Status().Snapshots)
+
+	advance(t, time.Hour)
+	_, err = follower.Get(100)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestFollower_TooFarBehind_Snapshot(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, Options{ChangeLogSize: 10})
+	defer bpTreeImpl.DeleteStore(".")
+	replica := setupReplicaDB(t, Options{})
+	defer replica.DeleteStore(replica.Path)
+	replica.Put(1000, [10]byte{1})
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.Put(key, [10]byte{byte(key)})
+	}
+
+	_, follower := replicate(t, bpTreeImpl, replica)
+	bpTreeImpl.Put(100, [10]byte{100})
+	caughtUp(t, bpTreeImpl, follower)
+
+	assert.Equal(t, 1, follower.Status().Snapshots)
+	assert.Equal(t, 101, treeStats(t, replica).Keys)
+	_, err := follower.Get(1000)
+	assert.Equal(t, ErrNotFound, err)
+	value, _ := follower.Get(42)
+	assert.Equal(t, [10]byte{42}, value)
+	assert.Nil(t, replica.Verify())
+}
+
+func TestFollower_Reconnected_ResumesFromSequence(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	replica := setupReplicaDB(t, Options{})
+	defer replica.DeleteStore(replica.Path)
+	bpTreeImpl.Put(1, [10]byte{1})
+	leader, follower := replicate(t, bpTreeImpl, replica)
+	caughtUp(t, bpTreeImpl, follower)
+	follower.Close()
+	leader.Close()
+	assert.Nil(t, replica.Close())
+
+	for key := 2; key < 20; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+	reopened, _ := replica.Open(replica.Path)
+	_, follower = replicate(t, bpTreeImpl, reopened)
+	caughtUp(t, bpTreeImpl, follower)
+
+	assert.Equal(t, 0, follower.Status().Snapshots)
+	assert.Equal(t, 19, treeStats(t, reopened).Keys)
+}
+
+func TestFollower_NamedTrees_OnlyReplicatedTree(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	replica := setupReplicaDB(t, Options{})
+	defer replica.DeleteStore(replica.Path)
+	users, _ := bpTreeImpl.CreateTree("users")
+	copied, _ := replica.CreateTree("users")
+	_, follower := replicate(t, users, copied)
+
+	bpTreeImpl.Put(1, [10]byte{1})
+	users.Put(2, [10]byte{2})
+	caughtUp(t, users, follower)
+
+	assert.Equal(t, 1, treeStats(t, copied).Keys)
+	assert.Equal(t, 0, treeStats(t, replica).Keys)
+	assert.Equal(t, uint64(2), replica.LeaderSequence)
+}
+
+func TestLeader_Serve_ReportsFollowers(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	replica := setupReplicaDB(t, Options{})
+	defer replica.DeleteStore(replica.Path)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	leader := NewLeader(bpTreeImpl)
+	leader.HeartbeatInterval = time.Millisecond
+	served := make(chan error)
+	go func() { served <- leader.Serve(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	follower := NewFollower(replica)
+	go follower.Run(conn)
+	for key := 0; key < 10; key++ {
+		bpTreeImpl.Put(key, [10]byte{1})
+	}
+	caughtUp(t, bpTreeImpl, follower)
+
+	assert.Eventually(t, func() bool {
+		followers := leader.Followers()
+		return len(followers) == 1 && followers[0].Acknowledged == 10 && followers[0].Lag == 0
+	}, time.Second, time.Millisecond)
+	assert.Nil(t, follower.Close())
+	assert.Nil(t, leader.Close())
+	assert.Nil(t, <-served)
+}
+
+func TestLeader_ServeConn_InvalidStream_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	leaderConn, followerConn := net.Pipe()
+	go followerConn.Write([]byte("KVDUMP\x01\x00\x00\x00\x00\x00\x00\x00\x00"))
+
+	err := NewLeader(bpTreeImpl).ServeConn(leaderConn)
+
+	assert.Equal(t, ErrInvalidReplicationStream, err)
+}
@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"errors"
+	"time"
+
+	"main/infrastructure"
+)
+
+// Pairs put with PutWithTTL store their expiry next to them in the leaf. Expired pairs are hidden right away, Get,
+// Scan and the other operations treat them as if they had been deleted. They are only removed from the pages by
+// Sweep, the sweeper started with Options.SweepInterval, or Compact.
+
+// ErrInvalidTTL is returned when the supplied time-to-live is not positive
+var ErrInvalidTTL = errors.New(Package + " - ttl is not valid")
+
+// now returns the current time. Tests replace it to let pairs expire.
+var now = time.Now
+
+// PutWithTTL inserts key with the given value like Put. The pair expires once ttl has passed.
+// Update keeps the expiry, an expired key can be put again.
+func (bpTree *BpTreeImpl) PutWithTTL(key int, value [10]byte, ttl time.Duration) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	err = bpTree.reserve(1)
+	if err != nil {
+		return err
+	}
+	err = bpTree.putWithExpiry(encodeKey(key), value, now().Add(ttl).UnixNano())
+	if err != nil {
+		return err
+	}
+
+	return bpTree.syncIfDurable()
+}
+
+// Sweep removes the expired pairs from the pages of all trees of the store and returns how many it removed, not
+// counting the entries of secondary indexes. Leaves are not merged, Compact reclaims the space of emptied ones.
+func (bpTree *BpTreeImpl) Sweep() (_ int, err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	store := bpTree.storeTree()
+	catalog, err := store.readCatalog()
+	if err != nil {
+		return 0, err
+	}
+
+	// Entries of an index expire with their pairs, so every index can be swept on its own
+	trees := []*BpTreeImpl{store}
+	entries := []catalogEntry{{RootPageId: store.RootPageId, Indexes: store.Indexes}}
+	for name, entry := range catalog {
+		trees = append(trees, &BpTreeImpl{name: name, store: store, pager: store.pager})
+		entries = append(entries, entry)
+	}
+	removed := 0
+	t := now().UnixNano()
+	for i, entry := range entries {
+		tree := trees[i]
+		removed += bpTree.pager.sweepTree(entry.RootPageId, t, func(key string, value [10]byte) {
+			tree.record(Change{Kind: ChangeDelete, Key: decodeKey(key), Existed: true, OldValue: value})
+		})
+		for _, rootPageId := range entry.Indexes {
+			bpTree.pager.sweepIndex(rootPageId, t)
+		}
+	}
+
+	return removed, store.syncIfDurable()
+}
+
+// sweepTree removes the pairs that have expired at t from the leaves of the tree with the given root and calls
+// removed for each of them
+func (p *pager) sweepTree(rootPageId int, t int64, removed func(key string, value [10]byte)) int {
+	leaf, _ := BpTreeImpl{RootPageId: rootPageId, pager: p}.findLeaf("")
+
+	count := 0
+	for {
+		numKeys := leaf.numKeys
+		for i := 0; i < leaf.numKeys; {
+			if expired(leaf.Expires[i], t) {
+				removed(leaf.Keys[i], leaf.Values[i])
+				leaf.removeAt(i)
+			} else {
+				i++
+			}
+		}
+		if leaf.numKeys < numKeys {
+			count += numKeys - leaf.numKeys
+			p.writeNodeToPage(leaf)
+		}
+
+		if leaf.NextPageId == 0 {
+			return count
+		}
+		leaf = p.getNode(leaf.NextPageId, infrastructure.AccessSequential)
+	}
+}
+
+// expired reports whether a pair with the given expiry has expired at t
+func expired(expires int64, t int64) bool {
+	return expires != 0 && expires <= t
+}
+
+// hasExpiries reports whether a pair of the leaf expires
+func (node *Node) hasExpiries() bool {
+	for i := 0; i < node.numKeys; i++ {
+		if node.Expires[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sweeper calls Sweep in the background
+type sweeper struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startSweeper sweeps the store every interval until stopSweeper is called
+func (bpTree *BpTreeImpl) startSweeper(interval time.Duration) {
+	s := &sweeper{stop: make(chan struct{}), done: make(chan struct{})}
+	bpTree.sweeper = s
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				bpTree.Sweep()
+			}
+		}
+	}()
+}
+
+// stopSweeper stops the sweeper and waits until a running sweep has finished. It must not be called with pager.mu
+// held.
+func (bpTree *BpTreeImpl) stopSweeper() {
+	if bpTree == nil || bpTree.sweeper == nil {
+		return
+	}
+
+	close(bpTree.sweeper.stop)
+	<-bpTree.sweeper.done
+	bpTree.sweeper = nil
+}
@@ -0,0 +1,184 @@
+package kv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// advance moves the clock of the store forward by d until the test ends
+func advance(t *testing.T, d time.Duration) {
+	previous := now
+	now = func() time.Time { return previous().Add(d) }
+	t.Cleanup(func() { now = previous })
+}
+
+func TestPutWithTTL_Expired_Hidden(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 50; key++ {
+		assert.Nil(t, bpTreeImpl.PutWithTTL(key, [10]byte{1}, time.Minute))
+		assert.Nil(t, bpTreeImpl.Put(key+50, [10]byte{2}))
+	}
+
+	value, err := bpTreeImpl.Get(10)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{1}, value)
+
+	advance(t, time.Hour)
+
+	_, err = bpTreeImpl.Get(10)
+	assert.Equal(t, ErrNotFound, err)
+	count := 0
+	bpTreeImpl.Scan(0, 1000, func(key int, value [10]byte) bool {
+		assert.True(t, key >= 50)
+		count++
+		return true
+	})
+	assert.Equal(t, 50, count)
+	assert.Equal(t, ErrNotFound, bpTreeImpl.Delete(10))
+	assert.Equal(t, ErrNotFound, bpTreeImpl.Update(10, [10]byte{3}))
+}
+
+func TestPutWithTTL_ExpiredKey_PutAgain(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	assert.Nil(t, bpTreeImpl.PutWithTTL(1, [10]byte{1}, time.Minute))
+	assert.Equal(t, ErrSameKeyTwice, bpTreeImpl.Put(1, [10]byte{2}))
+
+	advance(t, time.Hour)
+
+	assert.Nil(t, bpTreeImpl.Put(1, [10]byte{2}))
+	value, err := bpTreeImpl.Get(1)
+	assert.Nil(t, err)
+	assert.Equal(t, [10]byte{2}, value)
+
+	var batch WriteBatch
+	bpTreeImpl.PutWithTTL(2, [10]byte{1}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	batch.Put(2, [10]byte{3})
+	assert.Nil(t, bpTreeImpl.Write(&batch))
+	assert.Equal(t, 2, treeStats(t, bpTreeImpl).Keys)
+}
+
+func TestPutWithTTL_InvalidTTL_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	assert.Equal(t, ErrInvalidTTL, bpTreeImpl.PutWithTTL(1, [10]byte{1}, 0))
+}
+
+func TestPutWithTTL_Reopened_KeepsExpiry(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.PutWithTTL(key, [10]byte{1}, time.Duration(key+1)*time.Minute)
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+
+	reopened, _ := bpTreeImpl.Open(".")
+	assert.Nil(t, reopened.Verify())
+	advance(t, 50*time.Minute+time.Second)
+
+	count := 0
+	reopened.Scan(0, 1000, func(key int, value [10]byte) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 50, count)
+}
+
+func TestSweep_ExpiredPairs_Removed(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	sessions, _ := bpTreeImpl.CreateTree("sessions")
+	assert.Nil(t, sessions.RegisterIndex("first", byFirstByte))
+	for key := 0; key < 100; key++ {
+		bpTreeImpl.PutWithTTL(key, [10]byte{1}, time.Minute)
+		sessions.PutWithTTL(key, [10]byte{1}, time.Minute)
+		sessions.Put(key+100, [10]byte{1})
+	}
+	advance(t, time.Hour)
+
+	removed, err := bpTreeImpl.Sweep()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, removed)
+	assert.Equal(t, 0, treeStats(t, bpTreeImpl).Keys)
+	assert.Equal(t, 100, treeStats(t, sessions).Keys)
+	assert.Nil(t, sessions.Verify())
+	primaryKeys, _ := sessions.LookupBy("first", 1)
+	assert.Equal(t, 100, len(primaryKeys))
+	value, _, _ := sessions.index("first").entry(encodeKey(1))
+	_, postingsRoot := splitIndexValue(value)
+	assert.Equal(t, 100, treeStats(t, sessions.postings(postingsRoot)).Keys)
+}
+
+func TestSweeper_Background_RemovesExpiredPairs(t *testing.T) {
+	var store BpTreeImpl
+	bpTreeImpl, _ := store.CreateWithOptions(".", mem, Options{SweepInterval: time.Millisecond})
+	defer bpTreeImpl.DeleteStore(".")
+	s, _ := bpTreeImpl.Watch(0, 100)
+	for key := 0; key < 30; key++ {
+		bpTreeImpl.PutWithTTL(key, [10]byte{1}, time.Millisecond)
+	}
+
+	// The sweeper reports every pair it removes, it may already run between the puts
+	for deleted := 0; deleted < 30; {
+		select {
+		case change := <-s.C:
+			if change.Kind == ChangeDelete {
+				deleted++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("pairs not swept")
+		}
+	}
+	assert.Nil(t, bpTreeImpl.Close())
+	assert.Equal(t, 0, treeStats(t, bpTreeImpl).Keys)
+}
+
+func TestCompact_ExpiredPairs_Dropped(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for key := 0; key < 200; key++ {
+		if key%2 == 0 {
+			bpTreeImpl.PutWithTTL(key, [10]byte{1}, time.Minute)
+		} else {
+			bpTreeImpl.PutWithTTL(key, [10]byte{1}, 2*time.Hour)
+		}
+	}
+	advance(t, time.Hour)
+
+	_, err := bpTreeImpl.Compact()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 100, treeStats(t, bpTreeImpl).Keys)
+	advance(t, 2*time.Hour)
+	_, err = bpTreeImpl.Get(1)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestDump_Expiry_Restored(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	bpTreeImpl.PutWithTTL(1, [10]byte{1}, time.Minute)
+	bpTreeImpl.PutWithTTL(2, [10]byte{2}, time.Hour)
+	bpTreeImpl.Put(3, [10]byte{3})
+	var dump bytes.Buffer
+	assert.Nil(t, bpTreeImpl.Dump(&dump))
+	bpTreeImpl.DeleteStore(".")
+
+	restored, _ := setupTestDB(".", mem)
+	defer restored.DeleteStore(".")
+	assert.Nil(t, restored.Restore(&dump))
+
+	advance(t, 30*time.Minute)
+	_, err := restored.Get(1)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = restored.Get(2)
+	assert.Nil(t, err)
+	_, err = restored.Get(3)
+	assert.Nil(t, err)
+}
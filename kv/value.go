@@ -0,0 +1,39 @@
+package kv
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// ParseValue reads a value given as text or as 0x-prefixed hex
+func ParseValue(text string) ([10]byte, error) {
+	var value [10]byte
+
+	data := []byte(text)
+	if strings.HasPrefix(text, "0x") {
+		decoded, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return value, ErrBadValue
+		}
+		data = decoded
+	}
+	if len(data) > len(value) {
+		return value, ErrBadValue
+	}
+
+	copy(value[:], data)
+	return value, nil
+}
+
+// FormatValue is the inverse of ParseValue. Values are shown as text without their trailing zero bytes
+// if they are printable, otherwise as hex.
+func FormatValue(value [10]byte) string {
+	text := strings.TrimRight(string(value[:]), "\x00")
+	for _, r := range text {
+		if r < ' ' || r > '~' {
+			return "0x" + hex.EncodeToString(value[:])
+		}
+	}
+
+	return text
+}
@@ -0,0 +1,15 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseValue_FormatValue(t *testing.T) {
+	for _, text := range []string{"hello", "0x00ff000000000000007f", ""} {
+		value, err := ParseValue(text)
+		assert.Nil(t, err)
+		assert.Equal(t, text, FormatValue(value))
+	}
+}
@@ -0,0 +1,200 @@
+package kv
+
+import (
+	"sort"
+)
+
+// batchOp is a single Put or Delete recorded in a WriteBatch
+type batchOp struct {
+	key      string // Encoded key, see encodeKey
+	value    [10]byte
+	isDelete bool
+	isUpsert bool // A Put that replaces the value of an existing key
+}
+
+// WriteBatch collects Put and Delete operations which are then applied to a BpTreeImpl in a single atomic step.
+// Operations on the same key are applied in the order they were added to the batch.
+type WriteBatch struct {
+	ops []batchOp
+}
+
+// NewWriteBatch returns an empty batch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put records the insertion of key with the given value
+func (batch *WriteBatch) Put(key int, value [10]byte) {
+	batch.ops = append(batch.ops, batchOp{key: encodeKey(key), value: value})
+}
+
+// Upsert records the insertion of key with the given value, or the replacement of its value if the key exists by
+// then. Like Upsert of the tree, a replaced value keeps its expiry.
+func (batch *WriteBatch) Upsert(key int, value [10]byte) {
+	batch.ops = append(batch.ops, batchOp{key: encodeKey(key), value: value, isUpsert: true})
+}
+
+// Delete records the removal of key
+func (batch *WriteBatch) Delete(key int) {
+	batch.ops = append(batch.ops, batchOp{key: encodeKey(key), isDelete: true})
+}
+
+// Len returns the number of recorded operations
+func (batch *WriteBatch) Len() int {
+	return len(batch.ops)
+}
+
+// Reset removes all recorded operations so that the batch can be reused
+func (batch *WriteBatch) Reset() {
+	batch.ops = batch.ops[:0]
+}
+
+// sortedOps returns the operations sorted by key. The sort is stable so operations on the same key keep their order.
+func (batch *WriteBatch) sortedOps() []batchOp {
+	ops := make([]batchOp, len(batch.ops))
+	copy(ops, batch.ops)
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].key < ops[j].key
+	})
+
+	return ops
+}
+
+// Write applies all operations of the batch. If one of the operations would fail, e.g. a Put of an existing key
+// (ErrSameKeyTwice) or a Delete of a missing key (ErrNotFound), none of them is applied. Upserts do not fail.
+// With Options.Durable, dirty pages are flushed and the header is written once for the whole batch.
+func (bpTree *BpTreeImpl) Write(batch *WriteBatch) (err error) {
+	bpTree.pager.mu.Lock()
+	defer bpTree.pager.mu.Unlock()
+	defer bpTree.pager.recoverPageError(&err, true)
+
+	ops := batch.sortedOps()
+
+	err = bpTree.validateBatch(ops)
+	if err != nil {
+		return err
+	}
+
+	bpTree.applyBatch(ops)
+
+	return bpTree.syncIfDurable()
+}
+
+// validateBatch checks every operation against the tree and the operations before it, without modifying the tree
+func (bpTree *BpTreeImpl) validateBatch(ops []batchOp) error {
+	err := bpTree.indexesRegistered()
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool)
+	puts := 0
+
+	for _, op := range ops {
+		present, ok := exists[op.key]
+		if !ok {
+			_, err := bpTree.get(op.key)
+			present = err == nil
+		}
+
+		if op.isDelete && !present {
+			return ErrNotFound
+		}
+		if !op.isDelete && !op.isUpsert && present {
+			return ErrSameKeyTwice
+		}
+		if !op.isDelete {
+			puts++
+		}
+
+		exists[op.key] = !op.isDelete
+	}
+
+	if puts == 0 {
+		return nil
+	}
+	return bpTree.reserve(puts)
+}
+
+// applyBatch applies validated operations in key order. Consecutive keys that fall into the same leaf are applied
+// to one in-memory copy of it, which is written back once, instead of descending from the root for every key.
+// With secondary indexes, every operation is applied on its own, so that the indexes are updated with it.
+func (bpTree *BpTreeImpl) applyBatch(ops []batchOp) {
+	if len(bpTree.Indexes) > 0 {
+		for _, op := range ops {
+			if op.isDelete {
+				bpTree.delete(op.key)
+			} else if !op.isUpsert || bpTree.update(op.key, op.value) == ErrNotFound {
+				bpTree.put(op.key, op.value)
+			}
+		}
+		return
+	}
+
+	t := now().UnixNano()
+
+	var leaf *Node
+	var upper string // Smallest key that no longer belongs to leaf
+	var bounded bool // false if leaf is the rightmost leaf
+	var dirty bool
+
+	release := func() {
+		if leaf != nil && dirty {
+			bpTree.pager.writeNodeToPage(leaf)
+		}
+		leaf = nil
+		dirty = false
+	}
+
+	for _, op := range ops {
+		if leaf == nil || (bounded && op.key >= upper) {
+			release()
+			leaf, upper, bounded = bpTree.findLeafWithBound(op.key)
+		}
+
+		i, found := leaf.search(op.key)
+		if op.isDelete {
+			if found {
+				bpTree.record(Change{Kind: ChangeDelete, Key: decodeKey(op.key), Existed: true, OldValue: leaf.Values[i]})
+				leaf.removeAt(i)
+				dirty = true
+			}
+		} else if op.isUpsert && found && !expired(leaf.Expires[i], t) {
+			old := leaf.Values[i]
+			leaf.Values[i] = op.value
+			bpTree.record(Change{Kind: ChangePut, Key: decodeKey(op.key), Existed: true, OldValue: old, NewValue: op.value, Expires: leaf.Expires[i]})
+			dirty = true
+		} else if !found && leaf.numKeys < MAX_BRANCHING_FACTOR {
+			leaf.insertAt(i, op.key, op.value, 0)
+			bpTree.record(Change{Kind: ChangePut, Key: decodeKey(op.key), NewValue: op.value})
+			dirty = true
+		} else {
+			// The leaf has to be split, which may change the tree above it, or holds an expired pair with the key
+			release()
+			bpTree.put(op.key, op.value)
+		}
+	}
+
+	release()
+}
+
+// findLeafWithBound works like findLeaf but also returns the separator key to the right of the leaf.
+// bounded is false if there is no such separator, i.e. if the leaf is the rightmost one.
+func (bpTree *BpTreeImpl) findLeafWithBound(key string) (leaf *Node, upper string, bounded bool) {
+	iteratorNode := bpTree.pager.getNodeFromPageId(bpTree.RootPageId)
+
+	for !iteratorNode.IsLeaf {
+		i := 0
+		for i < iteratorNode.numKeys && key >= iteratorNode.Keys[i] {
+			i++
+		}
+
+		if i < iteratorNode.numKeys {
+			upper = iteratorNode.Keys[i]
+			bounded = true
+		}
+		iteratorNode = bpTree.pager.getNodeFromPageId(iteratorNode.Children[i])
+	}
+
+	return iteratorNode, upper, bounded
+}
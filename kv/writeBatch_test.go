@@ -0,0 +1,140 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite_UnsortedBatch_AllKeysPresent(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	batch := NewWriteBatch()
+	for i := 0; i < 2000; i++ {
+		key := (i * 7919) % 2000
+		batch.Put(key, [10]byte{byte(key), byte(key >> 8)})
+	}
+
+	err := bpTreeImpl.Write(batch)
+
+	assert.Nil(t, err)
+	for key := 0; key < 2000; key++ {
+		value, err := bpTreeImpl.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, [10]byte{byte(key), byte(key >> 8)}, value)
+	}
+}
+
+func TestWrite_PutAndDelete(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	for i := 0; i < 20; i++ {
+		bpTreeImpl.Put(i, [10]byte{byte(i)})
+	}
+
+	batch := NewWriteBatch()
+	batch.Delete(5)
+	batch.Put(100, [10]byte{100})
+	batch.Delete(12)
+	batch.Put(12, [10]byte{42}) // Operations on the same key keep their order
+
+	err := bpTreeImpl.Write(batch)
+
+	assert.Nil(t, err)
+	_, err = bpTreeImpl.Get(5)
+	assert.EqualError(t, err, ErrNotFound.Error())
+	value, _ := bpTreeImpl.Get(12)
+	assert.Equal(t, [10]byte{42}, value)
+	value, _ = bpTreeImpl.Get(100)
+	assert.Equal(t, [10]byte{100}, value)
+}
+
+func TestWrite_DeleteMissingKey_NothingApplied(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+	bpTreeImpl.Put(1, [10]byte{1})
+
+	batch := NewWriteBatch()
+	batch.Put(2, [10]byte{2})
+	batch.Delete(1)
+	batch.Delete(3)
+
+	err := bpTreeImpl.Write(batch)
+
+	assert.EqualError(t, err, ErrNotFound.Error())
+	_, err = bpTreeImpl.Get(1)
+	assert.Nil(t, err)
+	_, err = bpTreeImpl.Get(2)
+	assert.EqualError(t, err, ErrNotFound.Error())
+}
+
+func TestWrite_Upsert_ReplacesOrInserts(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		bpTreeImpl, _ := setupTestDB(".", mem)
+		if indexed {
+			assert.Nil(t, bpTreeImpl.RegisterIndex("first", byFirstByte))
+		}
+		for i := 0; i < 20; i++ {
+			bpTreeImpl.Put(i, [10]byte{1})
+		}
+		s, _ := bpTreeImpl.Watch(0, 100)
+
+		batch := NewWriteBatch()
+		batch.Upsert(5, [10]byte{2})
+		batch.Upsert(50, [10]byte{2})
+		batch.Delete(6)
+		batch.Upsert(6, [10]byte{2})
+
+		assert.Nil(t, bpTreeImpl.Write(batch))
+		for _, key := range []int{5, 6, 50} {
+			value, err := bpTreeImpl.Get(key)
+			assert.Nil(t, err)
+			assert.Equal(t, [10]byte{2}, value)
+		}
+		assert.Equal(t, 21, treeStats(t, bpTreeImpl).Keys)
+		changes := receive(s)
+		assert.Equal(t, 4, len(changes))
+		assert.True(t, changes[0].Existed)
+		assert.Equal(t, [10]byte{1}, changes[0].OldValue)
+		if indexed {
+			replaced, _ := bpTreeImpl.LookupBy("first", 2)
+			assert.Equal(t, []int{5, 6, 50}, replaced)
+		}
+		bpTreeImpl.DeleteStore(".")
+	}
+}
+
+func TestWrite_SameKeyTwice_Fails(t *testing.T) {
+	bpTreeImpl, _ := setupTestDB(".", mem)
+	defer bpTreeImpl.DeleteStore(".")
+
+	batch := NewWriteBatch()
+	batch.Put(7, [10]byte{1})
+	batch.Put(7, [10]byte{2})
+
+	err := bpTreeImpl.Write(batch)
+
+	assert.EqualError(t, err, ErrSameKeyTwice.Error())
+	_, err = bpTreeImpl.Get(7)
+	assert.EqualError(t, err, ErrNotFound.Error())
+}
+
+func TestWrite_Durable_HeaderUpdated(t *testing.T) {
+	var bpTreeImpl BpTreeImpl
+	tree, err := bpTreeImpl.CreateWithOptions(".", mem, Options{Durable: true})
+	assert.Nil(t, err)
+	defer tree.DeleteStore(".")
+	rootPageId := tree.RootPageId
+
+	batch := NewWriteBatch()
+	for i := 0; i < 100; i++ {
+		batch.Put(i, [10]byte{byte(i)})
+	}
+	assert.Nil(t, tree.Write(batch))
+
+	header, err := OpenKVStore(".")
+	assert.Nil(t, err)
+	assert.Equal(t, tree.RootPageId, header.RootPageId)
+	assert.NotEqual(t, rootPageId, header.RootPageId) // The root was split
+}
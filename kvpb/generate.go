@@ -0,0 +1,7 @@
+// Package kvpb contains the protobuf messages and the gRPC client and server interfaces of the KV service,
+// generated from kv.proto with protoc-gen-go v1.28.1 and protoc-gen-go-grpc v1.2.0, which go generate installs first
+package kvpb
+
+//go:generate go install google.golang.org/protobuf/cmd/protoc-gen-go@v1.28.1
+//go:generate go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@v1.2.0
+//go:generate protoc --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. kv.proto
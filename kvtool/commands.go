@@ -0,0 +1,263 @@
+package kvtool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"main/kv"
+)
+
+// command is a subcommand of kvtool, run against the store at path
+type command struct {
+	minArgs, maxArgs int // Number of arguments after the path
+	run              func(cfg *config, opts kv.Options, path string, args []string, out output) error
+}
+
+// storeOp is an operation on an open store. It is shared by the commands and the shell.
+type storeOp func(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error
+
+var commands = map[string]command{
+	"create":  {0, 0, runCreate},
+	"open":    {0, 0, withOpenStore(printHeader)},
+	"get":     {1, 1, withOpenStore(getKey)},
+	"put":     {2, 2, withOpenStore(putKey)},
+	"delete":  {1, 1, withOpenStore(deleteKey)},
+	"scan":    {0, 2, withOpenStore(scanKeys)},
+	"stats":   {0, 0, withOpenStore(printStats)},
+	"usage":   {0, 0, withOpenStore(printUsage)},
+	"verify":  {0, 0, withOpenStore(verifyTree)},
+	"dump":    {0, 0, withOpenStore(dumpNodes)},
+	"compact": {0, 0, withOpenStore(compactTree)},
+	"destroy": {0, 0, runDestroy},
+	"shell":   {0, 0, runShell},
+	"serve":   {0, 0, runServe},
+}
+
+// output prints results either readable or as JSON, one document per line
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+func (out output) print(document interface{}, text string) error {
+	if out.json {
+		return json.NewEncoder(out.w).Encode(document)
+	}
+
+	_, err := fmt.Fprintln(out.w, text)
+	return err
+}
+
+type pair struct {
+	Key   int    `json:"key"`
+	Value string `json:"value"`
+}
+
+type status struct {
+	Status string `json:"status"`
+}
+
+// withStore opens the store at path, runs fn and closes the store again, which persists all changes
+func withStore(opts kv.Options, path string, fn func(tree *kv.BpTreeImpl) error) error {
+	var store kv.BpTreeImpl
+	tree, err := store.OpenWithOptions(path, opts)
+	if err != nil {
+		return err
+	}
+
+	err = fn(tree)
+	closeErr := tree.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// withOpenStore turns op into a command that opens the store for the duration of op
+func withOpenStore(op storeOp) func(cfg *config, opts kv.Options, path string, args []string, out output) error {
+	return func(cfg *config, opts kv.Options, path string, args []string, out output) error {
+		return withStore(opts, path, func(tree *kv.BpTreeImpl) error {
+			return op(cfg, tree, args, out)
+		})
+	}
+}
+
+func runCreate(cfg *config, opts kv.Options, path string, args []string, out output) error {
+	var store kv.BpTreeImpl
+	tree, err := store.CreateWithOptions(path, cfg.size, opts)
+	if err != nil {
+		return err
+	}
+	err = tree.Close()
+	if err != nil {
+		return err
+	}
+
+	return out.print(status{"created"}, "created "+path)
+}
+
+func runDestroy(cfg *config, opts kv.Options, path string, args []string, out output) error {
+	var store kv.BpTreeImpl
+	err := store.DeleteStore(path)
+	if err != nil {
+		return err
+	}
+
+	return out.print(status{"destroyed"}, "destroyed "+path)
+}
+
+func printHeader(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	text := fmt.Sprintf("path: %s\nroot page: %d\nsize: %d\nencrypted: %t", tree.Path, tree.RootPageId, tree.MaxMem, tree.Encrypted)
+	return out.print(tree, text)
+}
+
+func getKey(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	key, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	value, err := tree.Get(key)
+	if err != nil {
+		return err
+	}
+	return out.print(pair{key, kv.FormatValue(value)}, kv.FormatValue(value))
+}
+
+func putKey(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	key, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := kv.ParseValue(args[1])
+	if err != nil {
+		return err
+	}
+
+	err = tree.Put(key, value)
+	if err != nil {
+		return err
+	}
+	return out.print(status{"ok"}, "ok")
+}
+
+func deleteKey(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	key, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = tree.Delete(key)
+	if err != nil {
+		return err
+	}
+	return out.print(status{"ok"}, "ok")
+}
+
+func scanKeys(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	start, end := math.MinInt64, math.MaxInt64
+	var err error
+	if len(args) > 0 {
+		start, err = strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 1 {
+		end, err = strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	count := 0
+	var printErr error
+	err = tree.Scan(start, end, func(key int, value [10]byte) bool {
+		printErr = out.print(pair{key, kv.FormatValue(value)}, fmt.Sprintf("%d\t%s", key, kv.FormatValue(value)))
+		count++
+		return printErr == nil && (cfg.limit <= 0 || count < cfg.limit)
+	})
+	if err != nil {
+		return err
+	}
+	return printErr
+}
+
+func printStats(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	stats, err := tree.Stats()
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("height: %d\nkeys: %d\nleaves: %d\ninner nodes: %d\npages: %d\nfill factor: %.2f",
+		stats.Height, stats.Keys, stats.Leaves, stats.InnerNodes, stats.Pages, stats.FillFactor)
+	return out.print(stats, text)
+}
+
+func printUsage(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	usage, err := tree.Usage()
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("pages: %d\nfree pages: %d\nmax pages: %d\nbytes: %d",
+		usage.Pages, usage.FreePages, usage.MaxPages, usage.Bytes)
+	return out.print(usage, text)
+}
+
+func verifyTree(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	err := tree.Verify()
+	if err != nil {
+		return err
+	}
+	return out.print(status{"ok"}, "ok")
+}
+
+type compaction struct {
+	Saved int64 `json:"saved"`
+}
+
+func compactTree(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	saved, err := tree.Compact()
+	if err != nil {
+		return err
+	}
+	return out.print(compaction{saved}, fmt.Sprintf("saved %d bytes", saved))
+}
+
+func dumpNodes(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	var printErr error
+	err := tree.Walk(func(node kv.NodeInfo) bool {
+		printErr = out.print(node, formatNode(node))
+		return printErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return printErr
+}
+
+func printTree(cfg *config, tree *kv.BpTreeImpl, args []string, out output) error {
+	if out.json {
+		return dumpNodes(cfg, tree, args, out)
+	}
+
+	return tree.PrintTree(out.w)
+}
+
+// formatNode prints a node on one line, indented by its level
+func formatNode(node kv.NodeInfo) string {
+	var text strings.Builder
+	text.WriteString(strings.Repeat("  ", node.Level))
+	if node.IsLeaf {
+		fmt.Fprintf(&text, "leaf  page %d keys %v next %d", node.PageId, node.Keys, node.NextPageId)
+	} else {
+		fmt.Fprintf(&text, "inner page %d keys %v children %v", node.PageId, node.Keys, node.Children)
+	}
+
+	return text.String()
+}
@@ -0,0 +1,127 @@
+// Package kvtool implements the kvtool command-line tool for managing key-value stores
+package kvtool
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"main/kv"
+)
+
+const usage = `kvtool manages key-value stores.
+
+Usage:
+  kvtool [flags] <command> <path> [arguments]
+
+Commands:
+  create  <path>                create a new store
+  open    <path>                open a store and print its header
+  get     <path> <key>          print the value of a key
+  put     <path> <key> <value>  insert a key, values are text or 0x-prefixed hex of up to 10 bytes
+  delete  <path> <key>          delete a key
+  scan    <path> [start] [end]  print all keys in [start, end] in ascending order
+  stats   <path>                print statistics about the tree
+  usage   <path>                print the pages used and free
+  verify  <path>                check the structure of the tree
+  dump    <path>                print every node of the tree
+  compact <path>                rewrite the tree into densely packed pages
+  destroy <path>                delete the store
+  shell   <path>                open the store in an interactive shell
+  serve   <path>                serve the store over HTTP, RESP or gRPC until interrupted
+
+The hex encoded encryption key of a store is read from the file given with -key-file, or else from the
+environment variable ` + keyEnv + `, so that it does not show up in the list of processes.
+
+Flags:
+`
+
+// keyEnv is the environment variable the encryption key is read from without -key-file
+const keyEnv = "KVTOOL_KEY"
+
+// config holds the flags and the input shared by all commands
+type config struct {
+	stdin    io.Reader
+	json     bool
+	limit    int
+	size     int
+	maxPages int
+	compress bool
+	mmap     bool
+	keyFile  string
+	addr     string
+	protocol string
+}
+
+// Run parses args and executes the command, writing its output to stdout. Only the shell reads from stdin.
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	cfg := config{stdin: stdin}
+	flags := flag.NewFlagSet("kvtool", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&cfg.json, "json", false, "print the output as JSON")
+	flags.IntVar(&cfg.limit, "limit", 0, "print at most this many keys with scan, 0 for all")
+	flags.IntVar(&cfg.size, "size", 0, "size of a new store in bytes, 0 for the default")
+	flags.IntVar(&cfg.maxPages, "max-pages", 0, "pages a new store may use, 0 to derive them from -size")
+	flags.BoolVar(&cfg.compress, "compress", false, "compress pages that are written")
+	flags.BoolVar(&cfg.mmap, "mmap", false, "store the pages of a new store in one memory-mapped file")
+	flags.StringVar(&cfg.keyFile, "key-file", "", "file holding the hex encoded encryption key of the store")
+	flags.StringVar(&cfg.addr, "addr", "localhost:8080", "address to listen on with serve")
+	flags.StringVar(&cfg.protocol, "protocol", "http", "protocol of serve, http, resp (Redis) or grpc")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return errors.New("missing command or path")
+	}
+
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", flags.Arg(0))
+	}
+	if flags.NArg()-2 < command.minArgs || flags.NArg()-2 > command.maxArgs {
+		return fmt.Errorf("wrong number of arguments for %s", flags.Arg(0))
+	}
+
+	opts, err := cfg.options()
+	if err != nil {
+		return err
+	}
+
+	out := output{stdout, cfg.json}
+	return command.run(&cfg, opts, flags.Arg(1), flags.Args()[2:], out)
+}
+
+// options returns the store options selected by the flags and the encryption key
+func (cfg *config) options() (kv.Options, error) {
+	opts := kv.Options{Compression: cfg.compress, Mmap: cfg.mmap, MaxPages: cfg.maxPages}
+	text := os.Getenv(keyEnv)
+	if cfg.keyFile != "" {
+		data, err := os.ReadFile(cfg.keyFile)
+		if err != nil {
+			return opts, err
+		}
+		text = string(data)
+	}
+	text = strings.TrimSpace(text)
+	if text != "" {
+		key, err := hex.DecodeString(text)
+		if err != nil {
+			return opts, kv.ErrInvalidEncryptionKey
+		}
+		opts.EncryptionKey = key
+	}
+
+	return opts, nil
+}
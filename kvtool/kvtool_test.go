@@ -0,0 +1,107 @@
+package kvtool
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runCommand(t *testing.T, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := Run(args, strings.NewReader(""), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRun_Commands(t *testing.T) {
+	path := t.TempDir()
+
+	_, err := runCommand(t, "create", path)
+	assert.Nil(t, err)
+	for _, key := range []string{"5", "1", "-3", "12"} {
+		_, err = runCommand(t, "put", path, key, "value"+key)
+		assert.Nil(t, err)
+	}
+
+	output, err := runCommand(t, "get", path, "12")
+	assert.Nil(t, err)
+	assert.Equal(t, "value12\n", output)
+
+	output, err = runCommand(t, "-json", "get", path, "-3")
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"key\":-3,\"value\":\"value-3\"}\n", output)
+
+	output, err = runCommand(t, "scan", path, "0", "10")
+	assert.Nil(t, err)
+	assert.Equal(t, "1\tvalue1\n5\tvalue5\n", output)
+
+	output, err = runCommand(t, "-json", "-limit", "1", "scan", path)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"key\":-3,\"value\":\"value-3\"}\n", output)
+
+	_, err = runCommand(t, "delete", path, "5")
+	assert.Nil(t, err)
+	_, err = runCommand(t, "get", path, "5")
+	assert.NotNil(t, err)
+
+	output, err = runCommand(t, "-json", "stats", path)
+	assert.Nil(t, err)
+	assert.Contains(t, output, "\"Keys\":3")
+
+	output, err = runCommand(t, "usage", path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(output, "pages: "))
+
+	output, err = runCommand(t, "verify", path)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok\n", output)
+
+	output, err = runCommand(t, "compact", path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(output, "saved "))
+
+	output, err = runCommand(t, "dump", path)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(output, "leaf  page"))
+
+	_, err = runCommand(t, "destroy", path)
+	assert.Nil(t, err)
+	_, err = runCommand(t, "open", path)
+	assert.NotNil(t, err)
+}
+
+func TestRun_InvalidArguments_Fails(t *testing.T) {
+	_, err := runCommand(t, "frobnicate", ".")
+	assert.NotNil(t, err)
+
+	_, err = runCommand(t, "get", ".")
+	assert.NotNil(t, err)
+
+	_, err = runCommand(t, "put", ".", "1", "a value that is too long")
+	assert.NotNil(t, err)
+}
+
+func TestRun_EncryptionKey_FromFileOrEnvironment(t *testing.T) {
+	path := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	assert.Nil(t, os.WriteFile(keyFile, []byte("00112233445566778899aabbccddeeff\n"), 0600))
+
+	_, err := runCommand(t, "-key-file", keyFile, "create", path)
+	assert.Nil(t, err)
+	_, err = runCommand(t, "-key-file", keyFile, "put", path, "1", "secret")
+	assert.Nil(t, err)
+
+	_, err = runCommand(t, "get", path, "1")
+	assert.NotNil(t, err)
+
+	t.Setenv(keyEnv, "00112233445566778899aabbccddeeff")
+	output, err := runCommand(t, "get", path, "1")
+	assert.Nil(t, err)
+	assert.Contains(t, output, "secret")
+
+	_, err = runCommand(t, "-key-file", filepath.Join(t.TempDir(), "missing"), "get", path, "1")
+	assert.NotNil(t, err)
+}
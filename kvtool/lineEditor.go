@@ -0,0 +1,156 @@
+package kvtool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// lineEditor reads lines with history and tab completion from a terminal.
+// If the input is not a terminal, e.g. a pipe, lines are read as they are and no prompt is shown.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int // File descriptor of the terminal, -1 if the input is not a terminal
+	history  []string
+	complete func(line string) []string // Returns the possible completions of line
+}
+
+func newLineEditor(in io.Reader, out io.Writer, complete func(line string) []string) *lineEditor {
+	fd := -1
+	if file, ok := in.(*os.File); ok && isTerminal(int(file.Fd())) {
+		fd = int(file.Fd())
+	}
+
+	return &lineEditor{bufio.NewReader(in), out, fd, nil, complete}
+}
+
+// readLine returns the next line without its line break. It returns io.EOF at the end of the input.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.fd >= 0 {
+		restore, err := makeRaw(e.fd)
+		if err == nil {
+			defer restore()
+			return e.edit(prompt)
+		}
+	}
+
+	line, err := e.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil // The last line has no line break
+	}
+	if err != nil {
+		return "", err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	e.addHistory(line)
+	return line, nil
+}
+
+// edit reads the keys of one line from a terminal in raw mode and echoes them.
+// Up and down walk through the history, tab completes, Ctrl-C discards the line and Ctrl-D on an empty line ends the input.
+func (e *lineEditor) edit(prompt string) (string, error) {
+	line := []rune{}
+	historyIndex := len(e.history)
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(line))
+	}
+	redraw()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			e.addHistory(string(line))
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			line = line[:0]
+			historyIndex = len(e.history)
+			redraw()
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case 8, 127: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+		case 21: // Ctrl-U
+			line = line[:0]
+			redraw()
+		case '\t':
+			line = e.completeLine(line)
+			redraw()
+		case 27: // Escape sequence, only the up and down arrows are supported
+			if next, _, _ := e.in.ReadRune(); next != '[' {
+				continue
+			}
+			arrow, _, _ := e.in.ReadRune()
+			if arrow == 'A' && historyIndex > 0 {
+				historyIndex--
+			} else if arrow == 'B' && historyIndex < len(e.history) {
+				historyIndex++
+			} else {
+				continue
+			}
+
+			line = line[:0]
+			if historyIndex < len(e.history) {
+				line = []rune(e.history[historyIndex])
+			}
+			redraw()
+		default:
+			if r >= ' ' {
+				line = append(line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// completeLine extends line as far as all completions agree. If they differ, they are listed below the line.
+func (e *lineEditor) completeLine(line []rune) []rune {
+	candidates := e.complete(string(line))
+	if len(candidates) == 0 {
+		return line
+	}
+
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(candidates) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+
+	if len(prefix) >= len(string(line)) {
+		return []rune(prefix)
+	}
+	return line
+}
+
+// addHistory remembers line, unless it is empty or repeats the previous line
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+}
@@ -0,0 +1,60 @@
+package kvtool
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"main/kv"
+	"main/server"
+)
+
+// storeServer is implemented by the servers of the server package
+type storeServer interface {
+	ListenAndServe(addr string) error
+	Close() error
+}
+
+// runServe serves the store with the protocol selected by -protocol until the process is interrupted,
+// then closes it, which persists all changes
+func runServe(cfg *config, opts kv.Options, path string, args []string, out output) error {
+	if cfg.protocol != "http" && cfg.protocol != "resp" && cfg.protocol != "grpc" {
+		return fmt.Errorf("unknown protocol %q", cfg.protocol)
+	}
+
+	var store kv.BpTreeImpl
+	tree, err := store.OpenWithOptions(path, opts)
+	if err != nil {
+		return err
+	}
+
+	var storeServer storeServer = server.NewHTTPServer(tree)
+	switch cfg.protocol {
+	case "resp":
+		storeServer = server.NewRESPServer(tree)
+	case "grpc":
+		storeServer = server.NewGRPCServer(tree)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- storeServer.ListenAndServe(cfg.addr)
+	}()
+	fmt.Fprintf(out.w, "serving %s with %s on %s\n", path, cfg.protocol, cfg.addr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err = <-served:
+		// The server could not listen, the store still has to be closed
+		closeErr := storeServer.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	case <-signals:
+		return storeServer.Close()
+	}
+}
@@ -0,0 +1,122 @@
+package kvtool
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"main/kv"
+)
+
+const shellPrompt = "kv> "
+
+// shellCommand is a command of the interactive shell. Unlike kvtool commands, it runs against the store that the
+// shell keeps open.
+type shellCommand struct {
+	minArgs, maxArgs int
+	op               storeOp
+	usage            string
+}
+
+var shellCommands = map[string]shellCommand{
+	"GET":    {1, 1, getKey, "GET <key>              print the value of a key"},
+	"PUT":    {2, 2, putKey, "PUT <key> <value>      insert a key"},
+	"DEL":    {1, 1, deleteKey, "DEL <key>              delete a key"},
+	"SCAN":   {0, 2, scanKeys, "SCAN [start] [end]     print all keys in [start, end]"},
+	"TREE":   {0, 0, printTree, "TREE                   print every node with its keys"},
+	"STATS":  {0, 0, printStats, "STATS                  print statistics about the tree"},
+	"VERIFY": {0, 0, verifyTree, "VERIFY                 check the structure of the tree"},
+}
+
+// builtinCommands are handled by the shell itself
+var builtinCommands = []string{"EXIT", "HELP", "HISTORY", "QUIT"}
+
+// runShell keeps the store open and executes commands read from the input until EXIT or the end of the input.
+// Errors of a command are printed and do not end the shell. Changes are persisted when the shell ends.
+func runShell(cfg *config, opts kv.Options, path string, args []string, out output) error {
+	return withStore(opts, path, func(tree *kv.BpTreeImpl) error {
+		editor := newLineEditor(cfg.stdin, out.w, completeCommand)
+
+		for {
+			line, err := editor.readLine(shellPrompt)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			name := strings.ToUpper(fields[0])
+			switch name {
+			case "EXIT", "QUIT":
+				return nil
+			case "HELP":
+				printShellHelp(out.w)
+				continue
+			case "HISTORY":
+				for i, entry := range editor.history {
+					fmt.Fprintf(out.w, "%4d  %s\n", i+1, entry)
+				}
+				continue
+			}
+
+			command, ok := shellCommands[name]
+			if !ok {
+				fmt.Fprintf(out.w, "unknown command %s, type HELP for a list of commands\n", fields[0])
+				continue
+			}
+			if len(fields)-1 < command.minArgs || len(fields)-1 > command.maxArgs {
+				fmt.Fprintln(out.w, "usage:", command.usage)
+				continue
+			}
+
+			err = command.op(cfg, tree, fields[1:], out)
+			if err != nil {
+				fmt.Fprintln(out.w, "error:", err)
+			}
+		}
+	})
+}
+
+func printShellHelp(w io.Writer) {
+	for _, name := range shellCommandNames() {
+		if command, ok := shellCommands[name]; ok {
+			fmt.Fprintln(w, command.usage)
+		}
+	}
+	fmt.Fprintln(w, "HISTORY                print the commands entered so far")
+	fmt.Fprintln(w, "EXIT                   close the store and leave the shell")
+}
+
+// shellCommandNames returns the names of all commands, sorted
+func shellCommandNames() []string {
+	names := append([]string{}, builtinCommands...)
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// completeCommand completes the command name at the start of line. Arguments are not completed.
+func completeCommand(line string) []string {
+	if strings.Contains(line, " ") {
+		return nil
+	}
+
+	candidates := make([]string, 0)
+	for _, name := range shellCommandNames() {
+		if strings.HasPrefix(name, strings.ToUpper(line)) {
+			candidates = append(candidates, name+" ")
+		}
+	}
+
+	return candidates
+}
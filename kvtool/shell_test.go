@@ -0,0 +1,77 @@
+package kvtool
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_Shell(t *testing.T) {
+	path := t.TempDir()
+	_, err := runCommand(t, "create", path)
+	assert.Nil(t, err)
+
+	script := "PUT 1 one\nput 2 two\nGET 2\nDEL 1\nget 1\nTREE\nSTATS\nFROB\nGET\nHISTORY\nEXIT\nGET 2\n"
+	var stdout, stderr bytes.Buffer
+	err = Run([]string{"shell", path}, strings.NewReader(script), &stdout, &stderr)
+
+	assert.Nil(t, err)
+	lines := strings.Split(stdout.String(), "\n")
+	assert.Equal(t, "ok", lines[0])
+	assert.Equal(t, "ok", lines[1])
+	assert.Equal(t, "two", lines[2])
+	assert.Equal(t, "ok", lines[3])
+	assert.Equal(t, "error: kv - key not found", lines[4])
+	assert.Regexp(t, `^PageId:\[\d+\] --- \[2 \| \]$`, lines[5])
+	assert.Equal(t, "height: 1", lines[6])
+	assert.Contains(t, stdout.String(), "unknown command FROB")
+	assert.Contains(t, stdout.String(), "usage: GET <key>")
+	assert.Contains(t, stdout.String(), "   3  GET 2\n")
+	assert.True(t, strings.HasSuffix(stdout.String(), "  10  HISTORY\n")) // Nothing is run after EXIT
+
+	// Changes are persisted when the shell ends
+	output, err := runCommand(t, "get", path, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "two\n", output)
+}
+
+func newTestEditor(keys string) (*lineEditor, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &lineEditor{bufio.NewReader(strings.NewReader(keys)), &out, -1, nil, completeCommand}, &out
+}
+
+func TestLineEditor_CompletionAndHistory(t *testing.T) {
+	editor, _ := newTestEditor("ge\t12\r\x1b[A\x7f3\rx\x03\x1b[A\x1b[A\r")
+
+	line, err := editor.edit(shellPrompt)
+	assert.Nil(t, err)
+	assert.Equal(t, "GET 12", line)
+
+	// Up fetches the previous line, which is then edited
+	line, err = editor.edit(shellPrompt)
+	assert.Nil(t, err)
+	assert.Equal(t, "GET 13", line)
+
+	// Ctrl-C discards "x", up twice goes back to the first line
+	line, err = editor.edit(shellPrompt)
+	assert.Nil(t, err)
+	assert.Equal(t, "GET 12", line)
+
+	assert.Equal(t, []string{"GET 12", "GET 13", "GET 12"}, editor.history)
+}
+
+func TestLineEditor_AmbiguousCompletion(t *testing.T) {
+	editor, out := newTestEditor("s\t\r\x04")
+
+	line, err := editor.edit(shellPrompt)
+	assert.Nil(t, err)
+	assert.Equal(t, "S", line)
+	assert.Contains(t, out.String(), "SCAN   STATS ")
+
+	_, err = editor.edit(shellPrompt)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package kvtool
+
+import (
+	"errors"
+)
+
+// isTerminal reports whether fd refers to a terminal. Raw mode is only supported on Linux,
+// elsewhere the shell reads plain lines without history and completion.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported")
+}
@@ -1,9 +1,16 @@
 package main
 
-// import (
-// 	"errors"
-// 	"fmt"
-// )
+import (
+	"fmt"
+	"os"
+
+	"main/kvtool"
+)
 
 func main() {
+	err := kvtool.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvtool:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"main/kv"
+	"main/kvpb"
+)
+
+// GRPCServer serves a store with the KV service defined in kvpb/kv.proto.
+// Like the HTTPServer, calls are executed concurrently, the store serializes its operations itself.
+type GRPCServer struct {
+	kvpb.UnimplementedKVServer
+
+	tree   *kv.BpTreeImpl
+	server *grpc.Server
+}
+
+// NewGRPCServer returns a server for tree. The tree is closed together with the server.
+func NewGRPCServer(tree *kv.BpTreeImpl) *GRPCServer {
+	s := &GRPCServer{tree: tree, server: grpc.NewServer()}
+	kvpb.RegisterKVServer(s.server, s)
+
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves calls until the server is closed
+func (s *GRPCServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve serves calls on listener until the server is closed. It returns nil after Close.
+func (s *GRPCServer) Serve(listener net.Listener) error {
+	err := s.server.Serve(listener)
+	if err == grpc.ErrServerStopped {
+		return nil
+	}
+
+	return err
+}
+
+// Close stops accepting calls, waits for the running ones and closes the store, which persists all changes.
+// Calls that are still running after shutdownTimeout, e.g. a scan whose client does not read, are aborted.
+func (s *GRPCServer) Close() error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		s.server.Stop()
+		<-stopped
+	}
+
+	return s.tree.Close()
+}
+
+func (s *GRPCServer) Get(ctx context.Context, request *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	value, err := s.tree.Get(int(request.Key))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &kvpb.GetResponse{Value: valueBytes(value)}, nil
+}
+
+func (s *GRPCServer) Put(ctx context.Context, request *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	value, err := toValue(request.Value)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	err = s.tree.Upsert(int(request.Key), value)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &kvpb.PutResponse{}, nil
+}
+
+func (s *GRPCServer) Delete(ctx context.Context, request *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	err := s.tree.Delete(int(request.Key))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &kvpb.DeleteResponse{}, nil
+}
+
+// BatchWrite applies the operations in one batch. Its puts replace existing values, like Put.
+func (s *GRPCServer) BatchWrite(ctx context.Context, request *kvpb.BatchWriteRequest) (*kvpb.BatchWriteResponse, error) {
+	values := make([][10]byte, len(request.Operations))
+	for i, operation := range request.Operations {
+		if operation.Delete {
+			continue
+		}
+
+		var err error
+		values[i], err = toValue(operation.Value)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+	}
+
+	batch := kv.NewWriteBatch()
+	for i, operation := range request.Operations {
+		if operation.Delete {
+			batch.Delete(int(operation.Key))
+		} else {
+			batch.Upsert(int(operation.Key), values[i])
+		}
+	}
+
+	err := s.tree.Write(batch)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &kvpb.BatchWriteResponse{}, nil
+}
+
+// Scan sends the keys in chunks read with scanChunks, so a client that reads slowly does not block other calls.
+// It stops early if the client cancels the call.
+func (s *GRPCServer) Scan(request *kvpb.ScanRequest, stream kvpb.KV_ScanServer) error {
+	start, end := math.MinInt64, math.MaxInt64
+	if request.Start != nil {
+		start = int(request.Start.Key)
+	}
+	if request.End != nil {
+		end = int(request.End.Key)
+	}
+
+	var sendErr error
+	err := scanChunks(s.tree, start, end, int(request.Limit), func(chunk []entry) bool {
+		for _, e := range chunk {
+			sendErr = stream.Send(&kvpb.Pair{Key: int64(e.key), Value: valueBytes(e.value)})
+			if sendErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+
+	return sendErr
+}
+
+func (s *GRPCServer) Stats(ctx context.Context, request *kvpb.StatsRequest) (*kvpb.StatsResponse, error) {
+	stats, err := s.tree.Stats()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &kvpb.StatsResponse{
+		Height:     uint32(stats.Height),
+		Keys:       uint64(stats.Keys),
+		Leaves:     uint64(stats.Leaves),
+		InnerNodes: uint64(stats.InnerNodes),
+		Pages:      uint64(stats.Pages),
+		FillFactor: stats.FillFactor,
+	}, nil
+}
+
+// toValue pads data to a value, it returns ErrBadValue if data is longer than a value
+func toValue(data []byte) ([10]byte, error) {
+	var value [10]byte
+	if len(data) > len(value) {
+		return value, kv.ErrBadValue
+	}
+
+	copy(value[:], data)
+	return value, nil
+}
+
+// grpcError maps errors of the store to gRPC status codes
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, kv.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, kv.ErrSameKeyTwice):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, kv.ErrBadValue):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, kv.ErrStoreFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"main/kv"
+	"main/kvpb"
+)
+
+func startGRPCServer(t *testing.T) (*GRPCServer, kvpb.KVClient) {
+	var store kv.BpTreeImpl
+	tree, err := store.Create(t.TempDir(), 0)
+	assert.Nil(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewGRPCServer(tree)
+	go server.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return server, kvpb.NewKVClient(conn)
+}
+
+func TestGRPCServer_Keys(t *testing.T) {
+	server, client := startGRPCServer(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	_, err := client.Put(ctx, &kvpb.PutRequest{Key: -42, Value: []byte("hello")})
+	assert.Nil(t, err)
+	response, err := client.Get(ctx, &kvpb.GetRequest{Key: -42})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), response.Value)
+
+	// Put replaces existing values
+	_, err = client.Put(ctx, &kvpb.PutRequest{Key: -42, Value: []byte("world")})
+	assert.Nil(t, err)
+	response, err = client.Get(ctx, &kvpb.GetRequest{Key: -42})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("world"), response.Value)
+
+	_, err = client.Delete(ctx, &kvpb.DeleteRequest{Key: -42})
+	assert.Nil(t, err)
+	_, err = client.Get(ctx, &kvpb.GetRequest{Key: -42})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Delete(ctx, &kvpb.DeleteRequest{Key: -42})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Put(ctx, &kvpb.PutRequest{Key: 1, Value: []byte("a value that is too long")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCServer_BatchWrite_AllOrNothing(t *testing.T) {
+	server, client := startGRPCServer(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	operations := []*kvpb.Operation{}
+	for key := int64(0); key < 100; key++ {
+		operations = append(operations, &kvpb.Operation{Key: key, Value: []byte{byte(key)}})
+	}
+	_, err := client.BatchWrite(ctx, &kvpb.BatchWriteRequest{Operations: operations})
+	assert.Nil(t, err)
+
+	// The delete of the missing key 500 fails, so the delete of key 1 and the put of key 5 are not applied either
+	_, err = client.BatchWrite(ctx, &kvpb.BatchWriteRequest{Operations: []*kvpb.Operation{
+		{Key: 1, Delete: true},
+		{Key: 5, Value: []byte("again")},
+		{Key: 500, Delete: true},
+	}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Get(ctx, &kvpb.GetRequest{Key: 1})
+	assert.Nil(t, err)
+	response, _ := client.Get(ctx, &kvpb.GetRequest{Key: 5})
+	assert.Equal(t, []byte{5}, response.Value)
+
+	stats, err := client.Stats(ctx, &kvpb.StatsRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), stats.Keys)
+	assert.True(t, stats.Height > 1)
+}
+
+func TestGRPCServer_BatchWrite_PutsReplaceValues(t *testing.T) {
+	server, client := startGRPCServer(t)
+	defer server.Close()
+	ctx := context.Background()
+	_, err := client.Put(ctx, &kvpb.PutRequest{Key: 1, Value: []byte("one")})
+	assert.Nil(t, err)
+
+	_, err = client.BatchWrite(ctx, &kvpb.BatchWriteRequest{Operations: []*kvpb.Operation{
+		{Key: 1, Value: []byte("uno")},
+		{Key: 2, Value: []byte("two")},
+		{Key: 2, Value: []byte("dos")},
+		{Key: 3, Value: []byte("three")},
+		{Key: 3, Delete: true},
+	}})
+
+	assert.Nil(t, err)
+	response, _ := client.Get(ctx, &kvpb.GetRequest{Key: 1})
+	assert.Equal(t, []byte("uno"), response.Value[:3])
+	response, _ = client.Get(ctx, &kvpb.GetRequest{Key: 2})
+	assert.Equal(t, []byte("dos"), response.Value[:3])
+	_, err = client.Get(ctx, &kvpb.GetRequest{Key: 3})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCServer_Scan_StreamsKeysInOrder(t *testing.T) {
+	server, client := startGRPCServer(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	for key := int64(200); key > 0; key-- {
+		_, err := client.Put(ctx, &kvpb.PutRequest{Key: key, Value: []byte("v")})
+		assert.Nil(t, err)
+	}
+
+	stream, err := client.Scan(ctx, &kvpb.ScanRequest{Start: &kvpb.Bound{Key: 50}, End: &kvpb.Bound{Key: 150}, Limit: 60})
+	assert.Nil(t, err)
+	keys := []int64{}
+	for {
+		pair, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		keys = append(keys, pair.Key)
+	}
+	assert.Equal(t, 60, len(keys))
+	assert.Equal(t, int64(50), keys[0])
+	assert.Equal(t, int64(109), keys[59])
+
+	// Without bounds, all keys are sent
+	stream, err = client.Scan(ctx, &kvpb.ScanRequest{})
+	assert.Nil(t, err)
+	count := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 200, count)
+}
+
+func TestGRPCServer_StalledScan_OtherCallsServedAndClosed(t *testing.T) {
+	server, client := startGRPCServer(t)
+	operations := []*kvpb.Operation{}
+	for key := int64(0); key < 10000; key++ {
+		operations = append(operations, &kvpb.Operation{Key: key, Value: []byte("0123456789")})
+	}
+	_, err := client.BatchWrite(context.Background(), &kvpb.BatchWriteRequest{Operations: operations})
+	assert.Nil(t, err)
+
+	// The client receives nothing, so the server blocks once the flow control window is exhausted
+	_, err = client.Scan(context.Background(), &kvpb.ScanRequest{})
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	response, err := client.Get(ctx, &kvpb.GetRequest{Key: 42})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("0123456789"), response.GetValue())
+
+	assert.Nil(t, server.Close())
+}
+
+func TestGRPCServer_Close_PersistsStore(t *testing.T) {
+	server, client := startGRPCServer(t)
+	_, err := client.Put(context.Background(), &kvpb.PutRequest{Key: 7, Value: []byte("seven")})
+	assert.Nil(t, err)
+
+	path := server.tree.Path
+	assert.Nil(t, server.Close())
+
+	var store kv.BpTreeImpl
+	tree, err := store.Open(path)
+	assert.Nil(t, err)
+	value, err := tree.Get(7)
+	assert.Nil(t, err)
+	assert.Equal(t, "seven", kv.FormatValue(value))
+}
@@ -0,0 +1,331 @@
+// Package server exposes a key-value store over the network
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/kv"
+)
+
+// scanChunkSize is the number of keys a scan reads at a time. The store is locked while they are read, and the
+// response is flushed to the client after every chunk.
+const scanChunkSize = 100
+
+const (
+	// writeTimeout limits how long writing a response, or a chunk of a scan, may take, so a client that stops
+	// reading does not keep its request running
+	writeTimeout = 30 * time.Second
+
+	// shutdownTimeout is how long Close waits for running requests before it closes their connections
+	shutdownTimeout = 5 * time.Second
+)
+
+// connKey is the context key of the connection of a request
+type connKey struct{}
+
+// HTTPServer serves a store with a JSON API:
+//
+//	GET    /keys/{key}                     value of a key
+//	PUT    /keys/{key}                     insert or replace a key, the body is {"value": "..."}
+//	DELETE /keys/{key}                     delete a key
+//	GET    /scan?start=&end=&limit=        keys in [start, end], one JSON document per line, see handleScan
+//	GET    /stats                          statistics about the tree
+//	GET    /usage                          pages used and free, see kv.Usage
+//	GET    /healthz                        "ok" while the server is running
+//
+// Values are text or 0x-prefixed hex, like in kvtool. Requests are executed concurrently, the store serializes
+// its operations itself.
+type HTTPServer struct {
+	tree   *kv.BpTreeImpl
+	server *http.Server
+}
+
+type pair struct {
+	Key   int    `json:"key"`
+	Value string `json:"value"`
+}
+
+type putRequest struct {
+	Value string `json:"value"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewHTTPServer returns a server for tree. The tree is closed together with the server.
+func NewHTTPServer(tree *kv.BpTreeImpl) *HTTPServer {
+	s := &HTTPServer{tree: tree}
+	s.server = &http.Server{
+		Handler: s,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, conn)
+		},
+	}
+
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves requests until the server is closed
+func (s *HTTPServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve serves requests on listener until the server is closed. It returns nil after Close.
+func (s *HTTPServer) Serve(listener net.Listener) error {
+	err := s.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// Close stops accepting requests, waits for the running ones and closes the store, which persists all changes.
+// Requests that are still running after shutdownTimeout are aborted.
+func (s *HTTPServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := s.server.Shutdown(ctx)
+	if err == context.DeadlineExceeded {
+		err = s.server.Close()
+	}
+
+	closeErr := s.tree.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	extendWriteDeadline(r)
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/keys/"):
+		s.handleKey(w, r, strings.TrimPrefix(r.URL.Path, "/keys/"))
+	case r.URL.Path == "/scan" && r.Method == http.MethodGet:
+		s.handleScan(w, r)
+	case r.URL.Path == "/stats" && r.Method == http.MethodGet:
+		stats, err := s.tree.Stats()
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	case r.URL.Path == "/usage" && r.Method == http.MethodGet:
+		usage, err := s.tree.Usage()
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, usage)
+	case r.URL.Path == "/healthz" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint"))
+	}
+}
+
+func (s *HTTPServer) handleKey(w http.ResponseWriter, r *http.Request, keyText string) {
+	key, err := strconv.Atoi(keyText)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := s.tree.Get(key)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pair{key, kv.FormatValue(value)})
+
+	case http.MethodPut:
+		var request putRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		value, err := kv.ParseValue(request.Value)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+
+		err = s.tree.Upsert(key, value)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pair{key, kv.FormatValue(value)})
+
+	case http.MethodDelete:
+		err := s.tree.Delete(key)
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// handleScan streams the keys in chunks of scanChunkSize, one JSON document per line, see scanChunks.
+// The response is flushed after every chunk, so clients can process large ranges while they are transferred.
+// The status is only sent with the first key, so a scan that fails before returns an error status. A scan that
+// fails later ends the stream with an error document, {"error": "..."}, instead of a key.
+func (s *HTTPServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	start, err := queryInt(r, "start", math.MinInt64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	end, err := queryInt(r, "end", math.MaxInt64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	started := false
+	startStream := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	err = scanChunks(s.tree, start, end, limit, func(chunk []entry) bool {
+		startStream()
+		extendWriteDeadline(r)
+		for _, e := range chunk {
+			// The client is gone if the response cannot be written, so the scan is stopped
+			if encoder.Encode(pair{e.key, kv.FormatValue(e.value)}) != nil {
+				return false
+			}
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	})
+	if err != nil && !started {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	startStream()
+	if err != nil {
+		encoder.Encode(errorResponse{err.Error()})
+	}
+}
+
+// entry is a pair read by scanChunks
+type entry struct {
+	key   int
+	value [10]byte
+}
+
+// scanChunks passes the pairs of the keys in [start, end], at most limit of them or all if limit is 0, to send in
+// chunks of scanChunkSize pairs, until send returns false. The store is only locked while a chunk is read, so a client
+// that reads slowly does not block other requests. The next chunk starts behind the last key of the previous one and
+// sees the changes made in between.
+func scanChunks(tree *kv.BpTreeImpl, start int, end int, limit int, send func(chunk []entry) bool) error {
+	count := 0
+	for {
+		size := scanChunkSize
+		if limit > 0 && limit-count < size {
+			size = limit - count
+		}
+
+		chunk := make([]entry, 0, size)
+		err := tree.Scan(start, end, func(key int, value [10]byte) bool {
+			chunk = append(chunk, entry{key, value})
+			return len(chunk) < size
+		})
+		if len(chunk) > 0 && !send(chunk) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		count += len(chunk)
+		last := 0
+		if len(chunk) > 0 {
+			last = chunk[len(chunk)-1].key
+		}
+		if len(chunk) < size || count == limit || last >= end {
+			return nil
+		}
+		start = last + 1
+	}
+}
+
+// extendWriteDeadline lets the response to r be written for another writeTimeout
+func extendWriteDeadline(r *http.Request) {
+	if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+}
+
+// queryInt returns the query parameter name as int, or fallback if it is not given
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	text := r.URL.Query().Get(name)
+	if text == "" {
+		return fallback, nil
+	}
+
+	return strconv.Atoi(text)
+}
+
+// statusOf maps errors of the store to HTTP status codes
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, kv.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, kv.ErrBadValue), errors.Is(err, kv.ErrSameKeyTwice):
+		return http.StatusBadRequest
+	case errors.Is(err, kv.ErrStoreFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, document interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(document)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{err.Error()})
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"main/kv"
+)
+
+// startHTTPServer serves a new store on a local port and returns its base URL
+func startHTTPServer(t *testing.T) (*HTTPServer, string) {
+	path := t.TempDir()
+	var store kv.BpTreeImpl
+	tree, err := store.Create(path, 0)
+	assert.Nil(t, err)
+
+	return serveHTTP(t, tree)
+}
+
+// serveHTTP serves tree on a local port and returns its base URL
+func serveHTTP(t *testing.T, tree *kv.BpTreeImpl) (*HTTPServer, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := NewHTTPServer(tree)
+	go server.Serve(listener)
+
+	return server, "http://" + listener.Addr().String()
+}
+
+func request(t *testing.T, method string, url string, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestHTTPServer_Keys(t *testing.T) {
+	server, url := startHTTPServer(t)
+	defer server.Close()
+
+	status, body := request(t, http.MethodPut, url+"/keys/42", `{"value":"hello"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\"key\":42,\"value\":\"hello\"}\n", body)
+
+	status, body = request(t, http.MethodGet, url+"/keys/42", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\"key\":42,\"value\":\"hello\"}\n", body)
+
+	// PUT replaces existing values
+	status, _ = request(t, http.MethodPut, url+"/keys/42", `{"value":"0x01"}`)
+	assert.Equal(t, http.StatusOK, status)
+	_, body = request(t, http.MethodGet, url+"/keys/42", "")
+	assert.Contains(t, body, "0x01000000000000000000")
+
+	status, _ = request(t, http.MethodDelete, url+"/keys/42", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(t, http.MethodGet, url+"/keys/42", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = request(t, http.MethodDelete, url+"/keys/42", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestHTTPServer_InvalidRequests_Fail(t *testing.T) {
+	server, url := startHTTPServer(t)
+	defer server.Close()
+
+	status, _ := request(t, http.MethodGet, url+"/keys/abc", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = request(t, http.MethodPut, url+"/keys/1", `{"value":"a value that is too long"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = request(t, http.MethodPut, url+"/keys/1", `not json`)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = request(t, http.MethodPost, url+"/keys/1", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+
+	status, _ = request(t, http.MethodGet, url+"/unknown", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestHTTPServer_Scan_StreamsKeysInOrder(t *testing.T) {
+	server, url := startHTTPServer(t)
+	defer server.Close()
+
+	for key := 500; key > 0; key-- {
+		status, _ := request(t, http.MethodPut, url+"/keys/"+strconv.Itoa(key), `{"value":"v"}`)
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	resp, err := http.Get(url + "/scan?start=100&end=400&limit=250")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	keys := []int{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var p pair
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &p))
+		keys = append(keys, p.Key)
+	}
+	assert.Equal(t, 250, len(keys))
+	assert.Equal(t, 100, keys[0])
+	assert.Equal(t, 349, keys[len(keys)-1])
+
+	status, body := request(t, http.MethodGet, url+"/stats", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "\"Keys\":500")
+
+	status, body = request(t, http.MethodGet, url+"/usage", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "\"FreePages\":")
+}
+
+func TestHTTPServer_ScanFailsAtStart_ErrorStatus(t *testing.T) {
+	server, url := serveHTTP(t, corruptStore(t, func(node kv.NodeInfo) bool { return node.Level > 0 }))
+	defer server.Close()
+
+	status, body := request(t, http.MethodGet, url+"/scan", "")
+
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, "\"error\"")
+}
+
+func TestHTTPServer_ScanFailsLater_EndsWithError(t *testing.T) {
+	server, url := serveHTTP(t, corruptStore(t, func(node kv.NodeInfo) bool { return node.IsLeaf && node.NextPageId == 0 }))
+	defer server.Close()
+
+	status, body := request(t, http.MethodGet, url+"/scan", "")
+
+	assert.Equal(t, http.StatusOK, status)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	assert.Greater(t, len(lines), 1)
+	assert.Contains(t, lines[0], "\"key\":0")
+	assert.Contains(t, lines[len(lines)-1], "\"error\"")
+}
+
+func TestHTTPServer_Close_PersistsStore(t *testing.T) {
+	server, url := startHTTPServer(t)
+
+	status, body := request(t, http.MethodGet, url+"/healthz", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\"status\":\"ok\"}\n", body)
+	request(t, http.MethodPut, url+"/keys/7", `{"value":"seven"}`)
+
+	path := server.tree.Path
+	assert.Nil(t, server.Close())
+	_, err := http.Get(url + "/healthz")
+	assert.NotNil(t, err)
+
+	var store kv.BpTreeImpl
+	tree, err := store.Open(path)
+	assert.Nil(t, err)
+	value, err := tree.Get(7)
+	assert.Nil(t, err)
+	assert.Equal(t, "seven", kv.FormatValue(value))
+}
+
+// smallBufferListener shrinks the send buffer of accepted connections, so that a response blocks soon if the client
+// does not read it
+type smallBufferListener struct {
+	net.Listener
+}
+
+func (l smallBufferListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		conn.(*net.TCPConn).SetWriteBuffer(4096)
+	}
+	return conn, err
+}
+
+func TestHTTPServer_StalledScan_OtherRequestsServed(t *testing.T) {
+	var store kv.BpTreeImpl
+	tree, err := store.Create(t.TempDir(), 0)
+	assert.Nil(t, err)
+	batch := kv.NewWriteBatch()
+	for key := 0; key < 10000; key++ {
+		batch.Put(key, [10]byte{1})
+	}
+	assert.Nil(t, tree.Write(batch))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewHTTPServer(tree)
+	go server.Serve(smallBufferListener{listener})
+	url := "http://" + listener.Addr().String()
+
+	// The client reads nothing of the scan, so the server blocks once the buffers of the connection are full
+	dial := func(ctx context.Context, network string, address string) (net.Conn, error) {
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+		if err == nil {
+			conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	}
+	stalled := &http.Client{Transport: &http.Transport{DialContext: dial}}
+	resp, err := stalled.Get(url + "/scan")
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(url + "/keys/42")
+	assert.Nil(t, err)
+	if err == nil {
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		response.Body.Close()
+	}
+
+	resp.Body.Close()
+	assert.Nil(t, server.Close())
+}
@@ -0,0 +1,567 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"main/kv"
+)
+
+// maxBulkLength limits the size of a single argument and the number of arguments, which protects the server against
+// malformed requests
+const maxBulkLength = 1 << 20
+
+// maxLineLength limits the length of an inline command and of the header of an array or a bulk string
+const maxLineLength = 64 << 10
+
+// defaultScanCount is the number of keys SCAN returns if COUNT is not given, like in Redis
+const defaultScanCount = 10
+
+var errProtocol = errors.New("protocol error")
+
+// RESPServer serves a store over TCP with a subset of the Redis protocol (RESP2), so that Redis clients can use it.
+// The supported commands are GET, SET, DEL, EXISTS, SCAN, MGET, MSET, PING and INFO.
+//
+// Keys have to be integers. Values are stored as they are sent and have to fit into the 10 bytes of a value,
+// trailing zero bytes are not returned. Like the HTTPServer, commands are executed one at a time.
+type RESPServer struct {
+	mu   sync.Mutex // Guards tree
+	tree *kv.BpTreeImpl
+
+	connMu   sync.Mutex // Guards the fields below
+	listener net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+	handlers sync.WaitGroup // Running connection handlers
+}
+
+// respHandler executes a command. args does not contain the name of the command.
+type respHandler func(s *RESPServer, args []string, w *respWriter)
+
+type respCommand struct {
+	minArgs, maxArgs int // maxArgs is -1 for any number of arguments
+	handler          respHandler
+}
+
+var respCommands = map[string]respCommand{
+	"GET":    {1, 1, (*RESPServer).get},
+	"SET":    {2, 2, (*RESPServer).set},
+	"DEL":    {1, -1, (*RESPServer).del},
+	"EXISTS": {1, -1, (*RESPServer).exists},
+	"SCAN":   {1, 5, (*RESPServer).scan},
+	"MGET":   {1, -1, (*RESPServer).mget},
+	"MSET":   {2, -1, (*RESPServer).mset},
+	"PING":   {0, 1, (*RESPServer).ping},
+	"INFO":   {0, 1, (*RESPServer).info},
+}
+
+// NewRESPServer returns a server for tree. The tree is closed together with the server.
+func NewRESPServer(tree *kv.BpTreeImpl) *RESPServer {
+	return &RESPServer{tree: tree, conns: make(map[net.Conn]bool)}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections until the server is closed
+func (s *RESPServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on listener until the server is closed. It returns nil after Close.
+func (s *RESPServer) Serve(listener net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.connMu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			s.connMu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = true
+		s.handlers.Add(1)
+		s.connMu.Unlock()
+
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones after their current command and closes the store,
+// which persists all changes
+func (s *RESPServer) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+
+	s.handlers.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Close()
+}
+
+func (s *RESPServer) handleConn(conn net.Conn) {
+	defer func() {
+		s.connMu.Lock()
+		delete(s.conns, conn)
+		s.connMu.Unlock()
+		conn.Close()
+		s.handlers.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := &respWriter{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.writeError("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.execute(args, w)
+
+		// Replies to pipelined commands are sent together
+		if r.Buffered() == 0 && w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (s *RESPServer) execute(args []string, w *respWriter) {
+	name := strings.ToUpper(args[0])
+	command, ok := respCommands[name]
+	if !ok {
+		w.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if len(args)-1 < command.minArgs || (command.maxArgs >= 0 && len(args)-1 > command.maxArgs) {
+		w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+
+	command.handler(s, args[1:], w)
+}
+
+func (s *RESPServer) get(args []string, w *respWriter) {
+	key, ok := parseKey(args[0], w)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	value, err := s.tree.Get(key)
+	s.mu.Unlock()
+	if err == kv.ErrNotFound {
+		w.writeNull()
+		return
+	}
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeBulk(valueBytes(value))
+}
+
+func (s *RESPServer) set(args []string, w *respWriter) {
+	s.mset(args, w)
+}
+
+func (s *RESPServer) mset(args []string, w *respWriter) {
+	if len(args)%2 != 0 {
+		w.writeError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	keys := make([]int, 0, len(args)/2)
+	values := make([][10]byte, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, ok := parseKey(args[i], w)
+		if !ok {
+			return
+		}
+		value, err := toValue([]byte(args[i+1]))
+		if err != nil {
+			w.writeError("ERR value is longer than 10 bytes")
+			return
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The pairs are written in one batch, so that either all or none of them are set
+	batch := kv.NewWriteBatch()
+	for i, key := range keys {
+		batch.Upsert(key, values[i])
+	}
+
+	err := s.tree.Write(batch)
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeSimple("OK")
+}
+
+func (s *RESPServer) del(args []string, w *respWriter) {
+	keys, ok := parseKeys(args, w)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The keys that exist are deleted in one batch, so that either all or none of them are deleted
+	batch := kv.NewWriteBatch()
+	deleted := make(map[int]bool)
+	for _, key := range keys {
+		if deleted[key] {
+			continue
+		}
+		_, err := s.tree.Get(key)
+		if err == kv.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		batch.Delete(key)
+		deleted[key] = true
+	}
+
+	err := s.tree.Write(batch)
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeInt(len(deleted))
+}
+
+func (s *RESPServer) exists(args []string, w *respWriter) {
+	keys, ok := parseKeys(args, w)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, key := range keys {
+		if _, err := s.tree.Get(key); err == nil {
+			count++
+		}
+	}
+	w.writeInt(count)
+}
+
+func (s *RESPServer) mget(args []string, w *respWriter) {
+	keys, ok := parseKeys(args, w)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Missing keys are null, any other error fails the whole command
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := s.tree.Get(key)
+		if err == kv.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		values[i] = valueBytes(value)
+	}
+
+	w.writeArrayHeader(len(keys))
+	for _, value := range values {
+		if value == nil {
+			w.writeNull()
+		} else {
+			w.writeBulk(value)
+		}
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. The cursor encodes the key behind the last returned one,
+// so a scan continues there even if keys are inserted or deleted in between. Cursor 0 starts and ends a scan.
+func (s *RESPServer) scan(args []string, w *respWriter) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		w.writeError("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "", defaultScanCount
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	start := cursorStart(cursor)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Like in Redis, COUNT limits the visited keys, MATCH only filters them
+	keys := []string{}
+	visited, last := 0, 0
+	err = s.tree.Scan(start, math.MaxInt64, func(key int, value [10]byte) bool {
+		text := strconv.Itoa(key)
+		if matched, _ := path.Match(pattern, text); pattern == "" || matched {
+			keys = append(keys, text)
+		}
+		visited++
+		last = key
+		return visited < count
+	})
+	if err != nil {
+		// A cursor of 0 would tell the client that the scan is complete
+		w.writeError("ERR " + err.Error())
+		return
+	}
+
+	// There is no key behind the largest one, which must not wrap around to the smallest
+	next := uint64(0)
+	if visited == count && last != math.MaxInt64 {
+		next = startCursor(last + 1)
+	}
+
+	w.writeArrayHeader(2)
+	w.writeBulk([]byte(strconv.FormatUint(next, 10)))
+	w.writeArrayHeader(len(keys))
+	for _, key := range keys {
+		w.writeBulk([]byte(key))
+	}
+}
+
+func (s *RESPServer) ping(args []string, w *respWriter) {
+	if len(args) == 1 {
+		w.writeBulk([]byte(args[0]))
+		return
+	}
+	w.writeSimple("PONG")
+}
+
+func (s *RESPServer) info(args []string, w *respWriter) {
+	s.mu.Lock()
+	stats, err := s.tree.Stats()
+	path := s.tree.Path
+	s.mu.Unlock()
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "# Server\r\nstore_path:%s\r\n\r\n", path)
+	fmt.Fprintf(&text, "# Keyspace\r\ndb0:keys=%d\r\n\r\n", stats.Keys)
+	fmt.Fprintf(&text, "# Tree\r\nheight:%d\r\nleaves:%d\r\ninner_nodes:%d\r\npages:%d\r\nfill_factor:%.2f\r\n",
+		stats.Height, stats.Leaves, stats.InnerNodes, stats.Pages, stats.FillFactor)
+	w.writeBulk([]byte(text.String()))
+}
+
+// startCursor maps the key a scan continues at to a SCAN cursor. The order of keys is kept, and the smallest key,
+// where a scan starts, maps to 0.
+func startCursor(start int) uint64 {
+	return uint64(start) ^ 1<<63
+}
+
+// cursorStart is the inverse of startCursor
+func cursorStart(cursor uint64) int {
+	return int(cursor ^ 1<<63)
+}
+
+func parseKey(text string, w *respWriter) (int, bool) {
+	key, err := strconv.Atoi(text)
+	if err != nil {
+		w.writeError("ERR key is not an integer")
+		return 0, false
+	}
+
+	return key, true
+}
+
+func parseKeys(args []string, w *respWriter) ([]int, bool) {
+	keys := make([]int, len(args))
+	for i, arg := range args {
+		key, ok := parseKey(arg, w)
+		if !ok {
+			return nil, false
+		}
+		keys[i] = key
+	}
+
+	return keys, true
+}
+
+// valueBytes returns value without its trailing zero bytes, which are only padding
+func valueBytes(value [10]byte) []byte {
+	end := len(value)
+	for end > 0 && value[end-1] == 0 {
+		end--
+	}
+
+	return value[:end]
+}
+
+// readCommand reads a command as an array of bulk strings. Inline commands, as typed in telnet, are accepted as well.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxBulkLength {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	if n <= 0 {
+		return nil, nil // A null or empty array, which Redis ignores as well
+	}
+
+	// n is not trusted to preallocate the arguments, they are only allocated as they arrive
+	var args []string
+	for i := 0; i < n; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		data := make([]byte, length+2)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:length]))
+	}
+
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF and returns it without the terminator. A line that does not end within
+// maxLineLength bytes is a protocol error.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		part, err := r.ReadSlice('\n')
+		line = append(line, part...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+		if len(line) >= maxLineLength {
+			return "", fmt.Errorf("%w: too big request line", errProtocol)
+		}
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// respWriter encodes replies in RESP2
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w *respWriter) writeSimple(text string) {
+	fmt.Fprintf(w, "+%s\r\n", text)
+}
+
+func (w *respWriter) writeError(text string) {
+	fmt.Fprintf(w, "-%s\r\n", text)
+}
+
+func (w *respWriter) writeInt(n int) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func (w *respWriter) writeBulk(data []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(data))
+	w.Write(data)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeNull() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) writeArrayHeader(n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
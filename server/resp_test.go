@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"main/kv"
+)
+
+// respClient is a minimal Redis client, replies are decoded to string, int, nil, error or []interface{}
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startRESPServer(t *testing.T) (*RESPServer, *respClient) {
+	var store kv.BpTreeImpl
+	tree, err := store.Create(t.TempDir(), 0)
+	assert.Nil(t, err)
+
+	return serveRESP(t, tree)
+}
+
+// serveRESP serves tree with a RESP server and connects a client to it
+func serveRESP(t *testing.T, tree *kv.BpTreeImpl) (*RESPServer, *respClient) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewRESPServer(tree)
+	go server.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	return server, &respClient{conn, bufio.NewReader(conn)}
+}
+
+// corruptStore returns an encrypted store with the keys 0 to 99 whose nodes for which corrupt returns true cannot be
+// decrypted anymore, so that reading them fails
+func corruptStore(t *testing.T, corrupt func(node kv.NodeInfo) bool) *kv.BpTreeImpl {
+	key := []byte("0123456789abcdef")
+	var store kv.BpTreeImpl
+	tree, err := store.CreateWithOptions(t.TempDir(), 0, kv.Options{EncryptionKey: key})
+	assert.Nil(t, err)
+	for k := 0; k < 100; k++ {
+		assert.Nil(t, tree.Put(k, [10]byte{1}))
+	}
+	var pageIds []int
+	assert.Nil(t, tree.Walk(func(node kv.NodeInfo) bool {
+		if corrupt(node) {
+			pageIds = append(pageIds, node.PageId)
+		}
+		return true
+	}))
+	assert.Nil(t, tree.Close())
+
+	for _, pageId := range pageIds {
+		os.WriteFile(tree.Path+"/KVSTOREPAGES/"+strconv.Itoa(pageId), []byte("garbage"), 0644)
+	}
+
+	tree, err = store.OpenWithOptions(tree.Path, kv.Options{EncryptionKey: key})
+	assert.Nil(t, err)
+	return tree
+}
+
+func (c *respClient) send(args ...string) {
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+func (c *respClient) do(args ...string) interface{} {
+	c.send(args...)
+	return c.reply()
+}
+
+func (c *respClient) reply() interface{} {
+	line, err := readLine(c.r)
+	if err != nil {
+		return err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return errors.New(line[1:])
+	case ':':
+		n, _ := strconv.Atoi(line[1:])
+		return n
+	case '$':
+		length, _ := strconv.Atoi(line[1:])
+		if length < 0 {
+			return nil
+		}
+		data := make([]byte, length+2)
+		io.ReadFull(c.r, data)
+		return string(data[:length])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		elements := make([]interface{}, n)
+		for i := range elements {
+			elements[i] = c.reply()
+		}
+		return elements
+	}
+
+	return fmt.Errorf("unexpected reply %q", line)
+}
+
+func TestRESPServer_Commands(t *testing.T) {
+	server, client := startRESPServer(t)
+	defer server.Close()
+
+	assert.Equal(t, "PONG", client.do("PING"))
+	assert.Equal(t, "hello", client.do("ping", "hello"))
+
+	assert.Equal(t, "OK", client.do("SET", "1", "one"))
+	assert.Equal(t, "OK", client.do("SET", "1", "uno"))
+	assert.Equal(t, "uno", client.do("GET", "1"))
+	assert.Nil(t, client.do("GET", "2"))
+
+	assert.Equal(t, "OK", client.do("MSET", "2", "two", "-3", "\x00\x01"))
+	assert.Equal(t, []interface{}{"uno", nil, "\x00\x01"}, client.do("MGET", "1", "4", "-3"))
+	assert.Equal(t, 2, client.do("EXISTS", "1", "2", "4"))
+
+	assert.Equal(t, 2, client.do("DEL", "1", "2", "1", "4"))
+	assert.Equal(t, 0, client.do("EXISTS", "1", "2"))
+
+	info := client.do("INFO")
+	assert.Contains(t, info, "db0:keys=1\r\n")
+}
+
+func TestRESPServer_InvalidCommands_Fail(t *testing.T) {
+	server, client := startRESPServer(t)
+	defer server.Close()
+
+	assert.Equal(t, errors.New("ERR unknown command 'FLUSHALL'"), client.do("FLUSHALL"))
+	assert.Equal(t, errors.New("ERR wrong number of arguments for 'get' command"), client.do("GET"))
+	assert.Equal(t, errors.New("ERR wrong number of arguments for 'mset' command"), client.do("MSET", "1", "a", "2"))
+	assert.Equal(t, errors.New("ERR key is not an integer"), client.do("SET", "key", "a"))
+	assert.Equal(t, errors.New("ERR value is longer than 10 bytes"), client.do("SET", "1", "a value that is too long"))
+
+	// The connection is still usable
+	assert.Equal(t, "PONG", client.do("PING"))
+}
+
+func TestRESPServer_NegativeMultibulkLength_Ignored(t *testing.T) {
+	server, client := startRESPServer(t)
+	defer server.Close()
+
+	fmt.Fprint(client.conn, "*-1\r\n*0\r\n*-42\r\n")
+
+	assert.Equal(t, "PONG", client.do("PING"))
+}
+
+func TestRESPServer_LongLine_ProtocolError(t *testing.T) {
+	server, client := startRESPServer(t)
+	defer server.Close()
+
+	fmt.Fprint(client.conn, strings.Repeat("a", maxLineLength))
+
+	assert.Equal(t, errors.New("ERR protocol error: too big request line"), client.reply())
+	_, err := client.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRESPServer_PageErrors_Fail(t *testing.T) {
+	server, client := serveRESP(t, corruptStore(t, func(node kv.NodeInfo) bool { return node.Level > 0 }))
+	defer server.Close()
+
+	_, isErr := client.do("SCAN", "0").(error)
+	assert.True(t, isErr)
+	_, isErr = client.do("MGET", "1", "2").(error)
+	assert.True(t, isErr)
+	_, isErr = client.do("MSET", "1", "a", "200", "b").(error)
+	assert.True(t, isErr)
+	_, isErr = client.do("DEL", "1", "2").(error)
+	assert.True(t, isErr)
+}
+
+func TestRESPServer_MsetStoreFull_NothingSet(t *testing.T) {
+	var store kv.BpTreeImpl
+	tree, err := store.CreateWithOptions(t.TempDir(), 0, kv.Options{MaxPages: 10})
+	assert.Nil(t, err)
+	server, client := serveRESP(t, tree)
+	defer server.Close()
+	args := []string{"MSET"}
+	for key := 0; key < 200; key++ {
+		args = append(args, strconv.Itoa(key), "v")
+	}
+
+	assert.Equal(t, errors.New("ERR "+kv.ErrStoreFull.Error()), client.do(args...))
+	assert.Equal(t, 0, client.do("EXISTS", "0", "199"))
+	assert.Equal(t, "OK", client.do("MSET", "1", "a", "1", "b", "2", "c"))
+	assert.Equal(t, "OK", client.do("MSET", "2", "d"))
+	assert.Equal(t, []interface{}{"b", "d"}, client.do("MGET", "1", "2"))
+}
+
+func TestRESPServer_Scan_VisitsEveryKeyOnce(t *testing.T) {
+	server, client := startRESPServer(t)
+	defer server.Close()
+
+	for key := -50; key < 50; key++ {
+		assert.Equal(t, "OK", client.do("SET", strconv.Itoa(key), "v"))
+	}
+	assert.Equal(t, "OK", client.do("SET", strconv.Itoa(math.MaxInt64), "max"))
+
+	keys := []string{}
+	cursor := "0"
+	for {
+		reply := client.do("SCAN", cursor, "COUNT", "7").([]interface{})
+		for _, key := range reply[1].([]interface{}) {
+			keys = append(keys, key.(string))
+		}
+
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, 101, len(keys))
+	assert.Equal(t, "-50", keys[0])
+	assert.Equal(t, strconv.Itoa(math.MaxInt64), keys[100])
+
+	// A scan that returns the largest key ends instead of starting again
+	reply := client.do("SCAN", strconv.FormatUint(startCursor(math.MaxInt64), 10), "COUNT", "1").([]interface{})
+	assert.Equal(t, "0", reply[0])
+	assert.Equal(t, []interface{}{strconv.Itoa(math.MaxInt64)}, reply[1])
+
+	reply = client.do("SCAN", "0", "MATCH", "4*", "COUNT", "1000").([]interface{})
+	assert.Equal(t, "0", reply[0])
+	assert.Equal(t, []interface{}{"4", "40", "41", "42", "43", "44", "45", "46", "47", "48", "49"}, reply[1])
+}
+
+func TestRESPServer_Pipelining(t *testing.T) {
+	server, client := startRESPServer(t)
+	defer server.Close()
+
+	for key := 0; key < 100; key++ {
+		client.send("SET", strconv.Itoa(key), strconv.Itoa(key))
+	}
+	for key := 0; key < 100; key++ {
+		assert.Equal(t, "OK", client.reply())
+	}
+
+	// Inline commands are accepted as well
+	fmt.Fprint(client.conn, "GET 42\r\n")
+	assert.Equal(t, "42", client.reply())
+}
+
+func TestRESPServer_Close_PersistsStore(t *testing.T) {
+	server, client := startRESPServer(t)
+	assert.Equal(t, "OK", client.do("SET", "7", "seven"))
+
+	path := server.tree.Path
+	assert.Nil(t, server.Close())
+	_, isErr := client.do("PING").(error)
+	assert.True(t, isErr)
+
+	var store kv.BpTreeImpl
+	tree, err := store.Open(path)
+	assert.Nil(t, err)
+	value, err := tree.Get(7)
+	assert.Nil(t, err)
+	assert.Equal(t, "seven", kv.FormatValue(value))
+}